@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsShutdownTimeout bounds how long Serve's stop function waits for in-flight scrapes.
+const metricsShutdownTimeout = 5 * time.Second
+
+// Metrics counts route operations and exposes them in the Prometheus text format.
+// A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	uploaded     atomic.Uint64
+	deleted      atomic.Uint64
+	errors       atomic.Uint64
+	authAttempts atomic.Uint64
+
+	statePath string
+	registry  *prometheus.Registry
+}
+
+// metricsState is the on-disk form of the counters, so totals survive between invocations.
+type metricsState struct {
+	Uploaded     uint64 `json:"uploaded"`
+	Deleted      uint64 `json:"deleted"`
+	Errors       uint64 `json:"errors"`
+	AuthAttempts uint64 `json:"auth_attempts"`
+}
+
+// NewMetrics creates the counters. If statePath is not empty, they start from the totals
+// saved there by SaveState; a missing state file starts them from zero.
+func NewMetrics(statePath string) (*Metrics, error) {
+	m := &Metrics{statePath: statePath, registry: prometheus.NewRegistry()}
+	if statePath != "" {
+		data, err := os.ReadFile(statePath)
+		switch {
+		case err == nil:
+			var st metricsState
+			if err := json.Unmarshal(data, &st); err != nil {
+				return nil, fmt.Errorf("parse metrics state %s: %w", statePath, err)
+			}
+			m.uploaded.Store(st.Uploaded)
+			m.deleted.Store(st.Deleted)
+			m.errors.Store(st.Errors)
+			m.authAttempts.Store(st.AuthAttempts)
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, fmt.Errorf("read metrics state: %w", err)
+		}
+	}
+
+	counters := []struct {
+		name, help string
+		value      *atomic.Uint64
+	}{
+		{"keenetic_routes_uploaded_total", "Number of static routes added to routers.", &m.uploaded},
+		{"keenetic_routes_deleted_total", "Number of static routes removed from routers.", &m.deleted},
+		{"keenetic_routes_errors_total", "Number of failed commands.", &m.errors},
+		{"keenetic_auth_attempts_total", "Number of router login attempts.", &m.authAttempts},
+	}
+	for _, c := range counters {
+		value := c.value
+		m.registry.MustRegister(prometheus.NewCounterFunc(
+			prometheus.CounterOpts{Name: c.name, Help: c.help},
+			func() float64 { return float64(value.Load()) },
+		))
+	}
+	return m, nil
+}
+
+// AddUploaded records n routes added to a router.
+func (m *Metrics) AddUploaded(n int) {
+	if m != nil && n > 0 {
+		m.uploaded.Add(uint64(n))
+	}
+}
+
+// AddDeleted records n routes removed from a router.
+func (m *Metrics) AddDeleted(n int) {
+	if m != nil && n > 0 {
+		m.deleted.Add(uint64(n))
+	}
+}
+
+// RecordError records a failed command.
+func (m *Metrics) RecordError() {
+	if m != nil {
+		m.errors.Add(1)
+	}
+}
+
+// RecordAuthAttempt records a router login attempt.
+func (m *Metrics) RecordAuthAttempt() {
+	if m != nil {
+		m.authAttempts.Add(1)
+	}
+}
+
+// Handler returns the HTTP handler serving the counters.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve starts an HTTP server on addr exposing the counters at /metrics.
+// The returned function shuts the server down.
+func (m *Metrics) Serve(addr string) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}, nil
+}
+
+// SaveState writes the current totals to the state file. It does nothing without one.
+func (m *Metrics) SaveState() error {
+	if m == nil || m.statePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(metricsState{
+		Uploaded:     m.uploaded.Load(),
+		Deleted:      m.deleted.Load(),
+		Errors:       m.errors.Load(),
+		AuthAttempts: m.authAttempts.Load(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal metrics state: %w", err)
+	}
+	if err := os.WriteFile(m.statePath, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write metrics state: %w", err)
+	}
+	return nil
+}
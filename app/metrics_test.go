@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vladpi/keenetic-routes/config"
+	"github.com/vladpi/keenetic-routes/routes"
+)
+
+func scrapeMetrics(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestServiceMetrics(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+      - 2.2.2.2
+`)
+	statePath := filepath.Join(t.TempDir(), "metrics.json")
+	m, err := NewMetrics(statePath)
+	if err != nil {
+		t.Fatalf("NewMetrics: %v", err)
+	}
+	client := &fakeClient{routes: []routes.Route{{Host: "3.3.3.3", Gateway: "10.0.0.1"}}}
+	svc, _ := newTestService(client)
+	svc.SetMetrics(m)
+	if err := svc.Clear(context.Background(), &config.Config{}); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	// A dry run connects without sending a request, so it does not log in.
+	if err := svc.DryRunUpload([]string{file}, &config.Config{}, io.Discard); err != nil {
+		t.Fatalf("DryRunUpload: %v", err)
+	}
+	m.RecordError()
+
+	want := []string{
+		"keenetic_routes_uploaded_total 2",
+		"keenetic_routes_deleted_total 1",
+		"keenetic_routes_errors_total 1",
+		"keenetic_auth_attempts_total 2",
+	}
+	body := scrapeMetrics(t, m)
+	for _, line := range want {
+		if !strings.Contains(body, line) {
+			t.Fatalf("metrics missing %q:\n%s", line, body)
+		}
+	}
+
+	if err := m.SaveState(); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	restored, err := NewMetrics(statePath)
+	if err != nil {
+		t.Fatalf("NewMetrics from state: %v", err)
+	}
+	restored.AddUploaded(3)
+	if body := scrapeMetrics(t, restored); !strings.Contains(body, "keenetic_routes_uploaded_total 5") {
+		t.Fatalf("state not restored:\n%s", body)
+	}
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// webhookClient sends webhook notifications; the timeout keeps a slow receiver
+	// from holding up the command.
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+	// webhookRetryDelay is the pause before the single retry of a failed notification.
+	webhookRetryDelay = time.Second
+)
+
+// webhookPayload is the JSON body posted to the webhook URL.
+type webhookPayload struct {
+	Operation      string    `json:"operation"`
+	Status         string    `json:"status"`
+	RoutesAffected int       `json:"routes_affected"`
+	Error          string    `json:"error,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// webhookNotify posts the outcome of op to url: n routes affected and err if it failed.
+// A failed request is retried once.
+func webhookNotify(url, op string, n int, err error) error {
+	payload := webhookPayload{
+		Operation:      op,
+		Status:         "success",
+		RoutesAffected: n,
+		Timestamp:      time.Now().UTC(),
+	}
+	if err != nil {
+		payload.Status = "error"
+		payload.Error = err.Error()
+	}
+	body, mErr := json.Marshal(payload)
+	if mErr != nil {
+		return fmt.Errorf("marshal webhook payload: %w", mErr)
+	}
+
+	postErr := postWebhook(url, body)
+	if postErr != nil {
+		time.Sleep(webhookRetryDelay)
+		postErr = postWebhook(url, body)
+	}
+	return postErr
+}
+
+func postWebhook(url string, body []byte) error {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post webhook: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notify reports the outcome of op to the webhook set with SetWebhookURL, if any.
+// A webhook failure is printed as a warning and never fails the operation itself.
+func (s *Service) notify(op string, n int, err error) {
+	if s.webhookURL == "" {
+		return
+	}
+	if nErr := webhookNotify(s.webhookURL, op, n, err); nErr != nil {
+		fmt.Fprintf(s.warn, "Warning: webhook notification failed: %v\n", nErr)
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vladpi/keenetic-routes/config"
+	"github.com/vladpi/keenetic-routes/routes"
+)
+
+func TestWebhookNotifyRetriesOnce(t *testing.T) {
+	webhookRetryDelay = 0
+	var mu sync.Mutex
+	var payloads []webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		payloads = append(payloads, p)
+		if len(payloads) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	if err := webhookNotify(server.URL, "clear", 0, errors.New("boom")); err != nil {
+		t.Fatalf("webhookNotify: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(payloads) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(payloads))
+	}
+	p := payloads[1]
+	if p.Operation != "clear" || p.Status != "error" || p.Error != "boom" || p.Timestamp.IsZero() {
+		t.Fatalf("unexpected payload: %+v", p)
+	}
+}
+
+func TestServiceUploadWebhook(t *testing.T) {
+	webhookRetryDelay = 0
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+      - 2.2.2.2
+`)
+
+	var mu sync.Mutex
+	var payloads []webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		payloads = append(payloads, p)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	svc, _ := newTestService(&fakeClient{})
+	svc.SetWebhookURL(server.URL)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(payloads) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(payloads))
+	}
+	if p := payloads[0]; p.Operation != "upload" || p.Status != "success" || p.RoutesAffected != 2 || p.Error != "" {
+		t.Fatalf("unexpected payload: %+v", p)
+	}
+}
+
+func TestServiceWebhookFailureDoesNotFailOperation(t *testing.T) {
+	webhookRetryDelay = 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := &fakeClient{routes: []routes.Route{{Host: "1.1.1.1", Gateway: "10.0.0.1"}}}
+	svc, out := newTestService(client)
+	svc.SetWebhookURL(server.URL)
+	if err := svc.Clear(context.Background(), &config.Config{}); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if !client.deleted {
+		t.Fatalf("expected routes to be cleared")
+	}
+	if !strings.Contains(out.String(), "Warning: webhook notification failed") {
+		t.Fatalf("expected webhook warning, got %q", out.String())
+	}
+}
@@ -2,10 +2,27 @@ package app
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/vladpi/keenetic-routes/config"
 	"github.com/vladpi/keenetic-routes/keenetic"
@@ -14,178 +31,2037 @@ import (
 	"golang.org/x/term"
 )
 
+const (
+	// defaultResolveWorkers is the number of concurrent DNS lookups in ResolveDomains.
+	defaultResolveWorkers = 10
+	// defaultRequestRetries and defaultRetryDelay configure router request retries.
+	defaultRequestRetries = 3
+	defaultRetryDelay     = 500 * time.Millisecond
+)
+
 // RoutesClient is a small interface for route operations used by the app layer.
 type RoutesClient interface {
-	GetRoutes() ([]routes.Route, error)
-	AddRoutes([]routes.Route) error
-	DeleteAllRoutes() error
+	GetRoutes(ctx context.Context) ([]routes.Route, error)
+	GetRoutesCount(ctx context.Context) (int, error)
+	RoutesExist(ctx context.Context, hosts []string) (map[string]bool, error)
+	AddRoutes(ctx context.Context, entries []routes.Route) error
+	DeleteAllRoutes(ctx context.Context) error
+	DeleteRoutes(ctx context.Context, entries []routes.Route) error
+	ReplaceRoutes(ctx context.Context, entries []routes.Route) (added, removed []routes.Route, err error)
+	GetInterfaces(ctx context.Context) ([]string, error)
+	FirmwareVersion(ctx context.Context) (string, error)
+	GetSystemInfo(ctx context.Context) (*keenetic.SystemInfo, error)
+	CompatibilityCheck(ctx context.Context) error
+	DryRunAddRoutes(entries []routes.Route, out io.Writer) error
+	SetAutoSave(enabled bool)
+	SetAuthHook(fn func())
+	SetRequestLog(w io.Writer)
+	SetLogger(l keenetic.Logger)
+}
+
+// Service implements core app operations.
+type Service struct {
+	newClient func(*config.Config) (RoutesClient, error)
+	in        io.Reader
+	out       io.Writer
+	// warn receives warnings; unlike out it is not silenced by SetQuiet.
+	warn io.Writer
+	ipv6 bool
+	// includeDisabled uploads groups marked enabled: false as well.
+	includeDisabled bool
+	// tags limits upload, backup, and clear to groups with at least one of them.
+	tags []string
+	// groupParams holds gateway, interface, and comment for plain-text routes files.
+	groupParams routes.RouteGroup
+	// noSave skips saving the router configuration after route changes.
+	noSave bool
+	// skipVersionCheck disables the firmware version warning before route changes.
+	skipVersionCheck bool
+	// appendMode makes Upload announce that existing routes are left as they are.
+	appendMode bool
+	// aggregate merges contiguous destinations of uploaded routes into fewer CIDRs.
+	aggregate bool
+	// expandEnv expands $VAR references in routes files; off by default, since "$" may be
+	// plain text in existing files.
+	expandEnv bool
+	// allowEmptyVars expands unset environment variables in routes files to empty strings
+	// instead of failing.
+	allowEmptyVars bool
+	// strictNoOverlap makes uploads fail instead of warning about overlapping destinations.
+	strictNoOverlap bool
+	// limit caps how many routes an upload sends; zero means no limit.
+	limit int
+	// commentFilter limits uploads to routes whose group comment matches it when set.
+	commentFilter *regexp.Regexp
+	// metrics counts route operations; nil disables counting.
+	metrics *Metrics
+	// keepBackups is how many routes-backup-*.yaml files Backup keeps; zero keeps all.
+	keepBackups int
+	// color enables ANSI colors in diff and list output.
+	color bool
+	// requestLog receives router HTTP traffic when set.
+	requestLog io.Writer
+	// logger receives a line with the status and duration of every router request when set.
+	logger keenetic.Logger
+	// webhookURL receives the outcome of upload and clear operations when set.
+	webhookURL string
+	// watchEvent, when set, is told when Watch starts watching ("ready") and when it has
+	// handled a change ("handled"). Tests use it instead of sleeping.
+	watchEvent func(event string)
+}
+
+// SetIPv6 allows IPv6 hosts in routes files; they are rejected by default.
+func (s *Service) SetIPv6(enabled bool) {
+	s.ipv6 = enabled
+}
+
+// SetOutput redirects informational output, such as progress and summary lines, to w.
+func (s *Service) SetOutput(w io.Writer) {
+	s.out = w
+}
+
+// SetWarningOutput sends warnings to w instead of the output given to the constructor,
+// e.g. to stderr.
+func (s *Service) SetWarningOutput(w io.Writer) {
+	s.warn = w
+}
+
+// SetQuiet discards informational output such as progress and summary lines.
+// Errors are still returned to the caller, and warnings are still written.
+func (s *Service) SetQuiet(quiet bool) {
+	if quiet {
+		s.out = io.Discard
+	}
+}
+
+// SetKeepBackups makes Backup delete all but the keep most recent routes-backup-*.yaml
+// files next to the one it writes. Zero keeps all of them.
+func (s *Service) SetKeepBackups(keep int) {
+	s.keepBackups = keep
+}
+
+// SetColor enables ANSI colors in diff and list output; it should be on only for terminals.
+func (s *Service) SetColor(enabled bool) {
+	s.color = enabled
+}
+
+// ANSI escape codes used when colors are enabled.
+const (
+	ansiRed   = "\033[31m"
+	ansiGreen = "\033[32m"
+	ansiBold  = "\033[1m"
+	ansiReset = "\033[0m"
+)
+
+// colorize wraps text in the ANSI code if colors are enabled.
+func (s *Service) colorize(code, text string) string {
+	if !s.color {
+		return text
+	}
+	return code + text + ansiReset
+}
+
+// SetLogBodies logs router HTTP requests and response bodies to stderr. It is meant for
+// debugging and is independent of SetLogger.
+func (s *Service) SetLogBodies(enabled bool) {
+	if enabled {
+		s.requestLog = os.Stderr
+	}
+}
+
+// SetLogger logs the method, URL, status code and duration of every router request to l.
+func (s *Service) SetLogger(l keenetic.Logger) {
+	s.logger = l
+}
+
+// SetNoSave makes route changes skip the router configuration save, so they are lost
+// on reboot.
+func (s *Service) SetNoSave(noSave bool) {
+	s.noSave = noSave
+}
+
+// SetSkipVersionCheck disables the warning about firmware older than
+// keenetic.MinSupportedFirmware, which costs an extra router request per operation.
+func (s *Service) SetSkipVersionCheck(skip bool) {
+	s.skipVersionCheck = skip
+}
+
+// checkCompatibility warns when the router firmware is older than the client supports
+// or its version cannot be read. Neither stops the operation.
+func (s *Service) checkCompatibility(ctx context.Context, client RoutesClient) {
+	if s.skipVersionCheck {
+		return
+	}
+	err := client.CompatibilityCheck(ctx)
+	switch {
+	case err == nil:
+	case errors.Is(err, keenetic.ErrUnsupportedFirmware):
+		fmt.Fprintf(s.warn, "Warning: %v.\n", err)
+	default:
+		fmt.Fprintf(s.warn, "Warning: cannot check firmware compatibility: %v.\n", err)
+	}
+}
+
+// SetAppend marks uploads as additive: existing routes are neither checked nor removed.
+// This is what Upload always does; the setting only makes it explicit in the output.
+func (s *Service) SetAppend(enabled bool) {
+	s.appendMode = enabled
+}
+
+// SetAggregate makes uploads merge contiguous destinations with the same parameters into
+// the fewest covering CIDRs (see routes.AggregateRoutes).
+func (s *Service) SetAggregate(enabled bool) {
+	s.aggregate = enabled
+}
+
+// SetExpandEnv makes loaded routes files expand $VAR and ${VAR} references to environment
+// variables (see routes.ExpandEnvVars).
+func (s *Service) SetExpandEnv(enabled bool) {
+	s.expandEnv = enabled
+}
+
+// SetAllowEmptyVars makes unset environment variables referenced by routes files expand
+// to empty strings instead of failing the operation. It applies only with SetExpandEnv.
+func (s *Service) SetAllowEmptyVars(allow bool) {
+	s.allowEmptyVars = allow
+}
+
+// SetStrictNoOverlap makes uploads fail when destinations overlap instead of printing
+// warnings.
+func (s *Service) SetStrictNoOverlap(strict bool) {
+	s.strictNoOverlap = strict
+}
+
+// SetLimit makes uploads send at most n routes, e.g. to try a large file on a few of them.
+// Zero or less removes the limit.
+func (s *Service) SetLimit(n int) {
+	s.limit = n
+}
+
+// SetCommentFilter makes uploads send only the groups whose comment matches re.
+// A nil re uploads all routes.
+func (s *Service) SetCommentFilter(re *regexp.Regexp) {
+	s.commentFilter = re
+}
+
+// applyCommentFilter keeps the groups of rf whose comment matches the comment filter and
+// reports how many did. Groups are matched before flattening, so per-host comments do not
+// affect the result. No match is only a warning: the upload then has nothing to send.
+func (s *Service) applyCommentFilter(rf *routes.RoutesFile) *routes.RoutesFile {
+	if s.commentFilter == nil {
+		return rf
+	}
+	matched := make([]routes.RouteGroup, 0, len(rf.Routes))
+	for _, g := range rf.Routes {
+		if s.commentFilter.MatchString(g.Comment) {
+			matched = append(matched, g)
+		}
+	}
+	fmt.Fprintf(s.out, "Filtering by comment regexp %q: %d of %d groups match.\n", s.commentFilter.String(), len(matched), len(rf.Routes))
+	if len(matched) == 0 && len(rf.Routes) > 0 {
+		fmt.Fprintf(s.warn, "Warning: no group comments match %q.\n", s.commentFilter.String())
+	}
+	return &routes.RoutesFile{Metadata: rf.Metadata, Routes: matched}
+}
+
+// applyLimit truncates entries to the upload limit and says so when routes are left out.
+func (s *Service) applyLimit(entries []routes.Route) []routes.Route {
+	if s.limit <= 0 || len(entries) <= s.limit {
+		return entries
+	}
+	fmt.Fprintf(s.out, "Uploading %d of total %d routes (--limit applied).\n", s.limit, len(entries))
+	return entries[:s.limit]
+}
+
+// SetMetrics makes the service count uploaded and deleted routes and login attempts in m.
+func (s *Service) SetMetrics(m *Metrics) {
+	s.metrics = m
+}
+
+// SetWebhookURL makes upload and clear operations report their outcome to url.
+func (s *Service) SetWebhookURL(url string) {
+	s.webhookURL = url
+}
+
+// SetIncludeDisabled makes uploads include groups marked enabled: false.
+func (s *Service) SetIncludeDisabled(include bool) {
+	s.includeDisabled = include
+}
+
+// SetGroupParams sets the gateway, interface, and comment of plain-text routes files and
+// overrides them on all groups of other files. Empty values leave groups unchanged.
+func (s *Service) SetGroupParams(gateway, iface, comment string) {
+	s.groupParams = routes.RouteGroup{Gateway: gateway, Interface: iface, Comment: comment}
+}
+
+// SetTags limits upload, backup, and clear to route groups with at least one of tags.
+func (s *Service) SetTags(tags []string) {
+	s.tags = tags
+}
+
+// NewService creates a service with default IO and client factory.
+func NewService() *Service {
+	return NewServiceWithClientFactory(nil, nil, nil)
+}
+
+// NewServiceWithClientFactory allows injecting client factory and IO for tests.
+func NewServiceWithClientFactory(factory func(*config.Config) (RoutesClient, error), in io.Reader, out io.Writer) *Service {
+	if factory == nil {
+		factory = defaultClientFactory
+	}
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+	return &Service{newClient: factory, in: in, out: out, warn: out}
+}
+
+func defaultClientFactory(cfg *config.Config) (RoutesClient, error) {
+	var tlsCfg *tls.Config
+	if cfg.Insecure {
+		tlsCfg = &tls.Config{InsecureSkipVerify: true}
+	}
+	baseURL := config.BaseURL(cfg.Host)
+	transport, err := keenetic.NewTransport(baseURL, tlsCfg, cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	client, err := keenetic.NewClientWithHTTPClient(baseURL, cfg.User, cfg.Password, &http.Client{Transport: transport})
+	if err != nil {
+		return nil, err
+	}
+	timeouts, err := timeoutsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	batchSize, err := batchSizeFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.WithRetry(defaultRequestRetries, defaultRetryDelay).WithTimeouts(timeouts)
+	if batchSize > 0 {
+		client.WithBatchSize(batchSize)
+	}
+	client.UseSessionFile(config.GetSessionFilePath())
+	return &keeneticAdapter{client: client}, nil
+}
+
+// batchSizeFromConfig returns cfg.BatchSize, falling back to KEENETIC_BATCH_SIZE.
+// Zero means the client default.
+func batchSizeFromConfig(cfg *config.Config) (int, error) {
+	n := cfg.BatchSize
+	if n == 0 {
+		raw := os.Getenv("KEENETIC_BATCH_SIZE")
+		if raw == "" {
+			return 0, nil
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, fmt.Errorf("invalid KEENETIC_BATCH_SIZE %q: %w", raw, err)
+		}
+		n = v
+	}
+	if err := keenetic.ValidateBatchSize(n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// timeoutsFromEnv reads KEENETIC_TIMEOUT_AUTH, KEENETIC_TIMEOUT_GET, and KEENETIC_TIMEOUT_POST
+// as Go durations (e.g., "30s"). Unset variables keep client defaults.
+func timeoutsFromEnv() (keenetic.Timeouts, error) {
+	var t keenetic.Timeouts
+	for _, v := range []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"KEENETIC_TIMEOUT_AUTH", &t.Auth},
+		{"KEENETIC_TIMEOUT_GET", &t.Get},
+		{"KEENETIC_TIMEOUT_POST", &t.Post},
+	} {
+		raw := os.Getenv(v.env)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return t, fmt.Errorf("invalid %s %q: expected positive duration like 30s", v.env, raw)
+		}
+		*v.dst = d
+	}
+	return t, nil
+}
+
+// saveNote describes whether route changes were saved to the router configuration.
+func (s *Service) saveNote() string {
+	if s.noSave {
+		return " (config not saved)"
+	}
+	return " and saved config"
+}
+
+// connect creates a router client for cfg with the service settings applied.
+func (s *Service) connect(cfg *config.Config) (RoutesClient, error) {
+	client, err := s.newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.SetAutoSave(!s.noSave)
+	client.SetAuthHook(s.metrics.RecordAuthAttempt)
+	if s.requestLog != nil {
+		client.SetRequestLog(s.requestLog)
+	}
+	if s.logger != nil {
+		client.SetLogger(s.logger)
+	}
+	return client, nil
+}
+
+type keeneticAdapter struct {
+	client *keenetic.Client
+}
+
+func (k *keeneticAdapter) GetRoutes(ctx context.Context) ([]routes.Route, error) {
+	defer k.saveSession()
+	return k.client.GetDomainRoutes(ctx)
+}
+
+func (k *keeneticAdapter) GetRoutesCount(ctx context.Context) (int, error) {
+	defer k.saveSession()
+	return k.client.GetRoutesCount(ctx)
+}
+
+func (k *keeneticAdapter) RoutesExist(ctx context.Context, hosts []string) (map[string]bool, error) {
+	defer k.saveSession()
+	return k.client.RoutesExist(ctx, hosts)
+}
+
+func (k *keeneticAdapter) AddRoutes(ctx context.Context, entries []routes.Route) error {
+	defer k.saveSession()
+	return k.client.AddRoutes(ctx, entries)
+}
+
+func (k *keeneticAdapter) DeleteAllRoutes(ctx context.Context) error {
+	defer k.saveSession()
+	return k.client.DeleteAllRoutes(ctx)
+}
+
+func (k *keeneticAdapter) DeleteRoutes(ctx context.Context, entries []routes.Route) error {
+	defer k.saveSession()
+	return k.client.DeleteRoutes(ctx, entries)
+}
+
+func (k *keeneticAdapter) ReplaceRoutes(ctx context.Context, entries []routes.Route) ([]routes.Route, []routes.Route, error) {
+	defer k.saveSession()
+	return k.client.ReplaceRoutes(ctx, entries)
+}
+
+func (k *keeneticAdapter) GetInterfaces(ctx context.Context) ([]string, error) {
+	defer k.saveSession()
+	return k.client.GetInterfaces(ctx)
+}
+
+func (k *keeneticAdapter) FirmwareVersion(ctx context.Context) (string, error) {
+	defer k.saveSession()
+	return k.client.FirmwareVersion(ctx)
+}
+
+func (k *keeneticAdapter) GetSystemInfo(ctx context.Context) (*keenetic.SystemInfo, error) {
+	defer k.saveSession()
+	return k.client.GetSystemInfo(ctx)
+}
+
+func (k *keeneticAdapter) CompatibilityCheck(ctx context.Context) error {
+	defer k.saveSession()
+	return k.client.CompatibilityCheck(ctx)
+}
+
+func (k *keeneticAdapter) SetAutoSave(enabled bool) {
+	k.client.SetAutoSave(enabled)
+}
+
+func (k *keeneticAdapter) SetAuthHook(fn func()) {
+	k.client.WithAuthHook(fn)
+}
+
+func (k *keeneticAdapter) SetRequestLog(w io.Writer) {
+	k.client.WithRequestLog(w)
+}
+
+func (k *keeneticAdapter) SetLogger(l keenetic.Logger) {
+	k.client.WithLogger(l)
+}
+
+func (k *keeneticAdapter) DryRunAddRoutes(entries []routes.Route, out io.Writer) error {
+	return k.client.DryRunAddRoutes(entries, out)
+}
+
+// saveSession persists session cookies so the next invocation can skip auth.
+// Persistence is best-effort: a failure only costs a fresh login next time.
+func (k *keeneticAdapter) saveSession() {
+	_ = k.client.SaveSession()
+}
+
+// Upload parses a YAML file and uploads static routes to the router.
+func (s *Service) Upload(ctx context.Context, files []string, cfg *config.Config) error {
+	return s.upload(ctx, cfg, func() ([]routes.Route, []routes.DuplicateWarning, error) {
+		return s.loadUploadEntries(files...)
+	})
+}
+
+// UploadFromReader is Upload for routes data read from r, e.g. piped to stdin.
+// format selects the parser: yaml, json, or text.
+func (s *Service) UploadFromReader(ctx context.Context, r io.Reader, format string, cfg *config.Config) error {
+	return s.upload(ctx, cfg, func() ([]routes.Route, []routes.DuplicateWarning, error) {
+		rf, err := s.readRoutes(r, format, s.groupParams)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.printMetadata(rf.Metadata)
+		return s.entriesOf(rf)
+	})
+}
+
+// upload adds the routes returned by load to the router and reports the outcome.
+func (s *Service) upload(ctx context.Context, cfg *config.Config, load func() ([]routes.Route, []routes.DuplicateWarning, error)) (err error) {
+	var n int
+	defer func() { s.notify("upload", n, err) }()
+	entries, warnings, err := load()
+	if err != nil {
+		return err
+	}
+	s.printDuplicateWarnings(warnings)
+	if err := s.checkOverlaps(entries); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(s.out, "No entries to upload.")
+		return nil
+	}
+
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	s.checkCompatibility(ctx, client)
+
+	entries = s.applyLimit(entries)
+	if s.appendMode {
+		fmt.Fprintf(s.out, "Appending %d routes (existing routes preserved).\n", len(entries))
+	}
+	if err := client.AddRoutes(ctx, entries); err != nil {
+		return addRoutesError("add routes", withFirmware(ctx, client, err), len(entries))
+	}
+	n = len(entries)
+	s.metrics.AddUploaded(n)
+	fmt.Fprintf(s.out, "Uploaded %d static routes%s.\n", len(entries), s.saveNote())
+	return nil
+}
+
+// DryRunUpload parses a YAML file and writes the RCI payloads that Upload would send to out,
+// without contacting the router.
+func (s *Service) DryRunUpload(files []string, cfg *config.Config, out io.Writer) error {
+	entries, warnings, err := s.loadEntries(files...)
+	if err != nil {
+		return err
+	}
+	s.printDuplicateWarnings(warnings)
+	if len(entries) == 0 {
+		fmt.Fprintln(s.out, "No entries to upload.")
+		return nil
+	}
+
+	entries = s.applyLimit(entries)
+
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	if err := client.DryRunAddRoutes(entries, out); err != nil {
+		return fmt.Errorf("dry run: %w", err)
+	}
+	return nil
+}
+
+// MergeUpload uploads only routes from a YAML file whose destination has no route on the
+// router yet, whatever its gateway or interface.
+func (s *Service) MergeUpload(ctx context.Context, files []string, cfg *config.Config) (err error) {
+	var n int
+	defer func() { s.notify("upload", n, err) }()
+	entries, warnings, err := s.loadUploadEntries(files...)
+	if err != nil {
+		return err
+	}
+	s.printDuplicateWarnings(warnings)
+	if err := s.checkOverlaps(entries); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(s.out, "No entries to upload.")
+		return nil
+	}
+
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	s.checkCompatibility(ctx, client)
+	hosts := make([]string, 0, len(entries))
+	for _, e := range entries {
+		hosts = append(hosts, e.Host)
+	}
+	exists, err := client.RoutesExist(ctx, hosts)
+	if err != nil {
+		return fmt.Errorf("get routes: %w", err)
+	}
+
+	var added []routes.Route
+	for _, e := range entries {
+		if !exists[e.Host] {
+			added = append(added, e)
+		}
+	}
+	skipped := len(entries) - len(added)
+	added = s.applyLimit(added)
+	if len(added) > 0 {
+		if err := client.AddRoutes(ctx, added); err != nil {
+			return addRoutesError("add routes", withFirmware(ctx, client, err), len(added))
+		}
+		n = len(added)
+		s.metrics.AddUploaded(n)
+	}
+	fmt.Fprintf(s.out, "Skipped %d existing, added %d new routes.\n", skipped, len(added))
+	return nil
+}
+
+// UploadToAll uploads routes from a YAML file to every router in cfgs in parallel.
+// The returned slice holds one error (or nil) per config, in the same order.
+func (s *Service) UploadToAll(ctx context.Context, files []string, cfgs []*config.Config) []error {
+	return s.Broadcast(cfgs, func(svc *Service, cfg *config.Config) error {
+		return svc.Upload(ctx, files, cfg)
+	})
+}
+
+// ClearAll clears static routes on every router in cfgs in parallel.
+func (s *Service) ClearAll(ctx context.Context, cfgs []*config.Config) []error {
+	return s.Broadcast(cfgs, func(svc *Service, cfg *config.Config) error {
+		return svc.Clear(ctx, cfg)
+	})
+}
+
+// ClearTaggedAll runs ClearTagged on every router in cfgs in parallel.
+func (s *Service) ClearTaggedAll(ctx context.Context, file string, cfgs []*config.Config) []error {
+	return s.Broadcast(cfgs, func(svc *Service, cfg *config.Config) error {
+		return svc.ClearTagged(ctx, file, cfg)
+	})
+}
+
+// Broadcast runs op for every config in parallel and prints per-host results.
+// Each op gets its own Service whose output and warnings are buffered and printed prefixed with the host.
+func (s *Service) Broadcast(cfgs []*config.Config, op func(svc *Service, cfg *config.Config) error) []error {
+	errs := make([]error, len(cfgs))
+	outputs := make([]bytes.Buffer, len(cfgs))
+	warnings := make([]bytes.Buffer, len(cfgs))
+	var wg sync.WaitGroup
+	for i, cfg := range cfgs {
+		wg.Add(1)
+		go func(i int, cfg *config.Config) {
+			defer wg.Done()
+			svc := *s
+			svc.out = &outputs[i]
+			svc.warn = &warnings[i]
+			errs[i] = op(&svc, cfg)
+		}(i, cfg)
+	}
+	wg.Wait()
+
+	for i, cfg := range cfgs {
+		for _, line := range strings.Split(strings.TrimSpace(warnings[i].String()), "\n") {
+			if line != "" {
+				fmt.Fprintf(s.warn, "[%s] %s\n", cfg.Host, line)
+			}
+		}
+		for _, line := range strings.Split(strings.TrimSpace(outputs[i].String()), "\n") {
+			if line != "" {
+				fmt.Fprintf(s.out, "[%s] %s\n", cfg.Host, line)
+			}
+		}
+		if errs[i] != nil {
+			fmt.Fprintf(s.out, "[%s] FAILED: %v\n", cfg.Host, errs[i])
+		} else {
+			fmt.Fprintf(s.out, "[%s] OK\n", cfg.Host)
+		}
+	}
+	return errs
+}
+
+// DNSOptions selects the resolver used by ResolveDomains.
+type DNSOptions struct {
+	// Server is a plain DNS server address (host or host:port).
+	Server string
+	// DoHURL is a DNS over HTTPS endpoint using the JSON API.
+	DoHURL string
+	// Fallback lists DNS servers or DoH URLs (with a scheme) tried in order when
+	// the primary resolver fails.
+	Fallback []string
+	// NoDefaultResolver drops the system resolver that is otherwise tried last.
+	NoDefaultResolver bool
+}
+
+// newResolver returns the resolver for opts. If Server and DoHURL are empty, KEENETIC_DNS_SERVER
+// and KEENETIC_DNS_DOH_URL are used; if those are unset too, the system resolver is primary.
+// Fallback resolvers follow the primary one, and the system resolver comes last unless disabled.
+func newResolver(opts DNSOptions) (routes.IPResolver, error) {
+	if opts.Server == "" && opts.DoHURL == "" {
+		opts.Server = os.Getenv("KEENETIC_DNS_SERVER")
+		opts.DoHURL = os.Getenv("KEENETIC_DNS_DOH_URL")
+	}
+	var chain []routes.IPResolver
+	switch {
+	case opts.Server != "" && opts.DoHURL != "":
+		return nil, fmt.Errorf("use either a DNS server or a DoH URL, not both")
+	case opts.DoHURL != "":
+		chain = append(chain, routes.NewDoHResolver(opts.DoHURL))
+	case opts.Server != "":
+		chain = append(chain, routes.NewCustomResolver(opts.Server))
+	}
+	for _, addr := range opts.Fallback {
+		if strings.Contains(addr, "://") {
+			chain = append(chain, routes.NewDoHResolver(addr))
+		} else {
+			chain = append(chain, routes.NewCustomResolver(addr))
+		}
+	}
+	if !opts.NoDefaultResolver {
+		chain = append(chain, net.DefaultResolver)
+	}
+	switch len(chain) {
+	case 0:
+		return nil, fmt.Errorf("no DNS resolvers left with the system resolver disabled")
+	case 1:
+		return chain[0], nil
+	}
+	return routes.NewFallbackResolver(chain...), nil
+}
+
+// ResolveDomainsOptions configures ResolveDomains.
+type ResolveDomainsOptions struct {
+	DNS DNSOptions
+	// UnresolvedOutput is a file for groups with domains that failed to resolve.
+	UnresolvedOutput string
+	// DryRun prints the IPs that would be added without writing any file.
+	DryRun bool
+	// Workers is the number of concurrent lookups; zero means defaultResolveWorkers.
+	Workers int
+}
+
+// ResolveDomains resolves route group domains and merges IPv4 results into hosts
+// using the resolver selected by opts.DNS. If opts.UnresolvedOutput is set, domains that
+// failed to resolve are saved there with their groups for a later retry.
+func (s *Service) ResolveDomains(file string, opts ResolveDomainsOptions) error {
+	if file == "" {
+		return fmt.Errorf("file path is required")
+	}
+	if opts.Workers < 0 {
+		return fmt.Errorf("parallel must be positive, got %d", opts.Workers)
+	}
+	workers := opts.Workers
+	if workers == 0 {
+		workers = defaultResolveWorkers
+	}
+	rf, err := s.loadRewritable(file)
+	if err != nil {
+		return err
+	}
+	resolver, err := newResolver(opts.DNS)
+	if err != nil {
+		return err
+	}
+	before := make([]map[string]bool, len(rf.Routes))
+	for i, g := range rf.Routes {
+		before[i] = make(map[string]bool, len(g.Hosts))
+		for _, h := range g.Hosts {
+			before[i][strings.TrimSpace(h.Host)] = true
+		}
+	}
+	summary, err := routes.ResolveDomainsWithResolverConcurrent(rf, resolver, workers)
+	var resolveErr *routes.ResolveError
+	if err != nil && !errors.As(err, &resolveErr) {
+		return err
+	}
+	if summary.Groups == 0 {
+		fmt.Fprintln(s.out, "No domains to resolve.")
+		return nil
+	}
+	resolved := summary.Domains - len(summary.Errors)
+	if opts.DryRun {
+		for i, g := range rf.Routes {
+			for _, h := range g.Hosts {
+				if !before[i][h.Host] {
+					fmt.Fprintf(s.out, "+ %s (group %s)\n", h.Host, groupName(g, i))
+				}
+			}
+		}
+		fmt.Fprintf(s.out, "Dry run: resolved %d domains in %d groups, would add %d IPs.\n", resolved, summary.Groups, summary.IPsAdded)
+		return err
+	}
+	if resolved > 0 {
+		if err := routes.SaveYAML(file, rf); err != nil {
+			return fmt.Errorf("save YAML: %w", err)
+		}
+	}
+	if opts.UnresolvedOutput != "" && len(summary.Errors) > 0 {
+		if err := routes.SaveUnresolved(opts.UnresolvedOutput, rf, summary.Errors); err != nil {
+			return fmt.Errorf("save unresolved domains: %w", err)
+		}
+		fmt.Fprintf(s.out, "Saved %d unresolved domains to %s\n", len(summary.Errors), opts.UnresolvedOutput)
+	}
+	fmt.Fprintf(s.out, "Resolved %d domains in %d groups, added %d IPs.\n", resolved, summary.Groups, summary.IPsAdded)
+	return err
+}
+
+// groupName returns the quoted comment of group g at index i, or its 1-based number.
+func groupName(g routes.RouteGroup, i int) string {
+	if g.Comment != "" {
+		return strconv.Quote(g.Comment)
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// Backup downloads routes and saves them to output in format yaml, json, csv, or text
+// (see writeBackup). YAML backups keep the tags of the file they overwrite.
+func (s *Service) Backup(ctx context.Context, output, format string, cfg *config.Config) error {
+	if output == "" {
+		return fmt.Errorf("output path is required")
+	}
+	if err := checkBackupFormat(format); err != nil {
+		return err
+	}
+	if format != "" && format != "yaml" && len(s.tags) > 0 {
+		return fmt.Errorf("tags are not supported with %s format", format)
+	}
+
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	routesList, err := client.GetRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("get routes: %w", err)
+	}
+
+	rf := routes.ToYAML(routesList)
+	n := len(routesList)
+	if format == "" || format == "yaml" {
+		// The router does not store tags: they are copied from the file being overwritten,
+		// and --tags selects groups by these copied tags.
+		existing, err := routes.LoadYAML(output)
+		if err != nil {
+			fmt.Fprintf(s.warn, "Warning: cannot read tags from existing %s (%v); overwriting it.\n", output, err)
+			existing = nil
+		}
+		routes.CopyTags(rf, existing)
+		rf = routes.FilterByTags(rf, s.tags)
+		n = 0
+		for _, g := range rf.Routes {
+			n += len(g.Hosts)
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeBackup(&buf, format, routesList, rf, cfg.Host); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("backup: create output directory: %w", err)
+	}
+	if err := routes.WriteFileAtomic(output, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	fmt.Fprintf(s.out, "Backed up %d routes to %s\n", n, output)
+	if pattern, ok := backupRotationPattern(filepath.Base(output)); ok {
+		if err := rotateBackups(filepath.Dir(output), pattern, s.keepBackups); err != nil {
+			return fmt.Errorf("rotate backups: %w", err)
+		}
+	}
+	return nil
+}
+
+// BackupToWriter writes the router routes to w in format yaml, json, csv, or text, e.g. to
+// pipe a backup to another program. Unlike Backup it prints nothing else, so w may be stdout.
+func (s *Service) BackupToWriter(ctx context.Context, w io.Writer, format string, cfg *config.Config) error {
+	if err := checkBackupFormat(format); err != nil {
+		return err
+	}
+	if len(s.tags) > 0 {
+		return fmt.Errorf("tags are taken from an existing backup file and are not supported when writing to a stream")
+	}
+
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	routesList, err := client.GetRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("get routes: %w", err)
+	}
+	return writeBackup(w, format, routesList, routes.ToYAML(routesList), cfg.Host)
+}
+
+// checkBackupFormat returns an error unless format is one writeBackup supports.
+func checkBackupFormat(format string) error {
+	switch format {
+	case "", "yaml", "json", "csv", "text":
+		return nil
+	}
+	return fmt.Errorf("unsupported format %q (use yaml, json, csv, or text)", format)
+}
+
+// writeBackup writes the routes fetched from host to w. YAML and JSON backups write rf, the
+// grouped form of list, sorted and with backup metadata. CSV and text backups write list
+// as it is, sorted by destination: a route this tool would reject in a routes file must
+// still be backed up.
+func writeBackup(w io.Writer, format string, list []routes.Route, rf *routes.RoutesFile, host string) error {
+	switch format {
+	case "csv":
+		return writeRoutesCSV(w, sortedByHost(list), true)
+	case "text":
+		_, err := io.WriteString(w, routes.ToText(sortedByHost(list)))
+		return err
+	}
+	routes.SortFile(rf)
+	if format == "json" {
+		return routes.WriteJSON(w, rf)
+	}
+	rf.Metadata = &routes.Metadata{Description: "backup from " + host}
+	return routes.WriteYAML(w, rf)
+}
+
+// sortedByHost returns a copy of list sorted by destination, for stable backups.
+func sortedByHost(list []routes.Route) []routes.Route {
+	sorted := append([]routes.Route(nil), list...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Host < sorted[j].Host })
+	return sorted
+}
+
+// backupRotationPattern returns the glob matching earlier backups of the same kind as name,
+// e.g. routes-backup-[0-9]...[0-9].yaml (14 digits) for routes-backup-20240115120000.yaml.
+// Per-host backups only match backups of the same host. ok is false for names that do not
+// follow the routes-backup-<timestamp>.yaml scheme, which are never rotated.
+func backupRotationPattern(name string) (pattern string, ok bool) {
+	base, isYAML := strings.CutSuffix(name, ".yaml")
+	if !isYAML || !strings.HasPrefix(base, autoBackupPrefix) || len(base) < len(autoBackupPrefix)+14 {
+		return "", false
+	}
+	prefix, stamp := base[:len(base)-14], base[len(base)-14:]
+	if strings.Trim(stamp, "0123456789") != "" {
+		return "", false
+	}
+	if prefix != autoBackupPrefix && !strings.HasSuffix(prefix, "-") {
+		return "", false
+	}
+	escape := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`)
+	return escape.Replace(prefix) + strings.Repeat("[0-9]", 14) + ".yaml", true
+}
+
+// rotateBackups deletes files in dir matching pattern except the keep most recently
+// modified ones. A keep of zero or less keeps all files.
+func rotateBackups(dir, pattern string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return err
+	}
+	if len(matches) <= keep {
+		return nil
+	}
+	modTimes := make(map[string]time.Time, len(matches))
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			return err
+		}
+		modTimes[m] = info.ModTime()
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		ti, tj := modTimes[matches[i]], modTimes[matches[j]]
+		if ti.Equal(tj) {
+			return matches[i] > matches[j]
+		}
+		return ti.After(tj)
+	})
+	for _, m := range matches[keep:] {
+		if err := os.Remove(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// autoBackupPrefix starts the names of files written by AutoBackup.
+const autoBackupPrefix = "routes-backup-"
+
+// AutoBackup saves the routes of every router in cfgs to routes-backup-YYYYMMDDHHMMSS.yaml
+// in dir (the current directory if empty) ahead of a destructive operation. With several
+// routers the host is added to the file name so the backups do not overwrite each other.
+func (s *Service) AutoBackup(ctx context.Context, dir string, cfgs []*config.Config) error {
+	stamp := time.Now().Format("20060102150405")
+	for _, cfg := range cfgs {
+		name := autoBackupPrefix + stamp + ".yaml"
+		if len(cfgs) > 1 {
+			host := strings.NewReplacer(":", "_", "/", "_").Replace(config.HostAddr(cfg.Host))
+			name = autoBackupPrefix + host + "-" + stamp + ".yaml"
+		}
+		path := filepath.Join(dir, name)
+		// The backup holds every route, whatever tags the operation itself is limited to.
+		svc := *s
+		svc.tags = nil
+		svc.out = io.Discard
+		if err := svc.Backup(ctx, path, "yaml", cfg); err != nil {
+			return fmt.Errorf("auto-backup: %w", err)
+		}
+		fmt.Fprintf(s.out, "Auto-backup saved to %s.\n", path)
+	}
+	return nil
+}
+
+// ClearTagged removes from the router the routes of file groups matching the tags set with SetTags.
+func (s *Service) ClearTagged(ctx context.Context, file string, cfg *config.Config) (err error) {
+	var n int
+	defer func() { s.notify("clear", n, err) }()
+	if len(s.tags) == 0 {
+		return fmt.Errorf("tags are required")
+	}
+	entries, warnings, err := s.loadEntries(file)
+	if err != nil {
+		return err
+	}
+	s.printDuplicateWarnings(warnings)
+
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	s.checkCompatibility(ctx, client)
+	current, err := client.GetRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("get routes: %w", err)
+	}
+	missing, _ := routes.DiffRoutes(entries, current)
+	present, _ := routes.DiffRoutes(entries, missing)
+	if len(present) > 0 {
+		if err := client.DeleteRoutes(ctx, present); err != nil {
+			return fmt.Errorf("clear routes: %w", err)
+		}
+		n = len(present)
+		s.metrics.AddDeleted(n)
+	}
+	fmt.Fprintf(s.out, "Removed %d tagged routes%s.\n", len(present), s.saveNote())
+	return nil
+}
+
+// loadRewritable loads a YAML routes file that a command is about to rewrite in place.
+// Files with !include directives are refused: saving them would inline the included groups.
+func (s *Service) loadRewritable(file string) (*routes.RoutesFile, error) {
+	if _, err := os.Stat(file); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("routes file not found: %s", file)
+		}
+		return nil, fmt.Errorf("stat routes file: %w", err)
+	}
+	rf, err := routes.LoadYAML(file)
+	if err != nil {
+		return nil, fmt.Errorf("load YAML: %w", err)
+	}
+	if len(rf.Includes) > 0 {
+		return nil, fmt.Errorf("%s includes other files (%s); run the command on the included files instead", file, strings.Join(rf.Includes, ", "))
+	}
+	return rf, nil
+}
+
+// Normalize sorts groups and hosts of a YAML routes file and drops repeated hosts,
+// rewriting the file in place so that diffs stay small.
+func (s *Service) Normalize(file string) error {
+	rf, err := s.loadRewritable(file)
+	if err != nil {
+		return err
+	}
+	removed := routes.DedupeHosts(rf)
+	if removed == 0 && routes.IsSorted(rf) {
+		// Leave the file untouched: saving would drop its comments and formatting.
+		fmt.Fprintf(s.out, "%s is already normalized.\n", file)
+		return nil
+	}
+	routes.SortFile(rf)
+	if err := routes.SaveYAML(file, rf); err != nil {
+		return fmt.Errorf("save YAML: %w", err)
+	}
+	fmt.Fprintf(s.out, "Normalized %s (%d duplicate hosts removed).\n", file, removed)
+	return nil
+}
+
+// exampleRoutesYAML is the routes file written by Generate. It must stay valid for Lint.
+const exampleRoutesYAML = `# Routes file for keenetic-routes.
+# Upload it with: keenetic-routes upload -f routes.yaml
+
+# Optional information about the file; created_at is filled in when the file is saved.
+# metadata:
+#   version: v1
+#   description: "my routes"
+
+routes:
+  # Hosts sent through another router or VPN gateway on the local network.
+  - comment: "Example via gateway"
+    gateway: 192.168.1.1
+    # auto: true       # add the route automatically when the gateway is reachable
+    # reject: false    # drop packets instead of routing them
+    # enabled: false   # keep the group in the file but skip it on upload
+    # tags: [vpn]      # select groups with --tags vpn
+    hosts:
+      - 8.8.8.8
+      - 1.1.1.0/24
+      # - 10.0.0.1-10.0.0.20                    # IPv4 range, up to 256 addresses
+      # - {host: 9.9.9.9, comment: "Quad9 DNS"} # per-host comment
+
+  # Hosts sent through a router interface, e.g. a Wireguard tunnel.
+  - comment: "Example via interface"
+    interface: Wireguard0
+    auto: true
+    hosts:
+      - 142.250.0.0/15
+    # Domains are resolved to IPv4 and added to hosts by resolve-domains.
+    # domains:
+    #   - example.com
+`
+
+// Generate writes an example routes file with the schema explained in comments to path.
+// If the file exists, it asks before overwriting it.
+func (s *Service) Generate(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		ok, err := s.confirm(fmt.Sprintf("File %s exists. Overwrite?", path))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(s.out, "Aborted.")
+			return nil
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("stat routes file: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(exampleRoutesYAML), 0644); err != nil {
+		return fmt.Errorf("write routes file: %w", err)
+	}
+	fmt.Fprintf(s.out, "Example routes file written to %s\n", path)
+	return nil
+}
+
+// Fix corrects common issues of a YAML routes file in place (see routes.FixFile) and prints
+// what was changed. With dryRun the changes are only printed and the file is left as is.
+func (s *Service) Fix(file string, dryRun bool) error {
+	rf, err := s.loadRewritable(file)
+	if err != nil {
+		return err
+	}
+	summary := routes.FixFile(rf)
+	if summary == (routes.FixSummary{}) {
+		// Leave the file untouched: saving would drop its comments and formatting.
+		fmt.Fprintln(s.out, summary)
+		return nil
+	}
+	if dryRun {
+		fmt.Fprintf(s.out, "%s (dry run, %s not changed)\n", summary, file)
+		return nil
+	}
+	if err := routes.SaveYAML(file, rf); err != nil {
+		return fmt.Errorf("save YAML: %w", err)
+	}
+	fmt.Fprintln(s.out, summary)
+	return nil
+}
+
+// Aggregate merges contiguous hosts of each group of a YAML routes file into the fewest
+// covering CIDRs and saves the file. With dryRun only the resulting host count is printed.
+func (s *Service) Aggregate(file string, dryRun bool) error {
+	rf, err := s.loadRewritable(file)
+	if err != nil {
+		return err
+	}
+	before, after := routes.AggregateFile(rf)
+	if before == after {
+		// Leave the file untouched: saving would drop its comments and formatting.
+		fmt.Fprintf(s.out, "Nothing to aggregate in %s (%d hosts).\n", file, before)
+		return nil
+	}
+	if dryRun {
+		fmt.Fprintf(s.out, "Would aggregate %d hosts into %d (dry run, %s not changed).\n", before, after, file)
+		return nil
+	}
+	if err := routes.SaveYAML(file, rf); err != nil {
+		return fmt.Errorf("save YAML: %w", err)
+	}
+	fmt.Fprintf(s.out, "Aggregated %d hosts into %d in %s.\n", before, after, file)
+	return nil
+}
+
+// Lint validates a YAML routes file without connecting to the router and prints every
+// problem found. Warnings alone do not fail; any error makes Lint return an error.
+func (s *Service) Lint(file string) error {
+	if _, err := os.Stat(file); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("routes file not found: %s", file)
+		}
+		return fmt.Errorf("stat routes file: %w", err)
+	}
+	rf, err := routes.LoadYAML(file)
+	if err != nil {
+		return fmt.Errorf("load YAML: %w", err)
+	}
+	issues := routes.Lint(rf)
+	if !s.ipv6 {
+		issues = append(issues, routes.IPv6Issues(rf)...)
+	}
+	var errCount, warnCount int
+	for _, issue := range issues {
+		if issue.Severity == routes.SeverityError {
+			errCount++
+		} else {
+			warnCount++
+		}
+		fmt.Fprintf(s.out, "%s: %s\n", file, issue)
+	}
+	if errCount > 0 {
+		return fmt.Errorf("%s: %d error(s), %d warning(s)", file, errCount, warnCount)
+	}
+	fmt.Fprintf(s.out, "%s: OK (%d warning(s)).\n", file, warnCount)
+	return nil
+}
+
+// Clear removes all static routes from the router and saves config.
+func (s *Service) Clear(ctx context.Context, cfg *config.Config) (err error) {
+	var n int
+	defer func() { s.notify("clear", n, err) }()
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	s.checkCompatibility(ctx, client)
+
+	// Only look up how many routes are removed when someone is counting or notified.
+	var count int
+	if s.metrics != nil || s.webhookURL != "" {
+		if count, err = client.GetRoutesCount(ctx); err != nil {
+			return fmt.Errorf("count routes: %w", err)
+		}
+	}
+	if err := client.DeleteAllRoutes(ctx); err != nil {
+		return fmt.Errorf("clear routes: %w", err)
+	}
+	n = count
+	s.metrics.AddDeleted(n)
+	fmt.Fprintf(s.out, "Static routes cleared%s.\n", s.saveNote())
+	return nil
+}
+
+// ListInterfaces prints router interface names, one per line.
+func (s *Service) ListInterfaces(ctx context.Context, cfg *config.Config) error {
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	names, err := client.GetInterfaces(ctx)
+	if err != nil {
+		return fmt.Errorf("get interfaces: %w", err)
+	}
+	for _, name := range names {
+		fmt.Fprintln(s.out, name)
+	}
+	return nil
+}
+
+// Check verifies that the router is reachable and accepts the credentials, and prints
+// its firmware version, model, uptime and the round-trip latency. A positive timeout
+// bounds the whole probe.
+func (s *Service) Check(ctx context.Context, cfg *config.Config, timeout time.Duration) error {
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Only the version request is timed, so the latency is that of one round trip.
+	start := time.Now()
+	version, err := client.FirmwareVersion(ctx)
+	latency := time.Since(start).Milliseconds()
+	if err != nil {
+		if timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("check connection: %s did not respond within %s", cfg.Host, timeout)
+		}
+		return fmt.Errorf("check connection (after %dms): %w", latency, err)
+	}
+	// Model and uptime are informational only, as in List: a firmware that cannot report
+	// them does not fail the check.
+	info := &keenetic.SystemInfo{FirmwareVersion: version}
+	if sys, err := client.GetSystemInfo(ctx); err == nil {
+		info.Model, info.Uptime = sys.Model, sys.Uptime
+	}
+	fmt.Fprintf(s.out, "Connection OK: host=%s firmware=%s model=%s uptime=%s latency=%dms\n",
+		cfg.Host, orUnknown(info.FirmwareVersion), orUnknown(info.Model), formatUptime(info.Uptime), latency)
+	if err := keenetic.CheckFirmware(info.FirmwareVersion); err != nil && !s.skipVersionCheck {
+		fmt.Fprintf(s.warn, "Warning: %v.\n", err)
+	}
+	return nil
+}
+
+// Diff compares routes from a YAML file against live router routes.
+// added are routes only in the file; removed are routes only on the router.
+func (s *Service) Diff(ctx context.Context, file string, cfg *config.Config) (added, removed []routes.Route, err error) {
+	entries, warnings, err := s.loadEntries(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.printDuplicateWarnings(warnings)
+
+	client, err := s.connect(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := client.GetRoutes(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get routes: %w", err)
+	}
+
+	added, removed = routes.DiffRoutes(entries, current)
+	return added, removed, nil
+}
+
+// ShowDiff prints the difference between a YAML file and live router routes.
+func (s *Service) ShowDiff(ctx context.Context, file string, cfg *config.Config) error {
+	added, removed, err := s.Diff(ctx, file, cfg)
+	if err != nil {
+		return err
+	}
+	s.writeDiff(added, removed)
+	return nil
+}
+
+// Apply makes the router routes match a routes file: routes missing from the file are
+// deleted and routes missing on the router are added, while routes in both are left alone.
+// Running it again with the same file changes nothing.
+func (s *Service) Apply(ctx context.Context, file string, cfg *config.Config) (err error) {
+	var n int
+	defer func() { s.notify("apply", n, err) }()
+	entries, warnings, err := s.loadEntries(file)
+	if err != nil {
+		return err
+	}
+	s.printDuplicateWarnings(warnings)
+
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	s.checkCompatibility(ctx, client)
+	added, removed, err := client.ReplaceRoutes(ctx, entries)
+	if err != nil {
+		return fmt.Errorf("apply routes: %w", withFirmware(ctx, client, err))
+	}
+	n = len(added) + len(removed)
+	s.metrics.AddUploaded(len(added))
+	s.metrics.AddDeleted(len(removed))
+	fmt.Fprintf(s.out, "Applied: +%d added, -%d removed, %d unchanged.\n", len(added), len(removed), len(entries)-len(added))
+	return nil
+}
+
+// DiffFiles prints the difference between two routes files without contacting the router:
+// routes only in fileB with "+", routes only in fileA with "-", then a summary line.
+func (s *Service) DiffFiles(fileA, fileB string) error {
+	a, err := s.readRoutesFile(fileA, s.groupParams)
+	if err != nil {
+		return err
+	}
+	b, err := s.readRoutesFile(fileB, s.groupParams)
+	if err != nil {
+		return err
+	}
+	added, removed, err := routes.DiffFiles(a, b)
+	if err != nil {
+		return err
+	}
+	if s.writeDiff(added, removed) {
+		fmt.Fprintf(s.out, "%d added, %d removed.\n", len(added), len(removed))
+	}
+	return nil
+}
+
+// writeDiff prints added and removed routes, or "No differences.", and reports whether
+// there were any differences.
+func (s *Service) writeDiff(added, removed []routes.Route) bool {
+	if len(added) == 0 && len(removed) == 0 {
+		fmt.Fprintln(s.out, "No differences.")
+		return false
+	}
+	for _, r := range added {
+		fmt.Fprintln(s.out, s.colorize(ansiGreen, "+ "+formatRoute(r)))
+	}
+	for _, r := range removed {
+		fmt.Fprintln(s.out, s.colorize(ansiRed, "- "+formatRoute(r)))
+	}
+	return true
+}
+
+// formatRoute renders a route as "host via gateway" or "host dev interface", with the comment if any.
+func formatRoute(r routes.Route) string {
+	line := r.Host
+	if r.Gateway != "" {
+		line += " via " + r.Gateway
+	}
+	if r.Interface != "" {
+		line += " dev " + r.Interface
+	}
+	if r.Comment != "" {
+		line += fmt.Sprintf(" (%s)", r.Comment)
+	}
+	return line
+}
+
+// loadEntries reads files, merging them with routes.MergeFiles when there are several,
+// and flattens the result using the service settings.
+// IPv6 hosts are rejected unless SetIPv6 enabled them.
+func (s *Service) loadEntries(files ...string) ([]routes.Route, []routes.DuplicateWarning, error) {
+	loaded, err := s.loadFiles(files...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.entriesOf(loaded...)
+}
+
+// loadUploadEntries is loadEntries for uploads: it also prints the metadata of each file.
+func (s *Service) loadUploadEntries(files ...string) ([]routes.Route, []routes.DuplicateWarning, error) {
+	loaded, err := s.loadFiles(files...)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, rf := range loaded {
+		s.printMetadata(rf.Metadata)
+	}
+	return s.entriesOf(loaded...)
+}
+
+// loadFiles reads routes files with readRoutesFile.
+func (s *Service) loadFiles(files ...string) ([]*routes.RoutesFile, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("file path is required")
+	}
+	loaded := make([]*routes.RoutesFile, 0, len(files))
+	for _, file := range files {
+		rf, err := s.readRoutesFile(file, s.groupParams)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, rf)
+	}
+	return loaded, nil
+}
+
+// entriesOf flattens loaded routes files into routes to upload, applying the group
+// parameter overrides, comment filter, tags, and the IPv6 setting of the service.
+func (s *Service) entriesOf(loaded ...*routes.RoutesFile) ([]routes.Route, []routes.DuplicateWarning, error) {
+	for i, rf := range loaded {
+		if s.expandEnv {
+			expanded, err := routes.ExpandEnvVars(rf, s.allowEmptyVars)
+			if err != nil {
+				return nil, nil, err
+			}
+			rf = expanded
+		}
+		overrideGroupParams(rf, s.groupParams)
+		loaded[i] = rf
+	}
+	rf := loaded[0]
+	if len(loaded) > 1 {
+		rf = routes.MergeFiles(loaded...)
+	}
+	rf = s.applyCommentFilter(rf)
+	entries, warnings, err := flattenRoutesFile(rf, s.includeDisabled, s.tags)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.ipv6 {
+		for _, e := range entries {
+			if routes.IsIPv6(e.Host) {
+				return nil, nil, fmt.Errorf("IPv6 host %s requires --ipv6", e.Host)
+			}
+			if routes.IsIPv6(e.Gateway) {
+				return nil, nil, fmt.Errorf("IPv6 gateway %s requires --ipv6", e.Gateway)
+			}
+		}
+	}
+	if s.aggregate {
+		before := len(entries)
+		entries = routes.AggregateRoutes(entries)
+		fmt.Fprintf(s.out, "Aggregated %d routes into %d.\n", before, len(entries))
+	}
+	return entries, warnings, nil
+}
+
+// printMetadata prints the version, creation date, and description of a loaded file, if any.
+func (s *Service) printMetadata(m *routes.Metadata) {
+	if m == nil {
+		return
+	}
+	var parts []string
+	if m.Version != "" {
+		parts = append(parts, m.Version)
+	}
+	if !m.CreatedAt.IsZero() {
+		parts = append(parts, "created "+m.CreatedAt.Format("2006-01-02"))
+	}
+	if m.Description != "" {
+		parts = append(parts, "("+m.Description+")")
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(s.out, "Loading routes %s\n", strings.Join(parts, " "))
+	}
 }
 
-// Service implements core app operations.
-type Service struct {
-	newClient func(*config.Config) (RoutesClient, error)
-	in        io.Reader
-	out       io.Writer
+// overrideGroupParams sets the non-empty gateway, interface, and comment of params on all
+// groups of rf. A gateway replaces the interface of a group and vice versa.
+func overrideGroupParams(rf *routes.RoutesFile, params routes.RouteGroup) {
+	for i := range rf.Routes {
+		g := &rf.Routes[i]
+		if params.Gateway != "" {
+			g.Gateway, g.Interface = params.Gateway, ""
+		}
+		if params.Interface != "" {
+			g.Interface, g.Gateway = params.Interface, ""
+		}
+		if params.Comment != "" {
+			g.Comment = params.Comment
+		}
+	}
 }
 
-// NewService creates a service with default IO and client factory.
-func NewService() *Service {
-	return NewServiceWithClientFactory(nil, nil, nil)
+func (s *Service) printDuplicateWarnings(warnings []routes.DuplicateWarning) {
+	for _, w := range warnings {
+		fmt.Fprintf(s.warn, "Warning: %s\n", w)
+	}
 }
 
-// NewServiceWithClientFactory allows injecting client factory and IO for tests.
-func NewServiceWithClientFactory(factory func(*config.Config) (RoutesClient, error), in io.Reader, out io.Writer) *Service {
-	if factory == nil {
-		factory = defaultClientFactory
-	}
-	if in == nil {
-		in = os.Stdin
+// checkOverlaps prints a warning for every pair of entries with overlapping destinations,
+// or returns an error if strict no-overlap mode is on.
+func (s *Service) checkOverlaps(entries []routes.Route) error {
+	overlaps := routes.DetectOverlaps(entries)
+	for _, w := range overlaps {
+		fmt.Fprintf(s.warn, "Warning: %s\n", w)
 	}
-	if out == nil {
-		out = os.Stdout
+	if s.strictNoOverlap && len(overlaps) > 0 {
+		return fmt.Errorf("%d overlapping routes (--strict-no-overlap)", len(overlaps))
 	}
-	return &Service{newClient: factory, in: in, out: out}
+	return nil
 }
 
-func defaultClientFactory(cfg *config.Config) (RoutesClient, error) {
-	baseURL := "http://" + cfg.Host
-	client, err := keenetic.NewClient(baseURL, cfg.User, cfg.Password)
+// readRoutesFile loads file with loadRoutesFile and prints the warnings of parsing it.
+func (s *Service) readRoutesFile(file string, params routes.RouteGroup) (*routes.RoutesFile, error) {
+	rf, err := loadRoutesFile(file, params)
 	if err != nil {
 		return nil, err
 	}
-	return &keeneticAdapter{client: client}, nil
-}
-
-type keeneticAdapter struct {
-	client *keenetic.Client
-}
-
-func (k *keeneticAdapter) GetRoutes() ([]routes.Route, error) {
-	return k.client.GetDomainRoutes()
+	s.printParseWarnings(file, rf)
+	return rf, nil
 }
 
-func (k *keeneticAdapter) AddRoutes(entries []routes.Route) error {
-	return k.client.AddRoutes(entries)
+// readRoutes parses routes data from r with parseRoutes and prints the warnings of parsing it.
+func (s *Service) readRoutes(r io.Reader, format string, params routes.RouteGroup) (*routes.RoutesFile, error) {
+	rf, err := parseRoutes(r, format, params)
+	if err != nil {
+		return nil, err
+	}
+	s.printParseWarnings("input", rf)
+	return rf, nil
 }
 
-func (k *keeneticAdapter) DeleteAllRoutes() error {
-	return k.client.DeleteAllRoutes()
+// printParseWarnings prints the warnings routes gave while parsing rf from source.
+func (s *Service) printParseWarnings(source string, rf *routes.RoutesFile) {
+	for _, w := range rf.Warnings {
+		fmt.Fprintf(s.warn, "Warning: %s: %s.\n", source, w)
+	}
 }
 
-// Upload parses a YAML file and uploads static routes to the router.
-func (s *Service) Upload(file string, cfg *config.Config) error {
+// loadRoutesFile checks that file exists, then loads it. Files with a .csv or .json extension
+// are read with routes.LoadCSV or routes.LoadJSON. Plain-text files (one IP or CIDR
+// per line) are wrapped in a single group with the gateway, interface, and comment of params.
+func loadRoutesFile(file string, params routes.RouteGroup) (*routes.RoutesFile, error) {
 	if file == "" {
-		return fmt.Errorf("file path is required")
+		return nil, fmt.Errorf("file path is required")
 	}
 	if _, err := os.Stat(file); err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("routes file not found: %s", file)
+			return nil, fmt.Errorf("routes file not found: %s", file)
 		}
-		return fmt.Errorf("stat routes file: %w", err)
+		return nil, fmt.Errorf("stat routes file: %w", err)
 	}
-
-	client, err := s.newClient(cfg)
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		rf, err := routes.LoadCSV(file)
+		if err != nil {
+			return nil, fmt.Errorf("load CSV: %w", err)
+		}
+		return rf, nil
+	case ".json":
+		rf, err := routes.LoadJSON(file)
+		if err != nil {
+			return nil, fmt.Errorf("load JSON: %w", err)
+		}
+		return rf, nil
+	}
+	text, err := isPlainTextRoutes(file)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if text {
+		if params.Gateway == "" && params.Interface == "" {
+			return nil, fmt.Errorf("plain-text routes file %s requires --gateway or --interface", file)
+		}
+		rf, err := routes.LoadText(file, params.Gateway, params.Interface, params.Comment)
+		if err != nil {
+			return nil, fmt.Errorf("load text: %w", err)
+		}
+		return rf, nil
 	}
-
 	rf, err := routes.LoadYAML(file)
 	if err != nil {
-		return fmt.Errorf("load YAML: %w", err)
+		return nil, fmt.Errorf("load YAML: %w", err)
 	}
-	entries, err := routes.FlattenToEntries(rf)
+	return rf, nil
+}
+
+// parseRoutes parses routes data from r in format yaml, json, or text. Text data needs the
+// gateway or interface of params, as plain-text routes files do.
+func parseRoutes(r io.Reader, format string, params routes.RouteGroup) (*routes.RoutesFile, error) {
+	data, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("parse routes: %w", err)
+		return nil, fmt.Errorf("read routes: %w", err)
 	}
-	if len(entries) == 0 {
-		fmt.Fprintln(s.out, "No entries to upload.")
-		return nil
+	switch strings.ToLower(format) {
+	case "", "yaml", "yml":
+		rf, err := routes.ParseYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("load YAML: %w", err)
+		}
+		return rf, nil
+	case "json":
+		rf, err := routes.ParseJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("load JSON: %w", err)
+		}
+		return rf, nil
+	case "text", "txt":
+		if params.Gateway == "" && params.Interface == "" {
+			return nil, fmt.Errorf("plain-text routes require --gateway or --interface")
+		}
+		rf, err := routes.ParseText(data, params.Gateway, params.Interface, params.Comment)
+		if err != nil {
+			return nil, fmt.Errorf("load text: %w", err)
+		}
+		return rf, nil
 	}
+	return nil, fmt.Errorf("unknown routes format %q: use yaml, json, or text", format)
+}
 
-	if err := client.AddRoutes(entries); err != nil {
-		return fmt.Errorf("add routes: %w", err)
+// isPlainTextRoutes reports whether file is a plain-text IP list rather than YAML. The
+// extension decides: .txt is text, .yaml and .yml are YAML. Other files are text when
+// their first meaningful line does not start with "routes:" or "metadata:".
+func isPlainTextRoutes(file string) (bool, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".txt":
+		return true, nil
+	case ".yaml", ".yml":
+		return false, nil
 	}
-	fmt.Fprintf(s.out, "Uploaded %d static routes and saved config.\n", len(entries))
-	return nil
+	f, err := os.Open(file)
+	if err != nil {
+		return false, fmt.Errorf("open routes file: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		return !strings.HasPrefix(line, "routes:") && !strings.HasPrefix(line, "metadata:"), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("read routes file: %w", err)
+	}
+	return false, nil
 }
 
-// ResolveDomains resolves route group domains and merges IPv4 results into hosts.
-func (s *Service) ResolveDomains(file string) error {
-	if file == "" {
-		return fmt.Errorf("file path is required")
-	}
-	if _, err := os.Stat(file); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("routes file not found: %s", file)
+// flattenRoutesFile flattens rf into entries. Disabled groups are skipped unless
+// includeDisabled is set; with tags, only matching groups are used.
+func flattenRoutesFile(rf *routes.RoutesFile, includeDisabled bool, tags []string) ([]routes.Route, []routes.DuplicateWarning, error) {
+	if includeDisabled {
+		for i := range rf.Routes {
+			rf.Routes[i].Enabled = nil
 		}
-		return fmt.Errorf("stat routes file: %w", err)
 	}
+	entries, warnings, err := routes.FlattenToEntries(rf, tags...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse routes: %w", err)
+	}
+	return entries, warnings, nil
+}
 
-	rf, err := routes.LoadYAML(file)
+// Rollback replaces all router routes with routes from a backup file.
+// The backup is validated before the router is touched. If restoring fails after
+// the existing routes were deleted, the deleted routes are listed in a warning.
+func (s *Service) Rollback(ctx context.Context, backupFile string, cfg *config.Config) error {
+	rf, err := s.readRoutesFile(backupFile, routes.RouteGroup{})
 	if err != nil {
-		return fmt.Errorf("load YAML: %w", err)
+		return fmt.Errorf("backup file: %w", err)
 	}
-	summary, err := routes.ResolveDomains(rf)
+	entries, _, err := flattenRoutesFile(rf, true, nil)
+	if err != nil {
+		return fmt.Errorf("backup file: %w", err)
+	}
+
+	client, err := s.connect(cfg)
 	if err != nil {
 		return err
 	}
-	if summary.Groups == 0 {
-		fmt.Fprintln(s.out, "No domains to resolve.")
-		return nil
+	s.checkCompatibility(ctx, client)
+	previous, err := client.GetRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("get routes: %w", err)
 	}
-	if err := routes.SaveYAML(file, rf); err != nil {
-		return fmt.Errorf("save YAML: %w", err)
+	if err := client.DeleteAllRoutes(ctx); err != nil {
+		return fmt.Errorf("clear routes: %w", err)
+	}
+	s.metrics.AddDeleted(len(previous))
+	if len(entries) > 0 {
+		if err := client.AddRoutes(ctx, entries); err != nil {
+			fmt.Fprintf(s.warn, "WARNING: routes were cleared but restoring from %s failed.\n", backupFile)
+			fmt.Fprintf(s.warn, "The following %d routes were removed from the router:\n", len(previous))
+			for _, r := range previous {
+				fmt.Fprintf(s.warn, "  %s\n", formatRoute(r))
+			}
+			return addRoutesError("restore routes", err, len(entries))
+		}
+		s.metrics.AddUploaded(len(entries))
 	}
-	fmt.Fprintf(s.out, "Resolved %d domains in %d groups, added %d IPs.\n", summary.Domains, summary.Groups, summary.IPsAdded)
+	fmt.Fprintf(s.out, "Restored %d static routes from %s%s.\n", len(entries), backupFile, s.saveNote())
 	return nil
 }
 
-// Backup downloads routes and saves them to a YAML file.
-func (s *Service) Backup(output string, cfg *config.Config) error {
-	if output == "" {
-		return fmt.Errorf("output path is required")
+// Count prints the number of static routes on the router.
+func (s *Service) Count(ctx context.Context, cfg *config.Config) error {
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	n, err := client.GetRoutesCount(ctx)
+	if err != nil {
+		return fmt.Errorf("get routes: %w", err)
 	}
+	fmt.Fprintln(s.out, n)
+	return nil
+}
 
-	client, err := s.newClient(cfg)
+// List prints router routes as an aligned table, or as JSON or CSV if format is "json" or
+// "csv". noHeader leaves out the table and CSV header row.
+func (s *Service) List(ctx context.Context, cfg *config.Config, format string, noHeader bool) error {
+	if format != "" && format != "table" && format != "json" && format != "csv" {
+		return fmt.Errorf("unsupported format %q (use table, json, or csv)", format)
+	}
+	client, err := s.connect(cfg)
 	if err != nil {
 		return err
 	}
-
-	routesList, err := client.GetRoutes()
+	list, err := client.GetRoutes(ctx)
 	if err != nil {
 		return fmt.Errorf("get routes: %w", err)
 	}
 
-	rf := routes.ToYAML(routesList)
-	if err := routes.SaveYAML(output, rf); err != nil {
-		return fmt.Errorf("backup: %w", err)
+	switch format {
+	case "json":
+		if list == nil {
+			list = []routes.Route{}
+		}
+		enc := json.NewEncoder(s.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	case "csv":
+		return writeRoutesCSV(s.out, list, !noHeader)
+	}
+
+	if !noHeader {
+		// The router line is informational only; a firmware that cannot report it
+		// should not break listing.
+		if info, err := client.GetSystemInfo(ctx); err == nil && info.FirmwareVersion != "" {
+			fmt.Fprintf(s.out, "Router %s: %s, firmware %s, up %s\n\n",
+				cfg.Host, orUnknown(info.Model), info.FirmwareVersion, formatUptime(info.Uptime))
+		}
+	}
+	rows := make([][]string, 0, len(list))
+	for _, r := range list {
+		rows = append(rows, []string{r.Host, orDash(r.Gateway), orDash(r.Interface), orDash(r.Comment), yesNo(r.Auto), yesNo(r.Reject)})
+	}
+	return s.printTable([]string{"DESTINATION", "GATEWAY", "INTERFACE", "COMMENT", "AUTO", "REJECT"}, rows, noHeader)
+}
+
+// FindRoutes prints the routes of a routes file that would carry traffic to ip: those whose
+// destination contains it, as a table like List.
+func (s *Service) FindRoutes(file, ip string) error {
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return fmt.Errorf("invalid IP address %q", ip)
+	}
+	entries, _, err := s.loadEntries(file)
+	if err != nil {
+		return err
+	}
+	found := routes.FindContaining(entries, addr)
+	if len(found) == 0 {
+		fmt.Fprintf(s.out, "No routes contain %s.\n", addr)
+		return nil
 	}
-	n := 0
+	rows := make([][]string, 0, len(found))
+	for _, r := range found {
+		rows = append(rows, []string{r.Host, orDash(r.Gateway), orDash(r.Interface), orDash(r.Comment)})
+	}
+	return s.printTable([]string{"DESTINATION", "GATEWAY", "INTERFACE", "COMMENT"}, rows, false)
+}
+
+// localEntry is a row of ListLocal: a host or a domain of a routes file group.
+type localEntry struct {
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway"`
+	Interface   string `json:"interface"`
+	Comment     string `json:"comment"`
+	// Type is "ip" for hosts and "domain" for domains not resolved into hosts yet.
+	Type string `json:"type"`
+}
+
+// ListLocal prints the hosts and domains of a YAML routes file without connecting to the
+// router, in the formats of List. Domains are listed after the hosts of their group with
+// type "domain", so they are easy to tell from IPs that are uploaded as they are.
+func (s *Service) ListLocal(file, format string, noHeader bool) error {
+	if format != "" && format != "table" && format != "json" && format != "csv" {
+		return fmt.Errorf("unsupported format %q (use table, json, or csv)", format)
+	}
+	rf, err := s.readRoutesFile(file, s.groupParams)
+	if err != nil {
+		return err
+	}
+	entries := []localEntry{}
 	for _, g := range rf.Routes {
-		n += len(g.Hosts)
+		for _, h := range g.Hosts {
+			comment := g.Comment
+			if h.Comment != "" {
+				comment = h.Comment
+			}
+			entries = append(entries, localEntry{Destination: h.Host, Gateway: g.Gateway, Interface: g.Interface, Comment: comment, Type: "ip"})
+		}
+		for _, d := range g.Domains {
+			entries = append(entries, localEntry{Destination: d, Gateway: g.Gateway, Interface: g.Interface, Comment: g.Comment, Type: "domain"})
+		}
 	}
-	fmt.Fprintf(s.out, "Backed up %d routes to %s\n", n, output)
-	return nil
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(s.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		w := csv.NewWriter(s.out)
+		if !noHeader {
+			_ = w.Write([]string{"destination", "gateway", "interface", "comment", "type"})
+		}
+		for _, e := range entries {
+			_ = w.Write([]string{e.Destination, e.Gateway, e.Interface, e.Comment, e.Type})
+		}
+		w.Flush()
+		return w.Error()
+	}
+	rows := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		rows = append(rows, []string{e.Destination, orDash(e.Gateway), orDash(e.Interface), orDash(e.Comment), e.Type})
+	}
+	return s.printTable([]string{"DESTINATION", "GATEWAY", "INTERFACE", "COMMENT", "TYPE"}, rows, noHeader)
 }
 
-// Clear removes all static routes from the router and saves config.
-func (s *Service) Clear(cfg *config.Config) error {
-	client, err := s.newClient(cfg)
+// printTable writes rows as a table aligned with tabwriter under a bold header.
+func (s *Service) printTable(header []string, rows [][]string, noHeader bool) error {
+	// The table is aligned first and the header colored afterwards, because tabwriter
+	// would count the escape codes as part of the column width.
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	if !noHeader {
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	out := table.String()
+	if !noHeader {
+		head, rest, _ := strings.Cut(out, "\n")
+		out = s.colorize(ansiBold, head) + "\n" + rest
+	}
+	_, err := io.WriteString(s.out, out)
+	return err
+}
+
+// writeRoutesCSV writes routes as CSV rows in the column layout read by routes.LoadCSV.
+func writeRoutesCSV(out io.Writer, list []routes.Route, header bool) error {
+	w := csv.NewWriter(out)
+	if header {
+		_ = w.Write([]string{"destination", "gateway", "interface", "comment", "auto", "reject"})
+	}
+	for _, r := range list {
+		_ = w.Write([]string{r.Host, r.Gateway, r.Interface, r.Comment, strconv.FormatBool(r.Auto), strconv.FormatBool(r.Reject)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// formatUptime renders d as days, hours and minutes, e.g. "3d4h12m".
+func formatUptime(d time.Duration) string {
+	if d <= 0 {
+		return "unknown"
+	}
+	d = d.Truncate(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	if days > 0 {
+		return fmt.Sprintf("%dd%dh%dm", days, d/time.Hour, (d%time.Hour)/time.Minute)
+	}
+	return fmt.Sprintf("%dh%dm", d/time.Hour, (d%time.Hour)/time.Minute)
+}
+
+// addRoutesError wraps an AddRoutes failure of op and, when a batch failed, says how many
+// of total routes were added before it.
+func addRoutesError(op string, err error, total int) error {
+	var batchErr *keenetic.BatchError
+	if errors.As(err, &batchErr) {
+		return fmt.Errorf("%s (%d of %d routes added): %w", op, batchErr.Offset, total, err)
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// withFirmware appends the router firmware version to an error the router returned for
+// a write, which makes firmware-specific failures easier to report. Connection and auth
+// failures are returned unchanged: the router would not answer the extra request anyway.
+func withFirmware(ctx context.Context, client RoutesClient, err error) error {
+	if errors.Is(err, &keenetic.NetworkError{}) || errors.Is(err, &keenetic.AuthError{}) || ctx.Err() != nil {
+		return err
+	}
+	version, versionErr := client.FirmwareVersion(ctx)
+	if versionErr != nil || version == "" {
+		return err
+	}
+	return fmt.Errorf("%w (firmware %s)", err, version)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// Stats prints summary statistics about router routes as a table, or as JSON if format is "json".
+func (s *Service) Stats(ctx context.Context, cfg *config.Config, format string) error {
+	if format != "" && format != "text" && format != "json" {
+		return fmt.Errorf("unsupported format %q (use text or json)", format)
+	}
+	client, err := s.connect(cfg)
 	if err != nil {
 		return err
 	}
+	list, err := client.GetRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("get routes: %w", err)
+	}
+	stats := routes.Summarize(list)
 
-	if err := client.DeleteAllRoutes(); err != nil {
-		return fmt.Errorf("clear routes: %w", err)
+	if format == "json" {
+		enc := json.NewEncoder(s.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+
+	w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Total routes:\t%d\n", stats.Total)
+	fmt.Fprintf(w, "Host routes:\t%d\n", stats.HostRoutes)
+	fmt.Fprintf(w, "Network routes:\t%d\n", stats.NetworkRoutes)
+	fmt.Fprintf(w, "IPv4 addresses:\t%d\n", stats.IPCount)
+	writeCounts(w, "By gateway:", stats.ByGateway)
+	writeCounts(w, "By interface:", stats.ByInterface)
+	writeCounts(w, "By comment:", stats.ByComment)
+	return w.Flush()
+}
+
+func writeCounts(w io.Writer, title string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s\t\n", title)
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		label := k
+		if label == "" {
+			label = "(none)"
+		}
+		fmt.Fprintf(w, "  %s\t%d\n", label, counts[k])
 	}
-	fmt.Fprintln(s.out, "Static routes cleared and config saved.")
-	return nil
 }
 
 // InitConfig interactively creates configuration file.
-func (s *Service) InitConfig() error {
+// With useKeyring, the password is stored in the OS keyring and only a reference is saved;
+// if the keyring is unavailable, the password is saved in plaintext with a warning.
+func (s *Service) InitConfig(useKeyring bool) error {
 	scanner := bufio.NewScanner(s.in)
 	var cfg config.Config
+	var profile string
+
+	fmt.Fprint(s.out, "Profile name (default: empty for default): ")
+	if scanner.Scan() {
+		profile = strings.TrimSpace(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read input: %w", err)
+	}
 
 	fmt.Fprint(s.out, "Enter Keenetic router host (e.g., 192.168.100.1:280): ")
 	if scanner.Scan() {
@@ -224,10 +2100,140 @@ func (s *Service) InitConfig() error {
 		return fmt.Errorf("validation failed: %w", err)
 	}
 
-	if err := config.SaveConfig(&cfg); err != nil {
+	if useKeyring {
+		if err := config.StorePasswordInKeyring(cfg.User, cfg.Password); err != nil {
+			fmt.Fprintf(s.warn, "Warning: %v; saving password in plaintext.\n", err)
+		} else {
+			cfg.Password = ""
+			cfg.PasswordRef = config.PasswordRefKeyring
+		}
+	}
+
+	if err := config.SaveProfile(profile, &cfg); err != nil {
 		return fmt.Errorf("save config: %w", err)
 	}
 
+	if profile != "" {
+		fmt.Fprintf(s.out, "Configuration saved to %s (profile %q)\n", config.GetConfigFilePath(), profile)
+		return nil
+	}
 	fmt.Fprintf(s.out, "Configuration saved to %s\n", config.GetConfigFilePath())
 	return nil
 }
+
+// DeleteConfig removes the config file and the keyring passwords it refers to. Unless force
+// is set, it asks for confirmation first. A missing config file only produces a warning.
+func (s *Service) DeleteConfig(force bool) error {
+	path := config.GetConfigFilePath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Fprintf(s.warn, "Warning: config file not found: %s\n", path)
+		return nil
+	}
+	if !force {
+		ok, err := s.confirm(fmt.Sprintf("Delete config file %s?", path))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(s.out, "Aborted.")
+			return nil
+		}
+	}
+	if err := config.DeleteConfig(); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Config file deleted: %s\n", path)
+	return nil
+}
+
+// confirm asks a yes/no question on s.in and reports whether the answer was yes.
+func (s *Service) confirm(question string) (bool, error) {
+	fmt.Fprintf(s.out, "%s [y/N]: ", question)
+	scanner := bufio.NewScanner(s.in)
+	var answer string
+	if scanner.Scan() {
+		answer = strings.ToLower(strings.TrimSpace(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("read input: %w", err)
+	}
+	return answer == "y" || answer == "yes", nil
+}
+
+// ShowConfig prints the resolved configuration of profile (empty for default) and lists
+// all profiles from the config file. The password is masked unless revealPassword is set.
+func (s *Service) ShowConfig(profile string, revealPassword bool) error {
+	cfg, err := config.LoadConfigWithProfile(profile, "", "", "")
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	password := "***"
+	if revealPassword {
+		password = cfg.Password
+	}
+	if profile != "" {
+		fmt.Fprintf(s.out, "Profile:  %s\n", profile)
+	}
+	fmt.Fprintf(s.out, "Host:     %s\n", cfg.Host)
+	fmt.Fprintf(s.out, "User:     %s\n", cfg.User)
+	fmt.Fprintf(s.out, "Password: %s\n", password)
+
+	f, err := config.LoadFile()
+	if err != nil {
+		return err
+	}
+	if names := f.ProfileNames(); len(names) > 0 {
+		fmt.Fprintln(s.out, "\nProfiles:")
+		for _, name := range names {
+			fmt.Fprintf(s.out, "  %s (%s)\n", name, f.Profiles[name].Host)
+		}
+	}
+	return nil
+}
+
+// EditConfig opens the config file in $EDITOR (vi on Unix if unset) and validates profile
+// after the editor exits. Without an editor, it prints the config file path instead.
+func (s *Service) EditConfig(profile string) error {
+	path := config.GetConfigFilePath()
+	editor := strings.TrimSpace(os.Getenv("EDITOR"))
+	if editor == "" && runtime.GOOS != "windows" {
+		editor = "vi"
+	}
+	if editor == "" {
+		fmt.Fprintf(s.out, "Config file: %s\nSet $EDITOR to edit it with this command.\n", path)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create config directory: %w", err)
+	}
+	// $EDITOR may hold arguments or a quoted path with spaces, so let the shell split it.
+	// The config path is passed as "$1" and is never split.
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	if runtime.GOOS == "windows" {
+		args := strings.Fields(editor)
+		cmd = exec.Command(args[0], append(args[1:], path)...)
+	}
+	// The editor needs the terminal itself: s.in and s.out may be redirected by --quiet
+	// or --output.
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("run editor %s: %w", editor, err)
+	}
+	return s.ValidateConfig(profile)
+}
+
+// ValidateConfig checks that the resolved configuration of profile is complete and well-formed.
+func (s *Service) ValidateConfig(profile string) error {
+	cfg, err := config.LoadConfigWithProfile(profile, "", "", "")
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	fmt.Fprintln(s.out, "Config OK")
+	return nil
+}
@@ -0,0 +1,1847 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vladpi/keenetic-routes/config"
+	"github.com/vladpi/keenetic-routes/keenetic"
+	"github.com/vladpi/keenetic-routes/routes"
+)
+
+type fakeClient struct {
+	routes     []routes.Route
+	added      []routes.Route
+	deleted    bool
+	removed    []routes.Route
+	interfaces []string
+	firmware   string
+	model      string
+	uptime     time.Duration
+	pingErr    error
+	// systemErr fails GetSystemInfo only, like a firmware without rci/show/system.
+	systemErr error
+	addErr    error
+	autoSave  bool
+	// authHook is called on the first request after SetAuthHook, like a login.
+	authHook func()
+	authed   bool
+}
+
+func (f *fakeClient) SetAutoSave(enabled bool) {
+	f.autoSave = enabled
+}
+
+func (f *fakeClient) SetRequestLog(w io.Writer) {}
+
+func (f *fakeClient) SetLogger(l keenetic.Logger) {}
+
+// SetAuthHook stores fn for the login that the next request makes; each connection
+// sets the hook again and so logs in once.
+func (f *fakeClient) SetAuthHook(fn func()) {
+	f.authHook = fn
+	f.authed = false
+}
+
+// login simulates the auth a router request needs, calling the auth hook once.
+func (f *fakeClient) login() {
+	if f.authed {
+		return
+	}
+	f.authed = true
+	if f.authHook != nil {
+		f.authHook()
+	}
+}
+
+func (f *fakeClient) GetRoutes(ctx context.Context) ([]routes.Route, error) {
+	f.login()
+	return append([]routes.Route(nil), f.routes...), nil
+}
+
+func (f *fakeClient) GetRoutesCount(ctx context.Context) (int, error) {
+	f.login()
+	return len(f.routes), nil
+}
+
+func (f *fakeClient) RoutesExist(ctx context.Context, hosts []string) (map[string]bool, error) {
+	f.login()
+	found := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		for _, r := range f.routes {
+			if r.Host == h {
+				found[h] = true
+			}
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeClient) AddRoutes(ctx context.Context, entries []routes.Route) error {
+	f.login()
+	if f.addErr != nil {
+		return f.addErr
+	}
+	f.added = append(f.added, entries...)
+	f.routes = append(f.routes, entries...)
+	return nil
+}
+
+func (f *fakeClient) DeleteAllRoutes(ctx context.Context) error {
+	f.login()
+	f.deleted = true
+	f.routes = nil
+	return nil
+}
+
+func (f *fakeClient) DeleteRoutes(ctx context.Context, entries []routes.Route) error {
+	f.login()
+	_, f.routes = routes.DiffRoutes(entries, f.routes)
+	f.removed = append(f.removed, entries...)
+	return nil
+}
+
+func (f *fakeClient) ReplaceRoutes(ctx context.Context, entries []routes.Route) ([]routes.Route, []routes.Route, error) {
+	added, removed := routes.DiffRoutes(entries, f.routes)
+	if len(removed) > 0 {
+		if err := f.DeleteRoutes(ctx, removed); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(added) > 0 {
+		if err := f.AddRoutes(ctx, added); err != nil {
+			return nil, nil, err
+		}
+	}
+	return added, removed, nil
+}
+
+func (f *fakeClient) GetInterfaces(ctx context.Context) ([]string, error) {
+	f.login()
+	return f.interfaces, nil
+}
+
+func (f *fakeClient) FirmwareVersion(ctx context.Context) (string, error) {
+	f.login()
+	return f.firmware, f.pingErr
+}
+
+func (f *fakeClient) GetSystemInfo(ctx context.Context) (*keenetic.SystemInfo, error) {
+	f.login()
+	if f.pingErr != nil {
+		return nil, f.pingErr
+	}
+	if f.systemErr != nil {
+		return nil, f.systemErr
+	}
+	return &keenetic.SystemInfo{FirmwareVersion: f.firmware, Model: f.model, Uptime: f.uptime}, nil
+}
+
+func (f *fakeClient) CompatibilityCheck(ctx context.Context) error {
+	f.login()
+	if f.pingErr != nil {
+		return f.pingErr
+	}
+	if f.firmware == "" {
+		return fmt.Errorf("read firmware version: router reported no version")
+	}
+	return keenetic.CheckFirmware(f.firmware)
+}
+
+func (f *fakeClient) DryRunAddRoutes(entries []routes.Route, out io.Writer) error {
+	for _, e := range entries {
+		fmt.Fprintln(out, e.Host)
+	}
+	return nil
+}
+
+func newTestService(client *fakeClient) (*Service, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	factory := func(*config.Config) (RoutesClient, error) { return client, nil }
+	return NewServiceWithClientFactory(factory, strings.NewReader(""), out), out
+}
+
+func writeRoutesFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write routes file: %v", err)
+	}
+	return path
+}
+
+func TestServiceDiff(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - comment: test
+    gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+      - 2.2.2.2
+`)
+	tests := []struct {
+		name        string
+		current     []routes.Route
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{
+			name:      "add_only",
+			current:   []routes.Route{{Host: "1.1.1.1", Gateway: "10.0.0.1"}},
+			wantAdded: []string{"2.2.2.2"},
+		},
+		{
+			name: "remove_only",
+			current: []routes.Route{
+				{Host: "1.1.1.1", Gateway: "10.0.0.1"},
+				{Host: "2.2.2.2", Gateway: "10.0.0.1"},
+				{Host: "3.3.3.3", Gateway: "10.0.0.1"},
+			},
+			wantRemoved: []string{"3.3.3.3"},
+		},
+		{
+			name: "mixed",
+			current: []routes.Route{
+				{Host: "1.1.1.1", Gateway: "10.0.0.1"},
+				{Host: "3.3.3.3", Gateway: "10.0.0.1"},
+			},
+			wantAdded:   []string{"2.2.2.2"},
+			wantRemoved: []string{"3.3.3.3"},
+		},
+		{
+			name: "identical",
+			current: []routes.Route{
+				{Host: "2.2.2.2", Gateway: "10.0.0.1"},
+				{Host: "1.1.1.1", Gateway: "10.0.0.1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _ := newTestService(&fakeClient{routes: tt.current})
+			added, removed, err := svc.Diff(context.Background(), file, &config.Config{})
+			if err != nil {
+				t.Fatalf("Diff: %v", err)
+			}
+			if got := hosts(added); strings.Join(got, ",") != strings.Join(tt.wantAdded, ",") {
+				t.Fatalf("added: got %v, want %v", got, tt.wantAdded)
+			}
+			if got := hosts(removed); strings.Join(got, ",") != strings.Join(tt.wantRemoved, ",") {
+				t.Fatalf("removed: got %v, want %v", got, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func hosts(list []routes.Route) []string {
+	var out []string
+	for _, r := range list {
+		out = append(out, r.Host)
+	}
+	return out
+}
+
+func TestServiceQuiet(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+`)
+	client := &fakeClient{firmware: "4.1.7"}
+	svc, out := newTestService(client)
+	svc.SetQuiet(true)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if len(client.added) != 1 {
+		t.Fatalf("expected 1 route uploaded, got %d", len(client.added))
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected no output, got %q", out.String())
+	}
+
+	// Warnings are still written.
+	overlapping := writeRoutesFile(t, "routes:\n  - gateway: 10.0.0.1\n    hosts: [10.0.0.0/24, 10.0.0.5]\n")
+	warn := &bytes.Buffer{}
+	svc.SetWarningOutput(warn)
+	if err := svc.Upload(context.Background(), []string{overlapping}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if out.Len() != 0 || !strings.Contains(warn.String(), "Warning: ") {
+		t.Fatalf("expected only a warning, got output %q, warnings %q", out.String(), warn.String())
+	}
+
+	// So are the corrections made while parsing a file.
+	hostBits := writeRoutesFile(t, "routes:\n  - gateway: 10.0.0.1\n    hosts: [10.0.0.5/24]\n")
+	warn.Reset()
+	if err := svc.Upload(context.Background(), []string{hostBits}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if want := "Warning: " + hostBits + ": normalizing 10.0.0.5/24 to 10.0.0.0/24.\n"; out.Len() != 0 || warn.String() != want {
+		t.Fatalf("expected warning %q, got output %q, warnings %q", want, out.String(), warn.String())
+	}
+}
+
+func TestServiceList(t *testing.T) {
+	list := []routes.Route{
+		{Host: "1.1.1.1", Gateway: "10.0.0.1", Comment: "dns", Auto: true},
+		{Host: "192.168.100.0/24", Interface: "Wireguard1"},
+	}
+	tests := []struct {
+		name     string
+		format   string
+		noHeader bool
+		want     string
+	}{
+		{
+			name: "table",
+			want: "DESTINATION       GATEWAY   INTERFACE   COMMENT  AUTO  REJECT\n" +
+				"1.1.1.1           10.0.0.1  -           dns      yes   no\n" +
+				"192.168.100.0/24  -         Wireguard1  -        no    no\n",
+		},
+		{
+			name:     "table_no_header",
+			noHeader: true,
+			want: "1.1.1.1           10.0.0.1  -           dns  yes  no\n" +
+				"192.168.100.0/24  -         Wireguard1  -    no   no\n",
+		},
+		{
+			name:   "csv",
+			format: "csv",
+			want: "destination,gateway,interface,comment,auto,reject\n" +
+				"1.1.1.1,10.0.0.1,,dns,true,false\n" +
+				"192.168.100.0/24,,Wireguard1,,false,false\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, out := newTestService(&fakeClient{routes: list})
+			if err := svc.List(context.Background(), &config.Config{}, tt.format, tt.noHeader); err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", out.String(), tt.want)
+			}
+		})
+	}
+
+	svc, out := newTestService(&fakeClient{routes: list})
+	if err := svc.List(context.Background(), &config.Config{}, "json", false); err != nil {
+		t.Fatalf("List json: %v", err)
+	}
+	var decoded []map[string]any
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode json: %v", err)
+	}
+	if len(decoded) != 2 || decoded[0]["destination"] != "1.1.1.1" || decoded[1]["interface"] != "Wireguard1" {
+		t.Fatalf("unexpected json: %s", out.String())
+	}
+
+	if err := svc.List(context.Background(), &config.Config{}, "xml", false); err == nil {
+		t.Fatalf("expected error for unsupported format")
+	}
+
+	// Coloring the header must not break the column alignment.
+	out.Reset()
+	svc.SetColor(true)
+	if err := svc.List(context.Background(), &config.Config{}, "table", false); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	want := "\033[1mDESTINATION       GATEWAY   INTERFACE   COMMENT  AUTO  REJECT\033[0m\n" +
+		"1.1.1.1           10.0.0.1  -           dns      yes   no\n"
+	if !strings.HasPrefix(out.String(), want) {
+		t.Fatalf("colored table: got %q", out.String())
+	}
+
+	svc, out = newTestService(&fakeClient{routes: list, firmware: "4.1.7", model: "Keenetic Giga", uptime: 90 * time.Minute})
+	if err := svc.List(context.Background(), &config.Config{Host: "192.168.1.1"}, "table", false); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Router 192.168.1.1: Keenetic Giga, firmware 4.1.7, up 1h30m\n\nDESTINATION") {
+		t.Fatalf("expected router line before the table, got %q", out.String())
+	}
+}
+
+func TestServiceListLocal(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - comment: vpn
+    gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+    domains:
+      - example.com
+`)
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.ListLocal(file, "table", false); err != nil {
+		t.Fatalf("ListLocal: %v", err)
+	}
+	want := "DESTINATION  GATEWAY   INTERFACE  COMMENT  TYPE\n" +
+		"1.1.1.1      10.0.0.1  -          vpn      ip\n" +
+		"example.com  10.0.0.1  -          vpn      domain\n"
+	if out.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := svc.ListLocal(file, "csv", true); err != nil {
+		t.Fatalf("ListLocal csv: %v", err)
+	}
+	if want := "1.1.1.1,10.0.0.1,,vpn,ip\nexample.com,10.0.0.1,,vpn,domain\n"; out.String() != want {
+		t.Fatalf("csv: got %q", out.String())
+	}
+}
+
+func TestServiceFindRoutes(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - comment: dns
+    gateway: 10.0.0.1
+    hosts: [8.8.8.0/24, 1.1.1.1]
+  - interface: Wireguard1
+    hosts: [8.8.8.8]
+`)
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.FindRoutes(file, "8.8.8.8"); err != nil {
+		t.Fatalf("FindRoutes: %v", err)
+	}
+	want := "DESTINATION  GATEWAY   INTERFACE   COMMENT\n" +
+		"8.8.8.0/24   10.0.0.1  -           dns\n" +
+		"8.8.8.8      -         Wireguard1  -\n"
+	if out.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := svc.FindRoutes(file, "9.9.9.9"); err != nil {
+		t.Fatalf("FindRoutes: %v", err)
+	}
+	if out.String() != "No routes contain 9.9.9.9.\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if err := svc.FindRoutes(file, "not-an-ip"); err == nil {
+		t.Fatalf("expected error for invalid IP")
+	}
+}
+
+func TestServiceAutoBackup(t *testing.T) {
+	client := &fakeClient{routes: []routes.Route{{Host: "1.1.1.1", Gateway: "10.0.0.1"}}}
+	svc, out := newTestService(client)
+	svc.SetTags([]string{"vpn"})
+
+	dir := t.TempDir()
+	if err := svc.AutoBackup(context.Background(), dir, []*config.Config{{Host: "192.168.1.1:80"}}); err != nil {
+		t.Fatalf("AutoBackup: %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "routes-backup-*.yaml"))
+	if len(matches) != 1 || len(filepath.Base(matches[0])) != len("routes-backup-20240115120000.yaml") {
+		t.Fatalf("unexpected backup files: %v", matches)
+	}
+	if want := "Auto-backup saved to " + matches[0] + ".\n"; out.String() != want {
+		t.Fatalf("output: got %q, want %q", out.String(), want)
+	}
+	rf, err := routes.LoadYAML(matches[0])
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if len(rf.Routes) != 1 || len(rf.Routes[0].Hosts) != 1 {
+		t.Fatalf("backup should ignore tags and hold every route, got %+v", rf.Routes)
+	}
+
+	dir = t.TempDir()
+	cfgs := []*config.Config{{Host: "192.168.1.1:80"}, {Host: "https://192.168.2.1:443"}}
+	if err := svc.AutoBackup(context.Background(), dir, cfgs); err != nil {
+		t.Fatalf("AutoBackup: %v", err)
+	}
+	for _, host := range []string{"192.168.1.1_80", "192.168.2.1_443"} {
+		if m, _ := filepath.Glob(filepath.Join(dir, "routes-backup-"+host+"-*.yaml")); len(m) != 1 {
+			t.Fatalf("missing backup for %s", host)
+		}
+	}
+}
+
+func TestRotateBackups(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	var names []string
+	for i := 0; i < 5; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("routes-backup-2024011512000%d.yaml", i))
+		if err := os.WriteFile(name, []byte("routes: []\n"), 0644); err != nil {
+			t.Fatalf("write backup: %v", err)
+		}
+		if err := os.Chtimes(name, base, base.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+		names = append(names, name)
+	}
+	var others []string
+	for _, name := range []string{"manual.yaml", "routes-backup-notes.yaml", "routes-backup-10.0.0.1-20240115120000.yaml"} {
+		other := filepath.Join(dir, name)
+		if err := os.WriteFile(other, []byte("routes: []\n"), 0644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+		others = append(others, other)
+	}
+	pattern, _ := backupRotationPattern("routes-backup-20240115120009.yaml")
+
+	if err := rotateBackups(dir, pattern, 0); err != nil {
+		t.Fatalf("rotateBackups keep 0: %v", err)
+	}
+	if m, _ := filepath.Glob(filepath.Join(dir, pattern)); len(m) != 5 {
+		t.Fatalf("keep 0 must keep all files, got %v", m)
+	}
+
+	if err := rotateBackups(dir, pattern, 2); err != nil {
+		t.Fatalf("rotateBackups: %v", err)
+	}
+	m, _ := filepath.Glob(filepath.Join(dir, pattern))
+	if strings.Join(m, ",") != strings.Join(names[3:], ",") {
+		t.Fatalf("kept %v, want %v", m, names[3:])
+	}
+	for _, other := range others {
+		if _, err := os.Stat(other); err != nil {
+			t.Fatalf("unrelated file removed: %v", err)
+		}
+	}
+}
+
+func TestBackupRotationPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		ok      bool
+	}{
+		{name: "routes-backup-20240115120000.yaml", pattern: "routes-backup-" + strings.Repeat("[0-9]", 14) + ".yaml", ok: true},
+		{name: "routes-backup-192.168.1.1_80-20240115120000.yaml", pattern: "routes-backup-192.168.1.1_80-" + strings.Repeat("[0-9]", 14) + ".yaml", ok: true},
+		{name: "routes-backup-[__1]_80-20240115120000.yaml", pattern: `routes-backup-\[__1]_80-` + strings.Repeat("[0-9]", 14) + ".yaml", ok: true},
+		{name: "routes-backup-x20240115120000.yaml"},
+		{name: "backup.yaml"},
+		{name: "routes-backup-latest.yaml"},
+		{name: "routes-backup-20240115120000.txt"},
+	}
+	for _, tt := range tests {
+		pattern, ok := backupRotationPattern(tt.name)
+		if pattern != tt.pattern || ok != tt.ok {
+			t.Fatalf("%s: got %q, %v; want %q, %v", tt.name, pattern, ok, tt.pattern, tt.ok)
+		}
+	}
+}
+
+func TestServiceCheck(t *testing.T) {
+	svc, out := newTestService(&fakeClient{firmware: "4.1.7", model: "Keenetic Giga", uptime: 50 * time.Hour})
+	if err := svc.Check(context.Background(), &config.Config{Host: "192.168.1.1:80"}, time.Second); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, "host=192.168.1.1:80 firmware=4.1.7 model=Keenetic Giga uptime=2d2h0m latency=") || !strings.Contains(got, "ms\n") {
+		t.Fatalf("unexpected output: %q", got)
+	}
+
+	svc, out = newTestService(&fakeClient{firmware: "4.1.7", model: "Keenetic Giga", systemErr: fmt.Errorf("status 404")})
+	if err := svc.Check(context.Background(), &config.Config{Host: "192.168.1.1:80"}, time.Second); err != nil {
+		t.Fatalf("Check must not fail without system info: %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, "firmware=4.1.7 model=unknown uptime=unknown latency=") {
+		t.Fatalf("unexpected output: %q", got)
+	}
+
+	svc, _ = newTestService(&fakeClient{pingErr: context.DeadlineExceeded})
+	err := svc.Check(context.Background(), &config.Config{Host: "192.168.1.1:80"}, 2*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "did not respond within 2s") {
+		t.Fatalf("expected timeout diagnostic, got %v", err)
+	}
+}
+
+func TestServiceUploadErrorIncludesFirmware(t *testing.T) {
+	file := writeRoutesFile(t, "routes:\n  - gateway: 10.0.0.1\n    hosts:\n      - 1.1.1.1\n")
+	svc, _ := newTestService(&fakeClient{firmware: "4.1.7", addErr: fmt.Errorf("boom")})
+	err := svc.Upload(context.Background(), []string{file}, &config.Config{})
+	if err == nil || err.Error() != "add routes: boom (firmware 4.1.7)" {
+		t.Fatalf("expected firmware in error, got %v", err)
+	}
+
+	svc, _ = newTestService(&fakeClient{firmware: "4.1.7", addErr: &keenetic.NetworkError{Op: "post", Err: fmt.Errorf("refused")}})
+	err = svc.Upload(context.Background(), []string{file}, &config.Config{})
+	if err == nil || strings.Contains(err.Error(), "firmware") {
+		t.Fatalf("network errors must not be annotated, got %v", err)
+	}
+
+	file = writeRoutesFile(t, "routes:\n  - gateway: 10.0.0.1\n    hosts: [1.1.1.1, 2.2.2.2, 3.3.3.3]\n")
+	svc, _ = newTestService(&fakeClient{firmware: "4.1.7", addErr: &keenetic.BatchError{Offset: 2, Err: fmt.Errorf("boom")}})
+	err = svc.Upload(context.Background(), []string{file}, &config.Config{})
+	if err == nil || !strings.Contains(err.Error(), "add routes (2 of 3 routes added): ") {
+		t.Fatalf("expected added count in error, got %v", err)
+	}
+}
+
+func TestServiceVersionCheck(t *testing.T) {
+	file := writeRoutesFile(t, "routes:\n  - gateway: 10.0.0.1\n    hosts:\n      - 1.1.1.1\n")
+	tests := []struct {
+		name     string
+		firmware string
+		skip     bool
+		// warning is the expected warning text; empty means no warning.
+		warning string
+	}{
+		{name: "old", firmware: "2.16.D.12.0-3", warning: "firmware 2.16.D.12.0-3 is older than the minimum supported 3.0"},
+		{name: "old_skipped", firmware: "2.16", skip: true},
+		{name: "supported", firmware: "4.1.7"},
+		{name: "unreadable", firmware: "", warning: "cannot check firmware compatibility: read firmware version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{firmware: tt.firmware}
+			svc, out := newTestService(client)
+			svc.SetSkipVersionCheck(tt.skip)
+			if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if tt.warning == "" && strings.Contains(out.String(), "Warning:") {
+				t.Fatalf("unexpected warning: %q", out.String())
+			}
+			if tt.warning != "" && !strings.Contains(out.String(), "Warning: "+tt.warning) {
+				t.Fatalf("expected warning %q, got %q", tt.warning, out.String())
+			}
+			if len(client.added) != 1 {
+				t.Fatalf("the version check must not block the upload, added %v", client.added)
+			}
+		})
+	}
+}
+
+func TestServiceLoggerOneLinePerRequest(t *testing.T) {
+	t.Setenv("KEENETIC_CONFIG_PATH", filepath.Join(t.TempDir(), "config.yaml"))
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`[{"host": "1.1.1.1", "gateway": "10.0.0.1"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	// Set up logging as --verbose does, with stderr captured in a file.
+	stderrFile, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatalf("create stderr file: %v", err)
+	}
+	defer stderrFile.Close()
+	stderr := os.Stderr
+	os.Stderr = stderrFile
+	defer func() { os.Stderr = stderr }()
+
+	svc := NewServiceWithClientFactory(nil, strings.NewReader(""), &bytes.Buffer{})
+	svc.SetLogBodies(false)
+	svc.SetLogger(log.New(os.Stderr, "", 0))
+	if err := svc.Count(context.Background(), &config.Config{Host: server.URL}); err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	os.Stderr = stderr
+
+	data, err := os.ReadFile(stderrFile.Name())
+	if err != nil {
+		t.Fatalf("read stderr file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	mu.Lock()
+	defer mu.Unlock()
+	if requests == 0 || len(lines) != requests {
+		t.Fatalf("got %d log lines for %d requests:\n%s", len(lines), requests, data)
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "GET "+server.URL+"/") || !strings.HasSuffix(line, "ms)") {
+			t.Fatalf("unexpected log line %q", line)
+		}
+	}
+}
+
+func TestServiceLint(t *testing.T) {
+	warnOnly := writeRoutesFile(t, "routes:\n  - gateway: 10.0.0.1\n    hosts:\n      - 1.1.1.1\n      - 1.1.1.1\n")
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.Lint(warnOnly); err != nil {
+		t.Fatalf("Lint with warnings only: %v", err)
+	}
+	if !strings.Contains(out.String(), "warning: group #1: duplicate host 1.1.1.1") || !strings.Contains(out.String(), "OK (1 warning(s))") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+
+	invalid := writeRoutesFile(t, "routes:\n  - gateway: 10.0.0.1\n    hosts:\n      - 1.1.1\n")
+	svc, out = newTestService(&fakeClient{})
+	if err := svc.Lint(invalid); err == nil || !strings.Contains(err.Error(), "1 error(s)") {
+		t.Fatalf("expected lint error, got %v", err)
+	}
+	if !strings.Contains(out.String(), `error: group #1: host "1.1.1"`) {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+
+	if err := svc.Lint(invalid + ".missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected missing file error, got %v", err)
+	}
+}
+
+func TestServiceFix(t *testing.T) {
+	const content = "routes:\n  - gateway: 10.0.0.1\n    hosts:\n      - 2.2.2.2\n      - 1.1.1.1\n      - 2.2.2.2\n"
+	file := writeRoutesFile(t, content)
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.Fix(file, true); err != nil {
+		t.Fatalf("Fix dry run: %v", err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != content {
+		t.Fatalf("dry run modified the file:\n%s", data)
+	}
+	if !strings.Contains(out.String(), "Fixed 1 duplicate hosts, sorted hosts of 1 groups. (dry run") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	if err := svc.Fix(file, false); err != nil {
+		t.Fatalf("Fix: %v", err)
+	}
+	rf, err := routes.LoadYAML(file)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if got := strings.Join(rf.Routes[0].Hosts.Strings(), ","); got != "1.1.1.1,2.2.2.2" {
+		t.Fatalf("unexpected hosts: %s", got)
+	}
+}
+
+func TestServiceRewritesSkipCleanFile(t *testing.T) {
+	const content = "# my routes\nroutes:\n    # via VPN\n    - gateway: 10.0.0.1\n      hosts: [1.1.1.1, 2.2.2.2]\n"
+	file := writeRoutesFile(t, content)
+	svc, out := newTestService(&fakeClient{})
+	for name, run := range map[string]func() error{
+		"fix":       func() error { return svc.Fix(file, false) },
+		"normalize": func() error { return svc.Normalize(file) },
+		"aggregate": func() error { return svc.Aggregate(file, false) },
+	} {
+		if err := run(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if data, _ := os.ReadFile(file); string(data) != content {
+			t.Fatalf("%s rewrote a clean file:\n%s", name, data)
+		}
+	}
+	for _, want := range []string{"Nothing to fix.", "is already normalized.", "Nothing to aggregate"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output missing %q: %q", want, out.String())
+		}
+	}
+}
+
+func TestServiceRewritesRefuseIncludes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "common.yaml"), []byte("routes:\n  - gateway: 10.0.0.1\n    hosts: [2.2.2.2, 1.1.1.1, 1.1.1.1]\n"), 0644); err != nil {
+		t.Fatalf("write include: %v", err)
+	}
+	const content = "routes:\n  - !include common.yaml\n"
+	file := filepath.Join(dir, "main.yaml")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("write routes file: %v", err)
+	}
+	svc, _ := newTestService(&fakeClient{})
+	for name, run := range map[string]func() error{
+		"fix":             func() error { return svc.Fix(file, false) },
+		"normalize":       func() error { return svc.Normalize(file) },
+		"aggregate":       func() error { return svc.Aggregate(file, false) },
+		"resolve-domains": func() error { return svc.ResolveDomains(file, ResolveDomainsOptions{}) },
+	} {
+		if err := run(); err == nil || !strings.Contains(err.Error(), "includes other files") {
+			t.Fatalf("%s: expected includes error, got %v", name, err)
+		}
+		if data, _ := os.ReadFile(file); string(data) != content {
+			t.Fatalf("%s rewrote a file with includes:\n%s", name, data)
+		}
+	}
+}
+
+func TestServiceGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	out := &bytes.Buffer{}
+	svc := NewServiceWithClientFactory(nil, strings.NewReader(""), out)
+	if err := svc.Generate(path); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	rf, err := routes.LoadYAML(path)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if len(rf.Routes) != 2 || rf.Routes[0].Gateway == "" || rf.Routes[1].Interface == "" {
+		t.Fatalf("unexpected example groups: %+v", rf.Routes)
+	}
+	if issues := routes.Lint(rf); len(issues) != 0 {
+		t.Fatalf("example file has lint issues: %v", issues)
+	}
+
+	if err := os.WriteFile(path, []byte("routes: []\n"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	svc = NewServiceWithClientFactory(nil, strings.NewReader("n\n"), out)
+	if err := svc.Generate(path); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "routes: []\n" {
+		t.Fatalf("file overwritten without confirmation:\n%s", data)
+	}
+}
+
+func TestServiceApply(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+      - 2.2.2.2
+`)
+	client := &fakeClient{routes: []routes.Route{
+		{Host: "1.1.1.1", Gateway: "10.0.0.1"},
+		{Host: "3.3.3.3", Gateway: "10.0.0.1"},
+	}}
+	svc, out := newTestService(client)
+	if err := svc.Apply(context.Background(), file, &config.Config{}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if got := strings.Join(hosts(client.added), ","); got != "2.2.2.2" {
+		t.Fatalf("added: got %s", got)
+	}
+	if got := strings.Join(hosts(client.removed), ","); got != "3.3.3.3" {
+		t.Fatalf("removed: got %s", got)
+	}
+	if !strings.Contains(out.String(), "Applied: +1 added, -1 removed, 1 unchanged.") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	// A second run finds nothing to change.
+	out.Reset()
+	if err := svc.Apply(context.Background(), file, &config.Config{}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if !strings.Contains(out.String(), "Applied: +0 added, -0 removed, 2 unchanged.") {
+		t.Fatalf("unexpected output on second run: %q", out.String())
+	}
+}
+
+func TestServiceDiffFiles(t *testing.T) {
+	fileA := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+      - 2.2.2.2
+`)
+	fileB := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 2.2.2.2
+      - 3.3.3.3
+`)
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.DiffFiles(fileA, fileB); err != nil {
+		t.Fatalf("DiffFiles: %v", err)
+	}
+	want := "+ 3.3.3.3 via 10.0.0.1\n- 1.1.1.1 via 10.0.0.1\n1 added, 1 removed.\n"
+	if out.String() != want {
+		t.Fatalf("output: got %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	svc.SetColor(true)
+	if err := svc.DiffFiles(fileA, fileB); err != nil {
+		t.Fatalf("DiffFiles: %v", err)
+	}
+	want = "\033[32m+ 3.3.3.3 via 10.0.0.1\033[0m\n\033[31m- 1.1.1.1 via 10.0.0.1\033[0m\n1 added, 1 removed.\n"
+	if out.String() != want {
+		t.Fatalf("colored output: got %q, want %q", out.String(), want)
+	}
+}
+
+func TestServiceNoSave(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+`)
+	client := &fakeClient{}
+	svc, out := newTestService(client)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if !client.autoSave || !strings.Contains(out.String(), "and saved config") {
+		t.Fatalf("expected save by default, output %q", out.String())
+	}
+
+	svc.SetNoSave(true)
+	out.Reset()
+	if err := svc.Clear(context.Background(), &config.Config{}); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if client.autoSave || !strings.Contains(out.String(), "(config not saved)") {
+		t.Fatalf("expected no save, output %q", out.String())
+	}
+}
+
+func TestServiceMergeUpload(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+      - 2.2.2.2
+      - 3.3.3.3
+`)
+	client := &fakeClient{routes: []routes.Route{{Host: "1.1.1.1", Gateway: "10.0.0.1"}}}
+	svc, out := newTestService(client)
+	if err := svc.MergeUpload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("MergeUpload: %v", err)
+	}
+	if got := hosts(client.added); strings.Join(got, ",") != "2.2.2.2,3.3.3.3" {
+		t.Fatalf("added: got %v", got)
+	}
+	if !strings.Contains(out.String(), "Skipped 1 existing, added 2 new routes.") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestServiceUploadMetadata(t *testing.T) {
+	file := writeRoutesFile(t, `metadata:
+  version: v1.2
+  created_at: 2024-01-15T10:00:00Z
+routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+`)
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if !strings.Contains(out.String(), "Loading routes v1.2 created 2024-01-15\n") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	// Only uploads announce the file.
+	svc, out = newTestService(&fakeClient{})
+	if _, _, err := svc.Diff(context.Background(), file, &config.Config{}); err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if strings.Contains(out.String(), "Loading routes") {
+		t.Fatalf("diff printed metadata: %q", out.String())
+	}
+}
+
+func TestServiceDuplicateWarnings(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - comment: a
+    gateway: 10.0.0.1
+    tags: [vpn]
+    hosts: [1.1.1.1]
+  - comment: b
+    gateway: 10.0.0.2
+    tags: [vpn]
+    hosts: [1.1.1.1]
+`)
+	for name, run := range map[string]func(svc *Service) error{
+		"dry-run": func(svc *Service) error { return svc.DryRunUpload([]string{file}, &config.Config{}, io.Discard) },
+		"diff": func(svc *Service) error {
+			_, _, err := svc.Diff(context.Background(), file, &config.Config{})
+			return err
+		},
+		"clear": func(svc *Service) error {
+			svc.SetTags([]string{"vpn"})
+			return svc.ClearTagged(context.Background(), file, &config.Config{})
+		},
+	} {
+		svc, out := newTestService(&fakeClient{})
+		if err := run(svc); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !strings.Contains(out.String(), "Warning: host 1.1.1.1 is in groups") {
+			t.Fatalf("%s: expected duplicate warning, got %q", name, out.String())
+		}
+	}
+}
+
+func TestServiceUploadLimit(t *testing.T) {
+	file := writeRoutesFile(t, "routes:\n  - gateway: 10.0.0.1\n    hosts: [1.1.1.1, 2.2.2.2, 3.3.3.3]\n")
+	client := &fakeClient{}
+	svc, out := newTestService(client)
+	svc.SetLimit(2)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := strings.Join(hosts(client.added), ","); got != "1.1.1.1,2.2.2.2" {
+		t.Fatalf("added: got %s", got)
+	}
+	if !strings.Contains(out.String(), "Uploading 2 of total 3 routes (--limit applied).") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	client = &fakeClient{}
+	svc, out = newTestService(client)
+	svc.SetLimit(5)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if len(client.added) != 3 || strings.Contains(out.String(), "--limit") {
+		t.Fatalf("limit above the route count changed the upload: %d routes, output %q", len(client.added), out.String())
+	}
+}
+
+func TestServiceUploadCommentFilter(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - comment: vpn-work
+    gateway: 10.0.0.1
+    hosts: [1.1.1.1, 2.2.2.2, {host: 5.5.5.5, comment: printer}]
+  - comment: vpn-home
+    gateway: 10.0.0.1
+    hosts: [3.3.3.3, {host: 6.6.6.6, comment: vpn-work laptop}]
+  - gateway: 10.0.0.1
+    hosts: [4.4.4.4]
+`)
+	client := &fakeClient{}
+	svc, out := newTestService(client)
+	svc.SetCommentFilter(regexp.MustCompile(`^vpn-w`))
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := strings.Join(hosts(client.added), ","); got != "1.1.1.1,2.2.2.2,5.5.5.5" {
+		t.Fatalf("added: got %s", got)
+	}
+	if !strings.Contains(out.String(), `Filtering by comment regexp "^vpn-w": 1 of 3 groups match.`) {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	client = &fakeClient{}
+	svc, out = newTestService(client)
+	svc.SetCommentFilter(regexp.MustCompile(`office`))
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("no match must not be an error: %v", err)
+	}
+	if len(client.added) != 0 || !strings.Contains(out.String(), "Warning: no group comments match") {
+		t.Fatalf("expected warning and nothing uploaded, added %v, output %q", client.added, out.String())
+	}
+}
+
+func TestServiceAggregate(t *testing.T) {
+	const content = "routes:\n  - gateway: 10.0.0.1\n    hosts: [10.0.0.0/25, 10.0.0.128/25, 1.1.1.1]\n"
+	file := writeRoutesFile(t, content)
+	client := &fakeClient{}
+	svc, out := newTestService(client)
+	svc.SetAggregate(true)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := strings.Join(hosts(client.added), ","); got != "1.1.1.1,10.0.0.0/24" {
+		t.Fatalf("added: got %s", got)
+	}
+	if !strings.Contains(out.String(), "Aggregated 3 routes into 2.") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	svc, _ = newTestService(&fakeClient{})
+	if err := svc.Aggregate(file, true); err != nil {
+		t.Fatalf("Aggregate dry run: %v", err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != content {
+		t.Fatalf("dry run modified the file:\n%s", data)
+	}
+	if err := svc.Aggregate(file, false); err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	rf, err := routes.LoadYAML(file)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if got := strings.Join(rf.Routes[0].Hosts.Strings(), ","); got != "1.1.1.1,10.0.0.0/24" {
+		t.Fatalf("file hosts: got %s", got)
+	}
+}
+
+func TestServiceAggregateIdempotent(t *testing.T) {
+	file := writeRoutesFile(t, "routes:\n  - gateway: 10.0.0.1\n    hosts: [10.0.0.0/25, 10.0.0.128/25, 1.1.1.1]\n")
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.Aggregate(file, false); err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	aggregated, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+
+	// A second run finds nothing to merge and leaves the file as the first run wrote it.
+	out.Reset()
+	if err := svc.Aggregate(file, false); err != nil {
+		t.Fatalf("Aggregate again: %v", err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != string(aggregated) {
+		t.Fatalf("second run changed the file:\n%s\nwant:\n%s", data, aggregated)
+	}
+	if !strings.Contains(out.String(), "Nothing to aggregate in "+file+" (2 hosts).") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestServiceUploadOverlaps(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts: [10.0.0.0/16]
+  - interface: Wireguard1
+    hosts: [10.0.1.0/24]
+`)
+	client := &fakeClient{}
+	svc, out := newTestService(client)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if !strings.Contains(out.String(), "Warning: 10.0.1.0/24 (interface Wireguard1) is a subset of 10.0.0.0/16 (gateway 10.0.0.1)") {
+		t.Fatalf("missing overlap warning: %q", out.String())
+	}
+
+	client = &fakeClient{}
+	svc, _ = newTestService(client)
+	svc.SetStrictNoOverlap(true)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err == nil || !strings.Contains(err.Error(), "1 overlapping routes") {
+		t.Fatalf("expected overlap error, got %v", err)
+	}
+	if len(client.added) != 0 {
+		t.Fatalf("routes uploaded despite --strict-no-overlap: %v", hosts(client.added))
+	}
+}
+
+func TestServiceUploadEnvVars(t *testing.T) {
+	// Without --expand-env a "$" is plain text.
+	plain := writeRoutesFile(t, "routes:\n  - comment: Pay$Service\n    gateway: 10.0.0.1\n    hosts: [1.1.1.1]\n")
+	client := &fakeClient{}
+	svc, _ := newTestService(client)
+	if err := svc.Upload(context.Background(), []string{plain}, &config.Config{}); err != nil {
+		t.Fatalf("Upload without --expand-env: %v", err)
+	}
+	if len(client.added) != 1 || client.added[0].Comment != "Pay$Service" {
+		t.Fatalf("unexpected routes: %+v", client.added)
+	}
+
+	file := writeRoutesFile(t, "routes:\n  - gateway: $KR_TEST_GATEWAY\n    hosts: [1.1.1.1]\n")
+	client = &fakeClient{}
+	svc, _ = newTestService(client)
+	svc.SetExpandEnv(true)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err == nil || !strings.Contains(err.Error(), "KR_TEST_GATEWAY") {
+		t.Fatalf("expected unset variable error, got %v", err)
+	}
+
+	t.Setenv("KR_TEST_GATEWAY", "10.0.0.7")
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if len(client.added) != 1 || client.added[0].Gateway != "10.0.0.7" {
+		t.Fatalf("unexpected routes: %+v", client.added)
+	}
+}
+
+func TestServiceUploadMultipleFiles(t *testing.T) {
+	base := writeRoutesFile(t, `routes:
+  - comment: base
+    gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+      - 2.2.2.2
+`)
+	customer := writeRoutesFile(t, `routes:
+  - comment: base
+    gateway: 10.0.0.1
+    hosts:
+      - 2.2.2.2
+      - 3.3.3.3
+  - comment: customer
+    interface: Wireguard1
+    hosts:
+      - 4.4.4.4
+`)
+	client := &fakeClient{}
+	svc, out := newTestService(client)
+	if err := svc.Upload(context.Background(), []string{base, customer}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := strings.Join(hosts(client.added), ","); got != "1.1.1.1,2.2.2.2,3.3.3.3,4.4.4.4" {
+		t.Fatalf("added: got %s", got)
+	}
+	if !strings.Contains(out.String(), "Uploaded 4 static routes") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	svc.SetAppend(true)
+	out.Reset()
+	if err := svc.Upload(context.Background(), []string{base}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if !strings.Contains(out.String(), "Appending 2 routes (existing routes preserved).") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestServiceUploadGroupOverrides(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - comment: a
+    interface: Wireguard1
+    hosts:
+      - 1.1.1.1
+  - gateway: 10.0.0.2
+    hosts:
+      - 2.2.2.2
+`)
+	client := &fakeClient{}
+	svc, _ := newTestService(client)
+	svc.SetGroupParams("192.168.1.1", "", "corp VPN")
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if len(client.added) != 2 {
+		t.Fatalf("added: got %+v", client.added)
+	}
+	for _, r := range client.added {
+		if r.Gateway != "192.168.1.1" || r.Interface != "" || r.Comment != "corp VPN" {
+			t.Fatalf("override not applied: %+v", r)
+		}
+	}
+}
+
+func TestServiceUploadIPv6(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - interface: Wireguard0
+    hosts:
+      - 1.1.1.1
+      - 2001:db8::/32
+`)
+	client := &fakeClient{}
+	svc, _ := newTestService(client)
+	err := svc.Upload(context.Background(), []string{file}, &config.Config{})
+	if err == nil || !strings.Contains(err.Error(), "--ipv6") {
+		t.Fatalf("expected --ipv6 error, got %v", err)
+	}
+	if len(client.added) != 0 {
+		t.Fatalf("routes added despite error: %v", hosts(client.added))
+	}
+
+	svc.SetIPv6(true)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload with IPv6: %v", err)
+	}
+	if got := hosts(client.added); strings.Join(got, ",") != "1.1.1.1,2001:db8::/32" {
+		t.Fatalf("added: got %v", got)
+	}
+
+	gateway := writeRoutesFile(t, "routes:\n  - gateway: fe80::1\n    hosts: [1.1.1.1]\n")
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.Upload(context.Background(), []string{gateway}, &config.Config{}); err == nil || !strings.Contains(err.Error(), "IPv6 gateway fe80::1 requires --ipv6") {
+		t.Fatalf("expected IPv6 gateway error, got %v", err)
+	}
+	if err := svc.Lint(gateway); err == nil || !strings.Contains(out.String(), "IPv6 gateway fe80::1 requires --ipv6") {
+		t.Fatalf("expected lint error, got %v, output %q", err, out.String())
+	}
+	svc.SetIPv6(true)
+	if err := svc.Lint(gateway); err != nil {
+		t.Fatalf("Lint with IPv6: %v", err)
+	}
+}
+
+func TestServiceUploadDuplicateWarning(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - comment: a
+    gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+  - comment: b
+    gateway: 10.0.0.2
+    hosts:
+      - 1.1.1.1
+`)
+	client := &fakeClient{}
+	svc, out := newTestService(client)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if len(client.added) != 1 || client.added[0].Gateway != "10.0.0.1" {
+		t.Fatalf("added: got %+v", client.added)
+	}
+	if !strings.Contains(out.String(), `Warning: host 1.1.1.1 is in groups "a" and "b"`) {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestServiceUploadPlainText(t *testing.T) {
+	// Files without a .yaml, .yml or .txt extension are told apart by their content.
+	file := filepath.Join(t.TempDir(), "routes.list")
+	if err := os.WriteFile(file, []byte("# exported list\n1.1.1.1\n10.0.0.0/8\n"), 0644); err != nil {
+		t.Fatalf("write routes file: %v", err)
+	}
+	client := &fakeClient{}
+	svc, _ := newTestService(client)
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err == nil || !strings.Contains(err.Error(), "--gateway") {
+		t.Fatalf("expected --gateway error, got %v", err)
+	}
+
+	svc.SetGroupParams("192.168.1.1", "", "corp VPN")
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := hosts(client.added); strings.Join(got, ",") != "1.1.1.1,10.0.0.0/8" {
+		t.Fatalf("added: got %v", got)
+	}
+	if client.added[0].Gateway != "192.168.1.1" || client.added[0].Comment != "corp VPN" {
+		t.Fatalf("unexpected route params: %+v", client.added[0])
+	}
+
+	// A .yaml file is always YAML, whatever its first line.
+	yamlFile := writeRoutesFile(t, "1.1.1.1\n")
+	if err := svc.Upload(context.Background(), []string{yamlFile}, &config.Config{}); err == nil || !strings.Contains(err.Error(), "load YAML") {
+		t.Fatalf("expected YAML error, got %v", err)
+	}
+}
+
+func TestServiceUploadFromReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		gateway string
+		want    string
+		wantErr string
+	}{
+		{name: "yaml", format: "yaml", input: "routes:\n  - gateway: 10.0.0.1\n    hosts: [1.1.1.1, 2.2.2.2]\n", want: "1.1.1.1,2.2.2.2"},
+		{name: "json", format: "json", input: `[{"host": "1.1.1.1", "gateway": "10.0.0.1"}]`, want: "1.1.1.1"},
+		{name: "text", format: "text", input: "# list\n1.1.1.1\n10.0.0.0/8\n", gateway: "10.0.0.1", want: "1.1.1.1,10.0.0.0/8"},
+		{name: "text_without_gateway", format: "text", input: "1.1.1.1\n", wantErr: "--gateway"},
+		{name: "unknown_format", format: "xml", input: "<routes/>", wantErr: "unknown routes format"},
+		{name: "invalid_host", format: "yaml", input: "routes:\n  - gateway: 10.0.0.1\n    hosts: [bad]\n", wantErr: "bad"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{}
+			svc, out := newTestService(client)
+			svc.SetGroupParams(tt.gateway, "", "")
+			err := svc.UploadFromReader(context.Background(), strings.NewReader(tt.input), tt.format, &config.Config{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UploadFromReader: %v", err)
+			}
+			if got := strings.Join(hosts(client.added), ","); got != tt.want {
+				t.Fatalf("added: got %s, want %s", got, tt.want)
+			}
+			if !strings.Contains(out.String(), "Uploaded") {
+				t.Fatalf("unexpected output: %q", out.String())
+			}
+		})
+	}
+}
+
+func TestServiceTags(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - comment: a
+    gateway: 10.0.0.1
+    tags: [vpn-a]
+    hosts:
+      - 1.1.1.1
+  - comment: b
+    gateway: 10.0.0.2
+    tags: [vpn-b]
+    hosts:
+      - 2.2.2.2
+`)
+	client := &fakeClient{}
+	svc, out := newTestService(client)
+	svc.SetTags([]string{"vpn-a"})
+	if err := svc.Upload(context.Background(), []string{file}, &config.Config{}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got := hosts(client.added); strings.Join(got, ",") != "1.1.1.1" {
+		t.Fatalf("added: got %v", got)
+	}
+
+	client.routes = append(client.routes, routes.Route{Host: "2.2.2.2", Gateway: "10.0.0.2", Comment: "b"})
+	if err := svc.ClearTagged(context.Background(), file, &config.Config{}); err != nil {
+		t.Fatalf("ClearTagged: %v", err)
+	}
+	if got := hosts(client.removed); strings.Join(got, ",") != "1.1.1.1" {
+		t.Fatalf("removed: got %v", got)
+	}
+	if got := hosts(client.routes); strings.Join(got, ",") != "2.2.2.2" {
+		t.Fatalf("remaining: got %v", got)
+	}
+	if !strings.Contains(out.String(), "Removed 1 tagged routes") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestServiceResolveDomainsDoH(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Status":0,"Answer":[{"type":1,"data":"1.2.3.4"}]}`)
+	}))
+	defer server.Close()
+	t.Setenv("KEENETIC_DNS_SERVER", "")
+	t.Setenv("KEENETIC_DNS_DOH_URL", server.URL)
+
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    domains:
+      - a.example
+    hosts: []
+`)
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.ResolveDomains(file, ResolveDomainsOptions{DryRun: true, Workers: 1}); err != nil {
+		t.Fatalf("ResolveDomains dry run: %v", err)
+	}
+	if !strings.Contains(out.String(), "+ 1.2.3.4 (group #1)") {
+		t.Fatalf("unexpected dry run output: %q", out.String())
+	}
+	if rf, err := routes.LoadYAML(file); err != nil || len(rf.Routes[0].Hosts) != 0 {
+		t.Fatalf("dry run modified the file: %+v, %v", rf, err)
+	}
+
+	if err := svc.ResolveDomains(file, ResolveDomainsOptions{}); err != nil {
+		t.Fatalf("ResolveDomains: %v", err)
+	}
+	rf, err := routes.LoadYAML(file)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if got := rf.Routes[0].Hosts.Strings(); len(got) != 1 || got[0] != "1.2.3.4" {
+		t.Fatalf("hosts: got %v (output %q)", got, out.String())
+	}
+
+	if err := svc.ResolveDomains(file, ResolveDomainsOptions{DNS: DNSOptions{Server: "10.0.0.53", DoHURL: server.URL}}); err == nil {
+		t.Fatalf("expected error for both DNS server and DoH URL")
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	t.Setenv("KEENETIC_DNS_SERVER", "")
+	t.Setenv("KEENETIC_DNS_DOH_URL", "")
+
+	if r, err := newResolver(DNSOptions{}); err != nil || r != net.DefaultResolver {
+		t.Fatalf("expected system resolver, got %v, %v", r, err)
+	}
+	if _, err := newResolver(DNSOptions{NoDefaultResolver: true}); err == nil {
+		t.Fatalf("expected error for an empty resolver chain")
+	}
+	r, err := newResolver(DNSOptions{Server: "10.0.0.53", Fallback: []string{"https://dns.example/dns-query"}})
+	if err != nil {
+		t.Fatalf("newResolver: %v", err)
+	}
+	if _, ok := r.(*net.Resolver); ok {
+		t.Fatalf("expected a fallback chain, got %T", r)
+	}
+}
+
+func TestServiceBackupKeepsTags(t *testing.T) {
+	output := writeRoutesFile(t, `routes:
+  - comment: a
+    gateway: 10.0.0.1
+    tags: [vpn-a]
+    hosts:
+      - 1.1.1.1
+`)
+	client := &fakeClient{routes: []routes.Route{
+		{Host: "1.1.1.1", Gateway: "10.0.0.1", Comment: "a"},
+		{Host: "3.3.3.3", Gateway: "10.0.0.1", Comment: "a"},
+		{Host: "2.2.2.2", Gateway: "10.0.0.2", Comment: "b"},
+	}}
+	svc, _ := newTestService(client)
+	if err := svc.Backup(context.Background(), output, "", &config.Config{Host: "192.168.1.1:80"}); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	rf, err := routes.LoadYAML(output)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if len(rf.Routes) != 2 || fmt.Sprint(rf.Routes[0].Tags) != "[vpn-a]" || len(rf.Routes[1].Tags) != 0 {
+		t.Fatalf("unexpected groups: %+v", rf.Routes)
+	}
+	if rf.Metadata == nil || rf.Metadata.Description != "backup from 192.168.1.1:80" || rf.Metadata.CreatedAt.IsZero() {
+		t.Fatalf("unexpected metadata: %+v", rf.Metadata)
+	}
+
+	svc.SetTags([]string{"vpn-a"})
+	if err := svc.Backup(context.Background(), output, "", &config.Config{}); err != nil {
+		t.Fatalf("Backup with tags: %v", err)
+	}
+	rf, err = routes.LoadYAML(output)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if len(rf.Routes) != 1 || strings.Join(rf.Routes[0].Hosts.Strings(), ",") != "1.1.1.1,3.3.3.3" {
+		t.Fatalf("unexpected groups: %+v", rf.Routes)
+	}
+
+	// An unreadable existing file is overwritten with a warning.
+	broken := writeRoutesFile(t, "routes: [\n")
+	svc, out := newTestService(client)
+	if err := svc.Backup(context.Background(), broken, "", &config.Config{}); err != nil {
+		t.Fatalf("Backup over broken file: %v", err)
+	}
+	if rf, err := routes.LoadYAML(broken); err != nil || len(rf.Routes) != 2 {
+		t.Fatalf("broken file not overwritten: %+v, %v", rf, err)
+	}
+	if !strings.Contains(out.String(), "Warning: cannot read tags from existing") {
+		t.Fatalf("expected warning, got %q", out.String())
+	}
+}
+
+func TestServiceRollback(t *testing.T) {
+	backup := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+`)
+	current := []routes.Route{{Host: "9.9.9.9", Gateway: "10.0.0.1"}}
+
+	t.Run("restores", func(t *testing.T) {
+		client := &fakeClient{routes: current}
+		svc, _ := newTestService(client)
+		if err := svc.Rollback(context.Background(), backup, &config.Config{}); err != nil {
+			t.Fatalf("Rollback: %v", err)
+		}
+		if !client.deleted || strings.Join(hosts(client.routes), ",") != "1.1.1.1" {
+			t.Fatalf("unexpected router state: deleted=%v routes=%v", client.deleted, client.routes)
+		}
+	})
+
+	t.Run("warns_on_failed_restore", func(t *testing.T) {
+		client := &fakeClient{routes: current, addErr: fmt.Errorf("boom")}
+		svc, out := newTestService(client)
+		if err := svc.Rollback(context.Background(), backup, &config.Config{}); err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+		if !strings.Contains(out.String(), "WARNING") || !strings.Contains(out.String(), "9.9.9.9") {
+			t.Fatalf("expected warning listing lost routes, got %q", out.String())
+		}
+	})
+
+	t.Run("missing_backup_does_not_touch_router", func(t *testing.T) {
+		client := &fakeClient{routes: current}
+		svc, _ := newTestService(client)
+		if err := svc.Rollback(context.Background(), filepath.Join(t.TempDir(), "missing.yaml"), &config.Config{}); err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+		if client.deleted {
+			t.Fatalf("routes were deleted despite missing backup")
+		}
+	})
+}
+
+func TestServiceStats(t *testing.T) {
+	client := &fakeClient{routes: []routes.Route{
+		{Host: "1.1.1.1", Gateway: "10.0.0.1", Comment: "a"},
+		{Host: "10.0.0.0/8", Gateway: "10.0.0.1", Comment: "a"},
+		{Host: "2.2.2.2/32", Interface: "Wireguard1", Comment: "b"},
+	}}
+	svc, out := newTestService(client)
+	if err := svc.Stats(context.Background(), &config.Config{}, "json"); err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	var stats routes.RouteStats
+	if err := json.Unmarshal(out.Bytes(), &stats); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if stats.Total != 3 || stats.HostRoutes != 2 || stats.NetworkRoutes != 1 {
+		t.Fatalf("unexpected counts: %+v", stats)
+	}
+	if stats.ByGateway["10.0.0.1"] != 2 || stats.ByInterface["Wireguard1"] != 1 || stats.ByComment["a"] != 2 {
+		t.Fatalf("unexpected breakdown: %+v", stats)
+	}
+
+	out.Reset()
+	if err := svc.Stats(context.Background(), &config.Config{}, "text"); err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if !strings.Contains(out.String(), "Total routes:") || !strings.Contains(out.String(), "Wireguard1") {
+		t.Fatalf("unexpected text output: %q", out.String())
+	}
+}
+
+func TestServiceCount(t *testing.T) {
+	client := &fakeClient{routes: []routes.Route{
+		{Host: "1.1.1.1", Gateway: "10.0.0.1"},
+		{Host: "2.2.2.2", Gateway: "10.0.0.1"},
+	}}
+	svc, out := newTestService(client)
+	if err := svc.Count(context.Background(), &config.Config{}); err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if out.String() != "2\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestServiceUploadToAll(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+`)
+	var mu sync.Mutex
+	clients := map[string]*fakeClient{
+		"10.0.0.1:280": {},
+		"10.0.0.2:280": {addErr: fmt.Errorf("boom")},
+	}
+	out := &bytes.Buffer{}
+	svc := NewServiceWithClientFactory(func(cfg *config.Config) (RoutesClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return clients[cfg.Host], nil
+	}, strings.NewReader(""), out)
+
+	cfgs := []*config.Config{{Host: "10.0.0.1:280"}, {Host: "10.0.0.2:280"}}
+	errs := svc.UploadToAll(context.Background(), []string{file}, cfgs)
+	if len(errs) != 2 || errs[0] != nil || errs[1] == nil {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(clients["10.0.0.1:280"].added) != 1 {
+		t.Fatalf("first router did not receive routes")
+	}
+	if !strings.Contains(out.String(), "[10.0.0.1:280] OK") || !strings.Contains(out.String(), "[10.0.0.2:280] FAILED") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	// Firmware warnings are buffered per router and printed with its host.
+	if !strings.Contains(out.String(), "[10.0.0.1:280] Warning: cannot check firmware compatibility") {
+		t.Fatalf("expected host-prefixed warning, got: %q", out.String())
+	}
+}
+
+func TestServiceBackupText(t *testing.T) {
+	client := &fakeClient{routes: []routes.Route{
+		{Host: "2.2.2.2", Gateway: "10.0.0.1"},
+		{Host: "10.0.0.0/8", Interface: "Wireguard1"},
+		// Neither gateway nor interface: invalid in a routes file, but still backed up.
+		{Host: "3.3.3.3"},
+	}}
+	svc, _ := newTestService(client)
+	output := filepath.Join(t.TempDir(), "routes.txt")
+	if err := svc.Backup(context.Background(), output, "text", &config.Config{}); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	if string(data) != "10.0.0.0/8\n2.2.2.2\n3.3.3.3\n" {
+		t.Fatalf("unexpected backup: %q", data)
+	}
+	if err := svc.Backup(context.Background(), output, "xml", &config.Config{}); err == nil {
+		t.Fatalf("expected error for unsupported format")
+	}
+
+	// Files and streams share the format switch.
+	csvFile := filepath.Join(t.TempDir(), "routes.csv")
+	if err := svc.Backup(context.Background(), csvFile, "csv", &config.Config{}); err != nil {
+		t.Fatalf("Backup csv: %v", err)
+	}
+	var stream bytes.Buffer
+	if err := svc.BackupToWriter(context.Background(), &stream, "csv", &config.Config{}); err != nil {
+		t.Fatalf("BackupToWriter csv: %v", err)
+	}
+	if data, _ := os.ReadFile(csvFile); string(data) != stream.String() || !strings.Contains(stream.String(), "3.3.3.3") {
+		t.Fatalf("file and stream backups differ:\n%s\n%s", data, stream.String())
+	}
+}
+
+func TestServiceBackupToWriter(t *testing.T) {
+	client := &fakeClient{routes: []routes.Route{
+		{Host: "2.2.2.2", Gateway: "10.0.0.1", Comment: "dns"},
+		{Host: "10.0.0.0/8", Interface: "Wireguard1"},
+	}}
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: "yaml", want: []string{"description: backup from 192.168.1.1", "- 2.2.2.2", "interface: Wireguard1"}},
+		{format: "json", want: []string{`"routes": [`, `"gateway": "10.0.0.1"`}},
+		{format: "csv", want: []string{"destination,gateway,interface,comment,auto,reject\n", "2.2.2.2,10.0.0.1,,dns,false,false\n"}},
+		{format: "text", want: []string{"10.0.0.0/8\n2.2.2.2\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			svc, out := newTestService(client)
+			var buf bytes.Buffer
+			if err := svc.BackupToWriter(context.Background(), &buf, tt.format, &config.Config{Host: "192.168.1.1"}); err != nil {
+				t.Fatalf("BackupToWriter: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Fatalf("output missing %q:\n%s", want, buf.String())
+				}
+			}
+			if out.Len() != 0 {
+				t.Fatalf("unexpected informational output: %q", out.String())
+			}
+		})
+	}
+
+	svc, _ := newTestService(client)
+	if err := svc.BackupToWriter(context.Background(), io.Discard, "xml", &config.Config{}); err == nil {
+		t.Fatalf("expected error for unsupported format")
+	}
+}
+
+func TestServiceDeleteConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("KEENETIC_CONFIG_PATH", path)
+	if err := os.WriteFile(path, []byte("host: 10.0.0.1:280\n"), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	svc := NewServiceWithClientFactory(nil, strings.NewReader("n\n"), out)
+	if err := svc.DeleteConfig(false); err != nil {
+		t.Fatalf("DeleteConfig: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("config deleted without confirmation: %v", err)
+	}
+
+	svc = NewServiceWithClientFactory(nil, strings.NewReader("y\n"), out)
+	if err := svc.DeleteConfig(false); err != nil {
+		t.Fatalf("DeleteConfig: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("config file still exists: %v", err)
+	}
+	if !strings.Contains(out.String(), "Config file deleted: "+path) {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := svc.DeleteConfig(true); err != nil {
+		t.Fatalf("DeleteConfig on missing file: %v", err)
+	}
+	if !strings.Contains(out.String(), "Warning: config file not found") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestServiceEditConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor script requires a Unix shell")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	t.Setenv("KEENETIC_CONFIG_PATH", path)
+	t.Setenv("KEENETIC_HOST", "")
+	t.Setenv("KEENETIC_USER", "")
+	t.Setenv("KEENETIC_PASSWORD", "")
+	editor := filepath.Join(dir, "editor.sh")
+	script := "#!/bin/sh\nprintf 'host: 10.0.0.1:280\\nuser: admin\\npassword: secret\\n' > \"$1\"\n"
+	if err := os.WriteFile(editor, []byte(script), 0755); err != nil {
+		t.Fatalf("write editor script: %v", err)
+	}
+	t.Setenv("EDITOR", editor)
+
+	svc, out := newTestService(&fakeClient{})
+	if err := svc.EditConfig(""); err != nil {
+		t.Fatalf("EditConfig: %v", err)
+	}
+	if !strings.Contains(out.String(), "Config OK") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	// A quoted editor path with spaces.
+	spaced := filepath.Join(dir, "my editor.sh")
+	if err := os.WriteFile(spaced, []byte(script), 0755); err != nil {
+		t.Fatalf("write editor script: %v", err)
+	}
+	os.Remove(path)
+	t.Setenv("EDITOR", `"`+spaced+`"`)
+	if err := svc.EditConfig(""); err != nil {
+		t.Fatalf("EditConfig with quoted editor: %v", err)
+	}
+
+	// A blank $EDITOR falls back to vi.
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "vi"), []byte(script), 0755); err != nil {
+		t.Fatalf("write vi script: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	os.Remove(path)
+	t.Setenv("EDITOR", "  ")
+	if err := svc.EditConfig(""); err != nil {
+		t.Fatalf("EditConfig with blank editor: %v", err)
+	}
+
+	t.Setenv("EDITOR", "true")
+	if err := os.WriteFile(path, []byte("host: 10.0.0.1\n"), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := svc.EditConfig(""); err == nil || !strings.Contains(err.Error(), "invalid config") {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+}
+
+func TestServiceEditConfigQuietUsesTerminal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor script requires a Unix shell")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	t.Setenv("KEENETIC_CONFIG_PATH", path)
+	t.Setenv("KEENETIC_HOST", "")
+	t.Setenv("KEENETIC_USER", "")
+	t.Setenv("KEENETIC_PASSWORD", "")
+	editor := filepath.Join(dir, "editor.sh")
+	script := "#!/bin/sh\necho editor-screen\nprintf 'host: 10.0.0.1:280\\nuser: admin\\npassword: secret\\n' > \"$1\"\n"
+	if err := os.WriteFile(editor, []byte(script), 0755); err != nil {
+		t.Fatalf("write editor script: %v", err)
+	}
+	t.Setenv("EDITOR", editor)
+
+	// Stand in for the terminal: the editor must write there even when the
+	// service output is discarded.
+	terminal, err := os.Create(filepath.Join(dir, "terminal"))
+	if err != nil {
+		t.Fatalf("create terminal file: %v", err)
+	}
+	defer terminal.Close()
+	stdout := os.Stdout
+	os.Stdout = terminal
+	defer func() { os.Stdout = stdout }()
+
+	svc, out := newTestService(&fakeClient{})
+	svc.SetQuiet(true)
+	if err := svc.EditConfig(""); err != nil {
+		t.Fatalf("EditConfig: %v", err)
+	}
+	os.Stdout = stdout
+	if out.Len() != 0 {
+		t.Fatalf("editor output leaked into service output: %q", out.String())
+	}
+	data, err := os.ReadFile(terminal.Name())
+	if err != nil {
+		t.Fatalf("read terminal file: %v", err)
+	}
+	if string(data) != "editor-screen\n" {
+		t.Fatalf("editor did not write to the terminal, got %q", data)
+	}
+}
@@ -0,0 +1,221 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/vladpi/keenetic-routes/config"
+	"github.com/vladpi/keenetic-routes/keenetic"
+	"github.com/vladpi/keenetic-routes/routes"
+)
+
+const (
+	// DefaultWatchDebounce is how long Watch waits after the last change before uploading.
+	DefaultWatchDebounce = 2 * time.Second
+	// watchPollInterval is how often files are checked when fsnotify is unavailable.
+	watchPollInterval = time.Second
+)
+
+// Watch uploads routes from files every time one of them changes, until ctx is done.
+// Changes are debounced so an editor saving in several steps causes one upload.
+// A failed upload is printed and watching goes on.
+func (s *Service) Watch(ctx context.Context, files []string, cfg *config.Config, debounce time.Duration) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no files to watch")
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("routes file: %w", err)
+		}
+	}
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+
+	client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	s.checkCompatibility(ctx, client)
+	count, err := client.GetRoutesCount(ctx)
+	if err != nil {
+		return fmt.Errorf("count routes: %w", err)
+	}
+
+	watched := withIncludes(files)
+	changes, err := watchFiles(ctx, watched)
+	if err != nil {
+		fmt.Fprintf(s.warn, "Warning: file notifications unavailable (%v), polling every %s.\n", err, watchPollInterval)
+		changes = pollFiles(ctx, watched, watchPollInterval)
+	}
+	fmt.Fprintf(s.out, "Watching %d file(s) for changes (router has %d routes). Press Ctrl+C to stop.\n", len(files), count)
+	s.notifyWatch("ready")
+
+	var upload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(s.out, "Stopped watching.")
+			return nil
+		case <-changes:
+			upload = time.After(debounce)
+		case <-upload:
+			upload = nil
+			count = s.uploadChange(ctx, client, files, cfg, count)
+			s.notifyWatch("handled")
+		}
+	}
+}
+
+// uploadChange uploads files after a change and prints how the router route count moved
+// from count. It returns the new count, or count if the upload or the count failed.
+func (s *Service) uploadChange(ctx context.Context, client RoutesClient, files []string, cfg *config.Config, count int) int {
+	stamp := time.Now().Format("2006-01-02 15:04:05")
+	fmt.Fprintf(s.out, "[%s] Change detected, uploading routes.\n", stamp)
+	if err := s.Upload(ctx, files, cfg); err != nil {
+		fmt.Fprintf(s.out, "[%s] Upload failed: %v\n", stamp, err)
+		return count
+	}
+	curr, err := client.GetRoutesCount(ctx)
+	if err != nil {
+		fmt.Fprintf(s.out, "[%s] Count routes failed: %v\n", stamp, err)
+		return count
+	}
+	if keenetic.RouteCountChanged(count, curr) {
+		fmt.Fprintf(s.out, "[%s] Router routes: %d -> %d\n", stamp, count, curr)
+	}
+	return curr
+}
+
+// notifyWatch reports a Watch event to the watchEvent hook, if any.
+func (s *Service) notifyWatch(event string) {
+	if s.watchEvent != nil {
+		s.watchEvent(event)
+	}
+}
+
+// withIncludes returns files followed by the files they pull in with !include, so that
+// editing an included file also triggers an upload. Includes are resolved once, when
+// watching starts; files that fail to parse are watched as they are.
+func withIncludes(files []string) []string {
+	all := append([]string(nil), files...)
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		seen[filepath.Clean(f)] = true
+	}
+	for _, f := range files {
+		rf, err := routes.LoadYAML(f)
+		if err != nil {
+			continue
+		}
+		for _, inc := range rf.Includes {
+			if !seen[filepath.Clean(inc)] {
+				seen[filepath.Clean(inc)] = true
+				all = append(all, inc)
+			}
+		}
+	}
+	return all
+}
+
+// watchFiles sends on the returned channel whenever one of files is written or created.
+// The parent directories are watched because editors often save by renaming a temporary
+// file over the original.
+func watchFiles(ctx context.Context, files []string) (<-chan struct{}, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	watched := make(map[string]bool, len(files))
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		watched[abs] = true
+		if dir := filepath.Dir(abs); !dirs[dir] {
+			if err := w.Add(dir); err != nil {
+				w.Close()
+				return nil, err
+			}
+			dirs[dir] = true
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if !watched[filepath.Clean(ev.Name)] || ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case _, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// pollFiles sends on the returned channel whenever the size or modification time of one
+// of files changes between checks.
+func pollFiles(ctx context.Context, files []string, interval time.Duration) <-chan struct{} {
+	stamp := func(f string) string {
+		info, err := os.Stat(f)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%d/%d", info.Size(), info.ModTime().UnixNano())
+	}
+	last := make([]string, len(files))
+	for i, f := range files {
+		last[i] = stamp(f)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				changed := false
+				for i, f := range files {
+					if st := stamp(f); st != last[i] {
+						last[i] = st
+						changed = true
+					}
+				}
+				if changed {
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return changes
+}
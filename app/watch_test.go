@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vladpi/keenetic-routes/config"
+)
+
+func TestServiceWatch(t *testing.T) {
+	file := writeRoutesFile(t, `routes:
+  - gateway: 10.0.0.1
+    hosts:
+      - 1.1.1.1
+`)
+	client := &fakeClient{}
+	svc, out := newTestService(client)
+	events := make(chan string, 10)
+	svc.watchEvent = func(event string) { events <- event }
+	waitFor := func(want string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("watch event: got %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for watch event %q", want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- svc.Watch(ctx, []string{file}, &config.Config{}, 50*time.Millisecond) }()
+
+	waitFor("ready")
+	if err := os.WriteFile(file, []byte("routes:\n  - gateway: 10.0.0.1\n    hosts:\n      - 1.1.1.1\n      - 2.2.2.2\n"), 0644); err != nil {
+		t.Fatalf("write routes file: %v", err)
+	}
+	waitFor("handled")
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	if got := strings.Join(hosts(client.added), ","); got != "1.1.1.1,2.2.2.2" {
+		t.Fatalf("uploaded hosts: got %s\noutput:\n%s", got, out.String())
+	}
+	for _, want := range []string{"router has 0 routes", "Change detected", "Router routes: 0 -> 2", "Stopped watching."} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestPollFiles(t *testing.T) {
+	file := writeRoutesFile(t, "1.1.1.1\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := pollFiles(ctx, []string{file}, 10*time.Millisecond)
+
+	select {
+	case <-changes:
+		t.Fatalf("unexpected change before the file was modified")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := os.WriteFile(file, []byte("1.1.1.1\n2.2.2.2\n"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatalf("change not detected")
+	}
+}
+
+func TestWithIncludes(t *testing.T) {
+	dir := t.TempDir()
+	common := filepath.Join(dir, "common.yaml")
+	if err := os.WriteFile(common, []byte("routes:\n  - gateway: 10.0.0.1\n    hosts: [1.1.1.1]\n"), 0644); err != nil {
+		t.Fatalf("write include: %v", err)
+	}
+	main := filepath.Join(dir, "main.yaml")
+	if err := os.WriteFile(main, []byte("routes:\n  - !include common.yaml\n"), 0644); err != nil {
+		t.Fatalf("write routes file: %v", err)
+	}
+	text := writeRoutesFile(t, "1.1.1.1\n")
+
+	got := withIncludes([]string{main, text, common})
+	if strings.Join(got, ",") != strings.Join([]string{main, text, common}, ",") {
+		t.Fatalf("watched files: got %v", got)
+	}
+	got = withIncludes([]string{main})
+	if len(got) != 2 || got[1] != common {
+		t.Fatalf("watched files: got %v", got)
+	}
+}
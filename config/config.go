@@ -1,33 +1,79 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
+	"github.com/zalando/go-keyring"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds the Keenetic router connection configuration.
 type Config struct {
-	Host     string `yaml:"host"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
+	Host     string `yaml:"host" json:"host"`
+	User     string `yaml:"user" json:"user"`
+	Password string `yaml:"password,omitempty" json:"password,omitempty"`
+	// PasswordRef tells where the password is stored instead of Password ("keyring").
+	PasswordRef string `yaml:"password_ref,omitempty" json:"password_ref,omitempty"`
+	// Insecure disables TLS certificate verification for https:// hosts.
+	Insecure bool `yaml:"insecure,omitempty" json:"insecure,omitempty"`
+	// Proxy is an explicit HTTP proxy URL; HTTP_PROXY/HTTPS_PROXY are used when empty.
+	Proxy string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	// BatchSize is the number of routes sent per request; zero means KEENETIC_BATCH_SIZE or the client default.
+	BatchSize int `yaml:"batch_size,omitempty" json:"batch_size,omitempty"`
+}
+
+// PasswordRefKeyring marks a password stored in the OS keyring under the user name.
+const PasswordRefKeyring = "keyring"
+
+const keyringService = "keenetic-routes"
+
+// StorePasswordInKeyring saves password in the OS keyring for user.
+func StorePasswordInKeyring(user, password string) error {
+	if err := keyring.Set(keyringService, user, password); err != nil {
+		return fmt.Errorf("store password in keyring: %w", err)
+	}
+	return nil
+}
+
+// File is the config file layout: the default profile at the top level plus named profiles.
+// The file is YAML unless its name ends in .json.
+type File struct {
+	Config   `yaml:",inline"`
+	Profiles map[string]Config `yaml:"profiles,omitempty" json:"profiles,omitempty"`
 }
 
 // LoadConfig loads configuration from multiple sources in priority order:
 // 1. Command line flags (passed as parameters)
-// 2. Config file (~/.config/keenetic-routes/config.yaml)
+// 2. Config file (KEENETIC_CONFIG_PATH or $XDG_CONFIG_HOME/keenetic-routes/config.yaml)
 // 3. Environment variables
 // 4. .env file in current directory
 func LoadConfig(hostFlag, userFlag, passwordFlag string) (*Config, error) {
-	cfg := &Config{}
+	return LoadConfigWithProfile("", hostFlag, userFlag, passwordFlag)
+}
 
-	configFile := getConfigFilePath()
-	if data, err := os.ReadFile(configFile); err == nil {
-		if err := yaml.Unmarshal(data, cfg); err != nil {
-			return nil, fmt.Errorf("parse config file %s: %w", configFile, err)
+// LoadConfigWithProfile is like LoadConfig but reads the named profile from the config file.
+// An empty profile selects the default (top-level) settings.
+func LoadConfigWithProfile(profile, hostFlag, userFlag, passwordFlag string) (*Config, error) {
+	cfg, err := LoadProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PasswordRef == PasswordRefKeyring && cfg.Password == "" {
+		password, err := keyring.Get(keyringService, cfg.User)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: read password from keyring: %v; falling back to other password sources\n", err)
+		} else {
+			cfg.Password = password
 		}
 	}
 
@@ -44,6 +90,9 @@ func LoadConfig(hostFlag, userFlag, passwordFlag string) (*Config, error) {
 	if cfg.Password == "" {
 		cfg.Password = os.Getenv("KEENETIC_PASSWORD")
 	}
+	if cfg.Proxy == "" {
+		cfg.Proxy = os.Getenv("KEENETIC_PROXY")
+	}
 
 	if hostFlag != "" {
 		cfg.Host = hostFlag
@@ -58,11 +107,106 @@ func LoadConfig(hostFlag, userFlag, passwordFlag string) (*Config, error) {
 	return cfg, nil
 }
 
+// LoadConfigFile loads the default profile from the config file only.
+// Returns an empty Config if the file does not exist.
+func LoadConfigFile() (*Config, error) {
+	return LoadProfile("")
+}
+
+// LoadProfile loads the named profile from the config file only. An empty name selects
+// the default profile. Returns an error if a named profile does not exist.
+func LoadProfile(profileName string) (*Config, error) {
+	f, err := LoadFile()
+	if err != nil {
+		return nil, err
+	}
+	if profileName == "" {
+		cfg := f.Config
+		return &cfg, nil
+	}
+	cfg, ok := f.Profiles[profileName]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found in %s", profileName, getConfigFilePath())
+	}
+	return &cfg, nil
+}
+
+// LoadFile reads the whole config file. Returns an empty File if it does not exist.
+func LoadFile() (*File, error) {
+	f := &File{}
+	configFile := getConfigFilePath()
+	if data, err := os.ReadFile(configFile); err == nil {
+		if err := unmarshalFile(configFile, data, f); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// LoadConfigFromFile reads the default profile from the config file at path.
+// The format is detected from the extension: .json is JSON, .yaml and .yml are YAML.
+func LoadConfigFromFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	f := &File{}
+	if err := unmarshalFile(path, data, f); err != nil {
+		return nil, err
+	}
+	cfg := f.Config
+	return &cfg, nil
+}
+
+func unmarshalFile(path string, data []byte, f *File) error {
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, f)
+	case ".yaml", ".yml", "":
+		err = yaml.Unmarshal(data, f)
+	default:
+		return fmt.Errorf("unsupported config file format %s (want .yaml, .yml or .json)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func marshalFile(path string, f *File) ([]byte, error) {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return yaml.Marshal(f)
+}
+
+// ProfileNames returns the sorted names of profiles defined in the config file.
+func (f *File) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Validate checks if all required configuration fields are set.
 func (c *Config) Validate() error {
 	if c.Host == "" {
 		return fmt.Errorf("host is required (set via flag, config file, or KEENETIC_HOST env var)")
 	}
+	_, port, err := net.SplitHostPort(HostAddr(c.Host))
+	if err != nil {
+		return fmt.Errorf("host must be host:port format (e.g. 192.168.100.1:280), got: %q", c.Host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("host port must be a number in range 1-65535, got: %q", port)
+	}
 	if c.User == "" {
 		return fmt.Errorf("user is required (set via flag, config file, or KEENETIC_USER env var)")
 	}
@@ -72,8 +216,43 @@ func (c *Config) Validate() error {
 	return nil
 }
 
-// SaveConfig saves configuration to the config file.
+// BaseURL returns the router base URL for host. Hosts without a scheme use http://.
+func BaseURL(host string) string {
+	if strings.Contains(host, "://") {
+		return strings.TrimSuffix(host, "/")
+	}
+	return "http://" + host
+}
+
+// HostAddr returns host without an http:// or https:// scheme prefix.
+func HostAddr(host string) string {
+	if _, rest, ok := strings.Cut(host, "://"); ok {
+		return strings.TrimSuffix(rest, "/")
+	}
+	return host
+}
+
+// SaveConfig saves configuration as the default profile, keeping named profiles intact.
 func SaveConfig(cfg *Config) error {
+	return SaveProfile("", cfg)
+}
+
+// SaveProfile saves configuration under the named profile (or the default one if empty),
+// keeping other profiles intact.
+func SaveProfile(profileName string, cfg *Config) error {
+	f, err := LoadFile()
+	if err != nil {
+		return err
+	}
+	if profileName == "" {
+		f.Config = *cfg
+	} else {
+		if f.Profiles == nil {
+			f.Profiles = make(map[string]Config)
+		}
+		f.Profiles[profileName] = *cfg
+	}
+
 	configFile := getConfigFilePath()
 	configDir := filepath.Dir(configFile)
 
@@ -81,7 +260,7 @@ func SaveConfig(cfg *Config) error {
 		return fmt.Errorf("create config directory: %w", err)
 	}
 
-	data, err := yaml.Marshal(cfg)
+	data, err := marshalFile(configFile, f)
 	if err != nil {
 		return fmt.Errorf("marshal config: %w", err)
 	}
@@ -93,15 +272,68 @@ func SaveConfig(cfg *Config) error {
 	return nil
 }
 
+// DeleteConfig removes the config file and the keyring passwords referenced by its profiles.
+// Returns an error wrapping os.ErrNotExist if there is no config file.
+func DeleteConfig() error {
+	configFile := getConfigFilePath()
+	f, err := LoadFile()
+	if err != nil {
+		// An unparsable file is still deleted; its keyring entries cannot be found.
+		f = &File{}
+	}
+	if err := os.Remove(configFile); err != nil {
+		return fmt.Errorf("delete config file: %w", err)
+	}
+	configs := []Config{f.Config}
+	for _, name := range f.ProfileNames() {
+		configs = append(configs, f.Profiles[name])
+	}
+	for _, cfg := range configs {
+		if cfg.PasswordRef != PasswordRefKeyring {
+			continue
+		}
+		if err := keyring.Delete(keyringService, cfg.User); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			return fmt.Errorf("delete password from keyring: %w", err)
+		}
+	}
+	return nil
+}
+
 // GetConfigFilePath returns the path to the configuration file.
 func GetConfigFilePath() string {
 	return getConfigFilePath()
 }
 
+// GetSessionFilePath returns the path to the router session file stored next to the config file.
+func GetSessionFilePath() string {
+	return filepath.Join(filepath.Dir(getConfigFilePath()), "session.json")
+}
+
 func getConfigFilePath() string {
-	homeDir, err := os.UserHomeDir()
+	if path := os.Getenv("KEENETIC_CONFIG_PATH"); path != "" {
+		return path
+	}
+	dir, err := configHome()
 	if err != nil {
 		return ".keenetic-routes-config.yaml"
 	}
-	return filepath.Join(homeDir, ".config", "keenetic-routes", "config.yaml")
+	return filepath.Join(dir, "keenetic-routes", "config.yaml")
+}
+
+// configHome returns the base config directory: $XDG_CONFIG_HOME, %APPDATA% on Windows,
+// or ~/.config otherwise.
+func configHome() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return dir, nil
+		}
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config"), nil
 }
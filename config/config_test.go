@@ -1,9 +1,12 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/zalando/go-keyring"
 )
 
 func writeFile(t *testing.T, path, content string) {
@@ -29,7 +32,8 @@ func withTempHome(t *testing.T, fn func(dir string)) {
 	t.Cleanup(func() {
 		_ = os.Chdir(oldWD)
 	})
-	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("KEENETIC_CONFIG_PATH", "")
 	fn(dir)
 }
 
@@ -82,7 +86,7 @@ func TestLoadConfig_PriorityAndMerging(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			withTempHome(t, func(dir string) {
 				if tt.configYAML != "" {
-					configPath := filepath.Join(dir, ".config", "keenetic-routes", "config.yaml")
+					configPath := filepath.Join(dir, "keenetic-routes", "config.yaml")
 					writeFile(t, configPath, tt.configYAML)
 				}
 				if tt.envFile != "" {
@@ -103,3 +107,211 @@ func TestLoadConfig_PriorityAndMerging(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "ok", cfg: Config{Host: "192.168.100.1:280", User: "admin", Password: "pass"}},
+		{name: "missing_host", cfg: Config{User: "admin", Password: "pass"}, wantErr: true},
+		{name: "missing_user", cfg: Config{Host: "192.168.100.1:280", Password: "pass"}, wantErr: true},
+		{name: "missing_password", cfg: Config{Host: "192.168.100.1:280", User: "admin"}, wantErr: true},
+		{name: "https_host", cfg: Config{Host: "https://router.example.com:443", User: "admin", Password: "pass"}},
+		{name: "host_without_port", cfg: Config{Host: "192.168.100.1", User: "admin", Password: "pass"}, wantErr: true},
+		{name: "non_numeric_port", cfg: Config{Host: "192.168.100.1:http", User: "admin", Password: "pass"}, wantErr: true},
+		{name: "port_zero", cfg: Config{Host: "192.168.100.1:0", User: "admin", Password: "pass"}, wantErr: true},
+		{name: "port_out_of_range", cfg: Config{Host: "192.168.100.1:65536", User: "admin", Password: "pass"}, wantErr: true},
+		{name: "ipv6_host", cfg: Config{Host: "[fd00::1]:280", User: "admin", Password: "pass"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	withTempHome(t, func(dir string) {
+		configPath := filepath.Join(dir, "keenetic-routes", "config.yaml")
+		writeFile(t, configPath, `host: 10.0.0.1:280
+user: default
+password: pass
+profiles:
+  office:
+    host: 10.1.0.1:280
+    user: office
+    password: officepass
+`)
+
+		cfg, err := LoadConfigWithProfile("office", "", "", "")
+		if err != nil {
+			t.Fatalf("LoadConfigWithProfile: %v", err)
+		}
+		if cfg.Host != "10.1.0.1:280" || cfg.User != "office" {
+			t.Fatalf("unexpected office profile: %+v", *cfg)
+		}
+		if _, err := LoadProfile("missing"); err == nil {
+			t.Fatalf("expected error for missing profile")
+		}
+
+		if err := SaveProfile("home", &Config{Host: "10.2.0.1:280", User: "home", Password: "homepass"}); err != nil {
+			t.Fatalf("SaveProfile: %v", err)
+		}
+		f, err := LoadFile()
+		if err != nil {
+			t.Fatalf("LoadFile: %v", err)
+		}
+		if f.Host != "10.0.0.1:280" {
+			t.Fatalf("default profile lost: %+v", f.Config)
+		}
+		if names := f.ProfileNames(); len(names) != 2 || names[0] != "home" || names[1] != "office" {
+			t.Fatalf("unexpected profile names: %v", names)
+		}
+	})
+}
+
+func TestLoadConfig_PasswordFromKeyring(t *testing.T) {
+	keyring.MockInit()
+	withTempHome(t, func(dir string) {
+		configPath := filepath.Join(dir, "keenetic-routes", "config.yaml")
+		writeFile(t, configPath, "host: 10.0.0.1:280\nuser: admin\npassword_ref: keyring\n")
+
+		if err := StorePasswordInKeyring("admin", "secret"); err != nil {
+			t.Fatalf("StorePasswordInKeyring: %v", err)
+		}
+		cfg, err := LoadConfig("", "", "")
+		if err != nil {
+			t.Fatalf("LoadConfig: %v", err)
+		}
+		if cfg.Password != "secret" {
+			t.Fatalf("password: got %q, want %q", cfg.Password, "secret")
+		}
+	})
+}
+
+func TestLoadConfig_KeyringFallback(t *testing.T) {
+	keyring.MockInitWithError(errors.New("keyring unavailable"))
+	withTempHome(t, func(dir string) {
+		configPath := filepath.Join(dir, "keenetic-routes", "config.yaml")
+		writeFile(t, configPath, "host: 10.0.0.1:280\nuser: admin\npassword_ref: keyring\n")
+		t.Setenv("KEENETIC_PASSWORD", "envpass")
+
+		cfg, err := LoadConfig("", "", "")
+		if err != nil {
+			t.Fatalf("LoadConfig: %v", err)
+		}
+		if cfg.Password != "envpass" {
+			t.Fatalf("password: got %q, want %q", cfg.Password, "envpass")
+		}
+	})
+}
+
+func TestDeleteConfig(t *testing.T) {
+	keyring.MockInit()
+	withTempHome(t, func(dir string) {
+		configPath := filepath.Join(dir, "keenetic-routes", "config.yaml")
+		writeFile(t, configPath, "host: 10.0.0.1:280\nuser: admin\npassword_ref: keyring\nprofiles:\n  office:\n    host: 10.0.0.2:280\n    user: office\n    password: secret\n")
+		if err := StorePasswordInKeyring("admin", "secret"); err != nil {
+			t.Fatalf("StorePasswordInKeyring: %v", err)
+		}
+
+		if err := DeleteConfig(); err != nil {
+			t.Fatalf("DeleteConfig: %v", err)
+		}
+		if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+			t.Fatalf("config file still exists: %v", err)
+		}
+		if _, err := keyring.Get(keyringService, "admin"); !errors.Is(err, keyring.ErrNotFound) {
+			t.Fatalf("keyring entry still exists: %v", err)
+		}
+		if err := DeleteConfig(); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected os.ErrNotExist, got %v", err)
+		}
+	})
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr bool
+	}{
+		{name: "yaml", file: "config.yaml", content: "host: 10.0.0.1:280\nuser: admin\npassword: secret\n"},
+		{name: "yml", file: "config.yml", content: "host: 10.0.0.1:280\nuser: admin\npassword: secret\n"},
+		{name: "json", file: "config.json", content: `{"host": "10.0.0.1:280", "user": "admin", "password": "secret"}`},
+		{name: "invalid_json", file: "config.json", content: "host: 10.0.0.1:280\n", wantErr: true},
+		{name: "unknown_extension", file: "config.toml", content: "host = \"10.0.0.1:280\"\n", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			writeFile(t, path, tc.content)
+
+			cfg, err := LoadConfigFromFile(path)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got config %+v", *cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfigFromFile: %v", err)
+			}
+			want := Config{Host: "10.0.0.1:280", User: "admin", Password: "secret"}
+			if *cfg != want {
+				t.Fatalf("config: got %+v, want %+v", *cfg, want)
+			}
+		})
+	}
+}
+
+func TestConfigFilePath_XDG(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("KEENETIC_CONFIG_PATH", "")
+
+	t.Setenv("XDG_CONFIG_HOME", "/tmp/xdg")
+	if got, want := GetConfigFilePath(), filepath.Join("/tmp/xdg", "keenetic-routes", "config.yaml"); got != want {
+		t.Fatalf("with XDG_CONFIG_HOME: got %q, want %q", got, want)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", "")
+	if got, want := GetConfigFilePath(), filepath.Join(home, ".config", "keenetic-routes", "config.yaml"); got != want {
+		t.Fatalf("without XDG_CONFIG_HOME: got %q, want %q", got, want)
+	}
+}
+
+func TestConfigPathEnvOverride(t *testing.T) {
+	withTempHome(t, func(dir string) {
+		configPath := filepath.Join(dir, "custom", "keenetic.yaml")
+		t.Setenv("KEENETIC_CONFIG_PATH", configPath)
+
+		if got := GetConfigFilePath(); got != configPath {
+			t.Fatalf("GetConfigFilePath: got %q, want %q", got, configPath)
+		}
+		want := Config{Host: "10.0.0.1:280", User: "admin", Password: "secret"}
+		if err := SaveConfig(&want); err != nil {
+			t.Fatalf("SaveConfig: %v", err)
+		}
+		if _, err := os.Stat(configPath); err != nil {
+			t.Fatalf("config not written to KEENETIC_CONFIG_PATH: %v", err)
+		}
+		cfg, err := LoadConfig("", "", "")
+		if err != nil {
+			t.Fatalf("LoadConfig: %v", err)
+		}
+		if *cfg != want {
+			t.Fatalf("config: got %+v, want %+v", *cfg, want)
+		}
+	})
+}
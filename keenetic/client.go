@@ -2,39 +2,160 @@ package keenetic
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
-const defaultTimeout = 30 * time.Second
+// Default per-operation timeouts.
+const (
+	defaultAuthTimeout = 10 * time.Second
+	defaultGetTimeout  = 15 * time.Second
+	defaultPostTimeout = 60 * time.Second
+)
+
+// Timeouts holds per-operation request timeouts. Zero values keep the defaults.
+type Timeouts struct {
+	Auth time.Duration
+	Get  time.Duration
+	Post time.Duration
+}
+
+// DefaultTimeouts returns the default per-operation timeouts.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{Auth: defaultAuthTimeout, Get: defaultGetTimeout, Post: defaultPostTimeout}
+}
 
 func newCookieJar() (http.CookieJar, error) {
 	return cookiejar.New(nil)
 }
 
+// Version is reported in the default User-Agent header. The CLI sets it to its own version.
+var Version = "dev"
+
+// DefaultUserAgent returns the User-Agent sent when none is set with WithUserAgent.
+func DefaultUserAgent() string {
+	return "keenetic-routes/" + Version
+}
+
+// RetryPolicy controls how failed requests are retried.
+// Zero MaxRetries disables retries.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+}
+
+// Logger receives one line per router request. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// LoggingOptions configures request logging of a Client.
+type LoggingOptions struct {
+	// Logger receives the method, URL, status code and duration of every request.
+	Logger Logger
+}
+
+// nopLogger discards everything; it is the default Logger.
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...any) {}
+
 // Client is an HTTP client for Keenetic NDMS RCI API with session auth.
 type Client struct {
-	baseURL    string
-	login      string
-	password   string
-	httpClient *http.Client
-	authed     bool
+	baseURL     string
+	login       string
+	password    string
+	httpClient  *http.Client
+	retryPolicy RetryPolicy
+	timeouts    Timeouts
+	userAgent   string
+	sessionFile string
+	batchSize   int
+	// skipSave omits the configuration save command from route changes.
+	skipSave bool
+	// authHook is called before every login attempt.
+	authHook func()
+	logging  LoggingOptions
+
+	mu     sync.Mutex // guards authed and serializes the auth flow
+	authed bool
 }
 
-// NewClient creates a client. baseURL should be "http://host:port" (e.g. "http://192.168.100.1:280").
+// NewClient creates a client. baseURL should be "http://host:port" (e.g. "http://192.168.100.1:280")
+// or "https://host:port".
 func NewClient(baseURL, login, password string) (*Client, error) {
 	return NewClientWithHTTPClient(baseURL, login, password, nil)
 }
 
+// NewClientTLS creates a client that uses tlsCfg when baseURL has the https:// scheme.
+// For http:// base URLs tlsCfg is ignored.
+func NewClientTLS(baseURL, login, password string, tlsCfg *tls.Config) (*Client, error) {
+	transport, err := NewTransport(baseURL, tlsCfg, "")
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithHTTPClient(baseURL, login, password, &http.Client{Transport: transport})
+}
+
+// NewClientWithProxy creates a client that sends requests through proxyURL.
+// If proxyURL is empty, HTTP_PROXY/HTTPS_PROXY/NO_PROXY env vars are used.
+func NewClientWithProxy(baseURL, login, password, proxyURL string) (*Client, error) {
+	transport, err := NewTransport(baseURL, nil, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithHTTPClient(baseURL, login, password, &http.Client{Transport: transport})
+}
+
+// NewTransport builds an HTTP transport for baseURL. tlsCfg is applied to https:// base URLs only;
+// an empty proxyURL falls back to proxy settings from the environment.
+func NewTransport(baseURL string, tlsCfg *tls.Config, proxyURL string) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if isHTTPS(baseURL) && tlsCfg != nil {
+		transport.TLSClientConfig = tlsCfg
+	}
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy URL: %w", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("parse proxy URL: %q must include scheme and host", proxyURL)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return transport, nil
+}
+
+// NewClientWithSessionFile creates a client that restores session cookies from sessionFile
+// and writes them back on SaveSession. A missing or unreadable session file is ignored.
+func NewClientWithSessionFile(baseURL, login, password, sessionFile string) (*Client, error) {
+	c, err := NewClient(baseURL, login, password)
+	if err != nil {
+		return nil, err
+	}
+	c.UseSessionFile(sessionFile)
+	return c, nil
+}
+
 // NewClientWithHTTPClient creates a client with a custom http.Client for testing.
 func NewClientWithHTTPClient(baseURL, login, password string, httpClient *http.Client) (*Client, error) {
 	jar, err := newCookieJar()
@@ -42,34 +163,128 @@ func NewClientWithHTTPClient(baseURL, login, password string, httpClient *http.C
 		return nil, fmt.Errorf("cookie jar: %w", err)
 	}
 	if httpClient == nil {
-		httpClient = &http.Client{
-			Timeout: defaultTimeout,
-			Jar:     jar,
-		}
-	} else {
-		if httpClient.Jar == nil {
-			httpClient.Jar = jar
-		}
-		if httpClient.Timeout == 0 {
-			httpClient.Timeout = defaultTimeout
-		}
+		httpClient = &http.Client{Jar: jar}
+	} else if httpClient.Jar == nil {
+		httpClient.Jar = jar
 	}
 	return &Client{
 		baseURL:    strings.TrimSuffix(baseURL, "/"),
 		login:      login,
 		password:   password,
 		httpClient: httpClient,
+		timeouts:   DefaultTimeouts(),
+		userAgent:  DefaultUserAgent(),
+		batchSize:  routeBatchSize,
+		logging:    LoggingOptions{Logger: nopLogger{}},
 	}, nil
 }
 
+// WithRetry enables retries of network timeouts and 5xx responses, waiting baseDelay*2^attempt between tries.
+// POST requests are retried only when they could not connect, since the router may have applied them.
+func (c *Client) WithRetry(maxRetries int, baseDelay time.Duration) *Client {
+	c.retryPolicy = RetryPolicy{MaxRetries: maxRetries, BaseDelay: baseDelay}
+	return c
+}
+
+// WithTimeouts sets per-operation timeouts. Zero fields keep their current values.
+func (c *Client) WithTimeouts(t Timeouts) *Client {
+	if t.Auth > 0 {
+		c.timeouts.Auth = t.Auth
+	}
+	if t.Get > 0 {
+		c.timeouts.Get = t.Get
+	}
+	if t.Post > 0 {
+		c.timeouts.Post = t.Post
+	}
+	return c
+}
+
+// WithUserAgent overrides the User-Agent header sent with every request.
+func (c *Client) WithUserAgent(ua string) *Client {
+	c.userAgent = ua
+	return c
+}
+
+// WithAuthHook sets fn to be called before every login attempt, e.g. to count them.
+func (c *Client) WithAuthHook(fn func()) *Client {
+	c.authHook = fn
+	return c
+}
+
+// WithLogger logs the method, URL, status code and duration of every request, including
+// auth requests, to l. A nil l disables logging.
+func (c *Client) WithLogger(l Logger) *Client {
+	if l == nil {
+		l = nopLogger{}
+	}
+	c.logging.Logger = l
+	return c
+}
+
+// WithRequestLog logs every HTTP request (method and URL) and response (status and body,
+// truncated to maxLoggedBody bytes) to w. Request bodies are not logged.
+func (c *Client) WithRequestLog(w io.Writer) *Client {
+	base := c.httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c.httpClient.Transport = &loggingTransport{base: base, out: w}
+	return c
+}
+
+// maxLoggedBody limits how much of a response body loggingTransport prints.
+const maxLoggedBody = 500
+
+// loggingTransport is an http.RoundTripper that logs requests and responses of base to out.
+type loggingTransport struct {
+	base http.RoundTripper
+	out  io.Writer
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fmt.Fprintf(t.out, "> %s %s\n", req.Method, req.URL)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(t.out, "< error: %v\n", err)
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		fmt.Fprintf(t.out, "< %s (read body: %v)\n", resp.Status, err)
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	body := string(data)
+	if len(body) > maxLoggedBody {
+		body = body[:maxLoggedBody] + "..."
+	}
+	fmt.Fprintf(t.out, "< %s %s\n", resp.Status, body)
+	return resp, nil
+}
+
+func isHTTPS(baseURL string) bool {
+	return strings.HasPrefix(strings.ToLower(baseURL), "https://")
+}
+
 // auth performs NDMS auth: GET auth, on 401 compute MD5(login:realm:password) then SHA256(challenge+md5_hex), POST auth.
-func (c *Client) auth() error {
+// It holds c.mu for the whole flow so concurrent callers authenticate only once.
+func (c *Client) auth(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.authed {
 		return nil
 	}
-	getResp, err := c.httpClient.Get(c.baseURL + "/auth")
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Auth)
+	defer cancel()
+	getReq, err := c.newRequest(ctx, http.MethodGet, c.baseURL+"/auth", nil)
+	if err != nil {
+		return fmt.Errorf("auth GET: new request: %w", err)
+	}
+	getResp, err := c.do(getReq)
 	if err != nil {
-		return fmt.Errorf("auth GET: %w", err)
+		return newNetworkError("auth GET", err)
 	}
 	defer getResp.Body.Close()
 
@@ -78,12 +293,14 @@ func (c *Client) auth() error {
 		return nil
 	}
 	if getResp.StatusCode != http.StatusUnauthorized {
-		return fmt.Errorf("auth GET: unexpected status %d", getResp.StatusCode)
+		// Not a credentials problem: the router failed or is not an NDMS router.
+		data, _ := io.ReadAll(getResp.Body)
+		return newStatusError("auth GET", getResp.StatusCode, data)
 	}
 	realm := getResp.Header.Get("X-NDM-Realm")
 	challenge := getResp.Header.Get("X-NDM-Challenge")
 	if realm == "" || challenge == "" {
-		return fmt.Errorf("auth: missing X-NDM-Realm or X-NDM-Challenge")
+		return newAuthError(getResp.StatusCode, "auth: missing X-NDM-Realm or X-NDM-Challenge")
 	}
 	md5Sum := md5.Sum([]byte(c.login + ":" + realm + ":" + c.password))
 	md5Hex := hex.EncodeToString(md5Sum[:])
@@ -96,27 +313,29 @@ func (c *Client) auth() error {
 	if err != nil {
 		return fmt.Errorf("auth POST: marshal body: %w", err)
 	}
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/auth", bytes.NewReader(bodyBytes))
+	req, err := c.newRequest(ctx, http.MethodPost, c.baseURL+"/auth", bodyBytes)
 	if err != nil {
 		return fmt.Errorf("auth POST: new request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if c.authHook != nil {
+		c.authHook()
+	}
 	// Use same client so cookies from GET are sent and new ones from POST are stored
-	postResp, err := c.httpClient.Do(req)
+	postResp, err := c.do(req)
 	if err != nil {
-		return fmt.Errorf("auth POST: %w", err)
+		return newNetworkError("auth POST", err)
 	}
 	defer postResp.Body.Close()
 	if postResp.StatusCode != http.StatusOK {
-		return fmt.Errorf("auth POST: status %d", postResp.StatusCode)
+		return newAuthError(postResp.StatusCode, "auth POST: status %d", postResp.StatusCode)
 	}
 	c.authed = true
 	return nil
 }
 
 // Request performs a request after ensuring auth. GET if body is nil, POST with JSON body otherwise.
-func (c *Client) Request(query string, body interface{}) ([]byte, error) {
-	if err := c.auth(); err != nil {
+func (c *Client) Request(ctx context.Context, query string, body interface{}) ([]byte, error) {
+	if err := c.auth(ctx); err != nil {
 		return nil, err
 	}
 	u, err := url.JoinPath(c.baseURL, query)
@@ -131,48 +350,311 @@ func (c *Client) Request(query string, body interface{}) ([]byte, error) {
 		}
 	}
 
-	status, data, err := c.doRequest(u, query, bodyBytes)
+	status, data, err := c.doRequestWithRetry(ctx, u, query, bodyBytes)
 	if err != nil {
 		return nil, err
 	}
 	if status == http.StatusUnauthorized {
-		c.authed = false
-		if err := c.auth(); err != nil {
+		c.resetAuth()
+		if err := c.auth(ctx); err != nil {
 			return nil, err
 		}
-		status, data, err = c.doRequest(u, query, bodyBytes)
+		status, data, err = c.doRequestWithRetry(ctx, u, query, bodyBytes)
 		if err != nil {
 			return nil, err
 		}
 	}
 	if status != http.StatusOK {
-		return nil, fmt.Errorf("request %s: status %d: %s", query, status, string(data))
+		return nil, newStatusError("request "+query, status, data)
 	}
 	return data, nil
 }
 
-func (c *Client) doRequest(u, query string, bodyBytes []byte) (int, []byte, error) {
-	var req *http.Request
-	var err error
-	if bodyBytes == nil {
-		req, err = http.NewRequest(http.MethodGet, u, nil)
-	} else {
-		req, err = http.NewRequest(http.MethodPost, u, bytes.NewReader(bodyBytes))
-		if err == nil {
-			req.Header.Set("Content-Type", "application/json")
+// resetAuth marks the session as expired so the next auth call re-authenticates.
+func (c *Client) resetAuth() {
+	c.mu.Lock()
+	c.authed = false
+	c.mu.Unlock()
+}
+
+// doRequestWithRetry calls doRequest, retrying according to the client's retry policy:
+// GET requests on transient network errors and 5xx responses, POST requests only when
+// they failed to connect.
+func (c *Client) doRequestWithRetry(ctx context.Context, u, query string, bodyBytes []byte) (int, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		status, data, err := c.doRequest(ctx, u, query, bodyBytes)
+		var retryable bool
+		if bodyBytes == nil {
+			retryable = (err != nil && isTransientError(err)) || (err == nil && status >= http.StatusInternalServerError)
+		} else {
+			retryable = err != nil && isConnectError(err)
 		}
+		if !retryable || attempt >= c.retryPolicy.MaxRetries || ctx.Err() != nil {
+			return status, data, err
+		}
+		delay := c.retryPolicy.BaseDelay * time.Duration(1<<attempt)
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isTransientError reports whether a request error is a network timeout or reset worth retrying.
+// A canceled or expired context is not: another attempt would fail the same way.
+func isTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
 	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isConnectError reports whether a request error happened before the request reached the
+// router, so that resending it cannot apply it twice.
+func isConnectError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// newRequest builds a request with the client's User-Agent and a JSON body if bodyBytes is non-nil.
+func (c *Client) newRequest(ctx context.Context, method, u string, bodyBytes []byte) (*http.Request, error) {
+	var body io.Reader
+	if bodyBytes != nil {
+		body = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
 	if err != nil {
-		return 0, nil, fmt.Errorf("new request: %w", err)
+		return nil, err
+	}
+	if bodyBytes != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
+	req.Header.Set("User-Agent", c.userAgent)
+	return req, nil
+}
+
+// do sends req and logs its outcome and duration to the client's logger.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
+	elapsed := time.Since(start).Milliseconds()
+	if err != nil {
+		c.logging.Logger.Printf("%s %s: error: %v (%dms)", req.Method, req.URL, err, elapsed)
+		return nil, err
+	}
+	c.logging.Logger.Printf("%s %s: %d (%dms)", req.Method, req.URL, resp.StatusCode, elapsed)
+	return resp, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, u, query string, bodyBytes []byte) (int, []byte, error) {
+	timeout := c.timeouts.Post
+	if bodyBytes == nil {
+		timeout = c.timeouts.Get
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	method := http.MethodPost
+	if bodyBytes == nil {
+		method = http.MethodGet
+	}
+	req, err := c.newRequest(ctx, method, u, bodyBytes)
+	if err != nil {
+		return 0, nil, fmt.Errorf("new request: %w", err)
+	}
+	resp, err := c.do(req)
 	if err != nil {
-		return 0, nil, fmt.Errorf("request %s: %w", query, err)
+		return 0, nil, newNetworkError("request "+query, err)
 	}
 	defer resp.Body.Close()
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, newNetworkError("read response "+query, err)
 	}
 	return resp.StatusCode, data, nil
 }
+
+// GetInterfaces returns the names of router interfaces (GET rci/ip/interface), sorted.
+// The response may be an object keyed by interface name or a list of objects with an id or name.
+func (c *Client) GetInterfaces(ctx context.Context) ([]string, error) {
+	data, err := c.Request(ctx, "rci/ip/interface", nil)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var byName map[string]json.RawMessage
+	if err := json.Unmarshal(data, &byName); err == nil {
+		for name := range byName {
+			names = append(names, name)
+		}
+	} else {
+		var list []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("decode interfaces: %w", err)
+		}
+		for _, iface := range list {
+			name := iface.ID
+			if name == "" {
+				name = iface.Name
+			}
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Ping verifies connectivity and credentials with a cheap read-only request.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.showVersion(ctx)
+	return err
+}
+
+// FirmwareVersion returns the NDMS release string (GET rci/show/version).
+func (c *Client) FirmwareVersion(ctx context.Context) (string, error) {
+	info, err := c.showVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.FirmwareVersion, nil
+}
+
+// showVersion returns the firmware version and model from GET rci/show/version.
+// Uptime is left zero.
+func (c *Client) showVersion(ctx context.Context) (*SystemInfo, error) {
+	data, err := c.Request(ctx, "rci/show/version", nil)
+	if err != nil {
+		return nil, err
+	}
+	var v struct {
+		Release Stringish `json:"release"`
+		Title   Stringish `json:"title"`
+		Model   Stringish `json:"model"`
+		Device  Stringish `json:"device"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("decode version: %w", err)
+	}
+	info := &SystemInfo{FirmwareVersion: v.Release.String(), Model: v.Model.String()}
+	if info.FirmwareVersion == "" {
+		info.FirmwareVersion = v.Title.String()
+	}
+	if info.Model == "" {
+		info.Model = v.Device.String()
+	}
+	return info, nil
+}
+
+// MinSupportedFirmware is the oldest NDMS release whose RCI API this client is tested against.
+const MinSupportedFirmware = "3.0"
+
+// CompatibilityCheck fetches the router firmware version with a single request and
+// returns an *UnsupportedFirmwareError if it is older than MinSupportedFirmware.
+// Other errors mean the version could not be read.
+func (c *Client) CompatibilityCheck(ctx context.Context) error {
+	version, err := c.FirmwareVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("read firmware version: %w", err)
+	}
+	if version == "" {
+		return errors.New("read firmware version: router reported no version")
+	}
+	return CheckFirmware(version)
+}
+
+// CheckFirmware returns an *UnsupportedFirmwareError if version is older than
+// MinSupportedFirmware. Versions it cannot parse are accepted.
+func CheckFirmware(version string) error {
+	if compareVersions(version, MinSupportedFirmware) < 0 {
+		return &UnsupportedFirmwareError{Code: CodeUnsupportedFirmware, Version: version, Minimum: MinSupportedFirmware}
+	}
+	return nil
+}
+
+// compareVersions compares the leading numeric components of two NDMS versions such as
+// "4.1.7" or "2.16.D.12.0-3", returning -1, 0 or 1. A version without a leading number
+// compares equal to anything.
+func compareVersions(a, b string) int {
+	va, vb := versionNumbers(a), versionNumbers(b)
+	if len(va) == 0 || len(vb) == 0 {
+		return 0
+	}
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionNumbers returns the dot-separated numeric components of v up to the first
+// non-numeric one.
+func versionNumbers(v string) []int {
+	var nums []int
+	for _, part := range strings.Split(strings.TrimSpace(v), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			break
+		}
+		nums = append(nums, n)
+	}
+	return nums
+}
+
+// SystemInfo describes the router hardware and firmware.
+type SystemInfo struct {
+	// FirmwareVersion is the NDMS release, e.g. "4.1.7".
+	FirmwareVersion string
+	// Model is the marketing model name, e.g. "Keenetic Giga", or the device code.
+	Model  string
+	Uptime time.Duration
+}
+
+// GetSystemInfo returns the firmware version and model (GET rci/show/version) and
+// the uptime (GET rci/show/system) of the router. Callers that need only the version
+// should use FirmwareVersion, which makes a single request.
+func (c *Client) GetSystemInfo(ctx context.Context) (*SystemInfo, error) {
+	info, err := c.showVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.Request(ctx, "rci/show/system", nil)
+	if err != nil {
+		return nil, err
+	}
+	var sys struct {
+		Uptime Intish `json:"uptime"`
+	}
+	if err := json.Unmarshal(data, &sys); err != nil {
+		return nil, fmt.Errorf("decode system: %w", err)
+	}
+	info.Uptime = time.Duration(sys.Uptime) * time.Second
+	return info, nil
+}
@@ -1,15 +1,24 @@
 package keenetic
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/vladpi/keenetic-routes/routes"
 )
@@ -73,7 +82,7 @@ func TestClientGetRoutesAuthFlow(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewClientWithHTTPClient: %v", err)
 	}
-	routes, err := client.GetDomainRoutes()
+	routes, err := client.GetDomainRoutes(context.Background())
 	if err != nil {
 		t.Fatalf("GetDomainRoutes: %v", err)
 	}
@@ -88,6 +97,74 @@ func TestClientGetRoutesAuthFlow(t *testing.T) {
 	}
 }
 
+func TestClientTypedErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" && r.Method == http.MethodGet {
+			w.Header().Set("X-NDM-Realm", "realm")
+			w.Header().Set("X-NDM-Challenge", "challenge")
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "wrong", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	_, err = client.GetRoutes(context.Background())
+	var authErr *AuthError
+	if !errors.As(err, &authErr) || authErr.Code != CodeAuthFailed || authErr.Status != http.StatusUnauthorized {
+		t.Fatalf("expected AuthError with status 401, got %#v", err)
+	}
+	if !errors.Is(err, &AuthError{}) || errors.Is(err, &NetworkError{}) {
+		t.Fatalf("errors.Is mismatch for %v", err)
+	}
+
+	server.Close()
+	client, err = NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	_, err = client.GetRoutes(context.Background())
+	var netErr *NetworkError
+	if !errors.As(err, &netErr) || netErr.Code != CodeNetwork || netErr.Err == nil {
+		t.Fatalf("expected NetworkError, got %#v", err)
+	}
+	if !errors.Is(err, &NetworkError{}) {
+		t.Fatalf("errors.Is mismatch for %v", err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		authStatus int
+		rciStatus  int
+	}{
+		{name: "auth GET 503", authStatus: http.StatusServiceUnavailable},
+		{name: "request 400", authStatus: http.StatusOK, rciStatus: http.StatusBadRequest},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/auth" {
+				w.WriteHeader(tt.authStatus)
+				return
+			}
+			w.WriteHeader(tt.rciStatus)
+			_, _ = w.Write([]byte("bad request"))
+		}))
+		client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+		if err != nil {
+			t.Fatalf("NewClientWithHTTPClient: %v", err)
+		}
+		_, err = client.GetRoutes(context.Background())
+		server.Close()
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.Status == 0 || errors.Is(err, &AuthError{}) {
+			t.Fatalf("%s: expected StatusError, got %#v", tt.name, err)
+		}
+		if got := ErrorCode(err); got != CodeHTTPStatus {
+			t.Fatalf("%s: ErrorCode = %q", tt.name, got)
+		}
+	}
+}
+
 func TestClientAddRoutesBatching(t *testing.T) {
 	var mu sync.Mutex
 	var payloadLens []int
@@ -140,7 +217,7 @@ func TestClientAddRoutesBatching(t *testing.T) {
 			Gateway: "10.0.0.1",
 		}
 	}
-	if err := client.AddRoutes(entries); err != nil {
+	if err := client.AddRoutes(context.Background(), entries); err != nil {
 		t.Fatalf("AddRoutes: %v", err)
 	}
 
@@ -160,6 +237,47 @@ func TestClientAddRoutesBatching(t *testing.T) {
 	}
 }
 
+func TestClientAddRoutesCustomBatchSize(t *testing.T) {
+	var mu sync.Mutex
+	posts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/", "/rci":
+			if r.Method == http.MethodPost {
+				mu.Lock()
+				posts++
+				mu.Unlock()
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	client.WithBatchSize(10)
+	entries := make([]routes.Route, 25)
+	for i := range entries {
+		entries[i] = routes.Route{Host: fmt.Sprintf("10.0.0.%d", i+1), Gateway: "10.0.0.1"}
+	}
+	if err := client.AddRoutes(context.Background(), entries); err != nil {
+		t.Fatalf("AddRoutes: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 3 {
+		t.Fatalf("expected 3 POST requests, got %d", posts)
+	}
+}
+
 func TestClientDeleteAllRoutesEmpty(t *testing.T) {
 	var mu sync.Mutex
 	var deletePayloadLen int
@@ -202,7 +320,7 @@ func TestClientDeleteAllRoutesEmpty(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewClientWithHTTPClient: %v", err)
 	}
-	if err := client.DeleteAllRoutes(); err != nil {
+	if err := client.DeleteAllRoutes(context.Background()); err != nil {
 		t.Fatalf("DeleteAllRoutes: %v", err)
 	}
 
@@ -215,3 +333,1210 @@ func TestClientDeleteAllRoutesEmpty(t *testing.T) {
 		t.Fatalf("unexpected payload length: %d", deletePayloadLen)
 	}
 }
+
+func TestClientDeleteAllRoutesEmptyNoSave(t *testing.T) {
+	var mu sync.Mutex
+	var posts int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{})
+		case "/rci/", "/rci":
+			mu.Lock()
+			posts++
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	client.SetAutoSave(false)
+	if err := client.DeleteAllRoutes(context.Background()); err != nil {
+		t.Fatalf("DeleteAllRoutes: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 0 {
+		t.Fatalf("expected no POST for an empty router without save, got %d", posts)
+	}
+}
+
+func TestClientConcurrentAuth(t *testing.T) {
+	var mu sync.Mutex
+	var authPosts int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			if r.Method == http.MethodGet {
+				if _, err := r.Cookie("session"); err == nil {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				w.Header().Set("X-NDM-Realm", "realm")
+				w.Header().Set("X-NDM-Challenge", "challenge")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			mu.Lock()
+			authPosts++
+			mu.Unlock()
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetRoutes(context.Background()); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("GetRoutes: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if authPosts != 1 {
+		t.Fatalf("expected 1 auth POST, got %d", authPosts)
+	}
+}
+
+func TestClientRequestCanceledContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Request(ctx, "rci/ip/route", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNewClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientTLS(server.URL, "user", "pass", nil)
+	if err != nil {
+		t.Fatalf("NewClientTLS: %v", err)
+	}
+	if _, err := client.GetRoutes(context.Background()); err == nil {
+		t.Fatalf("expected certificate verification error, got nil")
+	}
+
+	client, err = NewClientTLS(server.URL, "user", "pass", &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("NewClientTLS: %v", err)
+	}
+	if _, err := client.GetRoutes(context.Background()); err != nil {
+		t.Fatalf("GetRoutes: %v", err)
+	}
+}
+
+func TestNewClientWithProxy(t *testing.T) {
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer proxy.Close()
+
+	client, err := NewClientWithProxy("http://router.invalid:280", "user", "pass", proxy.URL)
+	if err != nil {
+		t.Fatalf("NewClientWithProxy: %v", err)
+	}
+	if _, err := client.GetRoutes(context.Background()); err != nil {
+		t.Fatalf("GetRoutes: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(proxied) != 2 || proxied[1] != "http://router.invalid:280/rci/ip/route" {
+		t.Fatalf("unexpected proxied requests: %v", proxied)
+	}
+
+	if _, err := NewClientWithProxy("http://router.invalid:280", "user", "pass", "not a url"); err == nil {
+		t.Fatalf("expected error for invalid proxy URL")
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		failCode  int
+		retries   int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "no_retry_by_default", failures: 1, failCode: http.StatusServiceUnavailable, retries: 0, wantCalls: 1, wantErr: true},
+		{name: "recovers_from_5xx", failures: 2, failCode: http.StatusBadGateway, retries: 3, wantCalls: 3},
+		{name: "gives_up_on_5xx", failures: 5, failCode: http.StatusInternalServerError, retries: 2, wantCalls: 3, wantErr: true},
+		{name: "no_retry_on_4xx", failures: 5, failCode: http.StatusBadRequest, retries: 3, wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/auth":
+					w.WriteHeader(http.StatusOK)
+				case "/rci/ip/route":
+					mu.Lock()
+					calls++
+					n := calls
+					mu.Unlock()
+					if n <= tt.failures {
+						w.WriteHeader(tt.failCode)
+						return
+					}
+					w.Header().Set("Content-Type", "application/json")
+					_ = json.NewEncoder(w).Encode([]Route{})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+			if err != nil {
+				t.Fatalf("NewClientWithHTTPClient: %v", err)
+			}
+			client.WithRetry(tt.retries, time.Millisecond)
+			_, err = client.GetRoutes(context.Background())
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("GetRoutes: %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if calls != tt.wantCalls {
+				t.Fatalf("calls: got %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// failingTransport fails the first failures POST requests with err before sending them on.
+type failingTransport struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	posts    int
+}
+
+func (f *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPost {
+		f.mu.Lock()
+		f.posts++
+		fail := f.posts <= f.failures
+		f.mu.Unlock()
+		if fail {
+			return nil, f.err
+		}
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientRetryPOST(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		status    int
+		wantPosts int
+		wantErr   bool
+	}{
+		{name: "retries_dial_error", failures: 2, err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, status: http.StatusOK, wantPosts: 3},
+		{name: "no_retry_on_reset", failures: 1, err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, status: http.StatusOK, wantPosts: 1, wantErr: true},
+		{name: "no_retry_on_5xx", status: http.StatusServiceUnavailable, wantPosts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/auth":
+					w.WriteHeader(http.StatusOK)
+				case "/rci/", "/rci":
+					w.WriteHeader(tt.status)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			transport := &failingTransport{failures: tt.failures, err: tt.err}
+			client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{Transport: transport})
+			if err != nil {
+				t.Fatalf("NewClientWithHTTPClient: %v", err)
+			}
+			client.WithRetry(3, time.Millisecond)
+			_, err = client.Request(context.Background(), "rci/", []any{map[string]any{"system": map[string]any{"configuration": map[string]any{"save": true}}}})
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("Request: %v", err)
+			}
+			transport.mu.Lock()
+			defer transport.mu.Unlock()
+			if transport.posts != tt.wantPosts {
+				t.Fatalf("POST requests: got %d, want %d", transport.posts, tt.wantPosts)
+			}
+		})
+	}
+}
+
+func TestClientRetryStopsOnContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts Timeouts
+		cancel   bool
+		wantErr  error
+	}{
+		{name: "request_timeout", timeouts: Timeouts{Get: 10 * time.Millisecond}, wantErr: context.DeadlineExceeded},
+		{name: "canceled", cancel: true, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var mu sync.Mutex
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/auth":
+					w.WriteHeader(http.StatusOK)
+				case "/rci/ip/route":
+					mu.Lock()
+					calls++
+					mu.Unlock()
+					if tt.cancel {
+						cancel()
+					}
+					select {
+					case <-r.Context().Done():
+					case <-time.After(time.Second):
+					}
+					w.WriteHeader(http.StatusServiceUnavailable)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+			if err != nil {
+				t.Fatalf("NewClientWithHTTPClient: %v", err)
+			}
+			client.WithRetry(3, time.Millisecond)
+			if tt.timeouts != (Timeouts{}) {
+				client.WithTimeouts(tt.timeouts)
+			}
+			if _, err := client.GetRoutes(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if calls != 1 {
+				t.Fatalf("calls: got %d, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestClientTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{})
+		case "/rci/", "/rci":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	client.WithTimeouts(Timeouts{Get: 10 * time.Millisecond})
+	if _, err := client.GetRoutes(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if err := client.AddRoutes(context.Background(), []routes.Route{{Host: "8.8.8.8", Gateway: "10.0.0.1"}}); err != nil {
+		t.Fatalf("AddRoutes: %v", err)
+	}
+}
+
+func TestClientUserAgent(t *testing.T) {
+	var mu sync.Mutex
+	var agents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.UserAgent())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	if _, err := client.GetRoutes(context.Background()); err != nil {
+		t.Fatalf("GetRoutes: %v", err)
+	}
+	client.WithUserAgent("custom/1.0")
+	if _, err := client.GetRoutes(context.Background()); err != nil {
+		t.Fatalf("GetRoutes: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{DefaultUserAgent(), DefaultUserAgent(), "custom/1.0"}
+	if fmt.Sprint(agents) != fmt.Sprint(want) {
+		t.Fatalf("user agents: got %v, want %v", agents, want)
+	}
+}
+
+func TestClientRequestLog(t *testing.T) {
+	list := make([]Route, 20)
+	for i := range list {
+		list[i] = Route{Host: strPtr(fmt.Sprintf("10.0.0.%d", i+1)), Gateway: strPtr("192.168.1.1")}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(list)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	var log bytes.Buffer
+	client.WithRequestLog(&log)
+	got, err := client.GetRoutes(context.Background())
+	if err != nil {
+		t.Fatalf("GetRoutes: %v", err)
+	}
+	if len(got) != len(list) {
+		t.Fatalf("logging changed the response: got %d routes", len(got))
+	}
+	for _, want := range []string{"> GET " + server.URL + "/auth\n", "> GET " + server.URL + "/rci/ip/route\n", "< 200 OK [{", "...\n"} {
+		if !strings.Contains(log.String(), want) {
+			t.Fatalf("log missing %q:\n%s", want, log.String())
+		}
+	}
+	for _, line := range strings.Split(log.String(), "\n") {
+		if len(line) > len("< 200 OK ")+maxLoggedBody+len("...") {
+			t.Fatalf("response body not truncated: %q", line)
+		}
+	}
+}
+
+// recordingLogger collects formatted log lines.
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...any) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestClientWithLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	logger := &recordingLogger{}
+	client.WithLogger(logger)
+	if _, err := client.GetRoutes(context.Background()); err != nil {
+		t.Fatalf("GetRoutes: %v", err)
+	}
+	if _, err := client.Request(context.Background(), "rci/missing", nil); err == nil {
+		t.Fatalf("expected error for missing endpoint")
+	}
+	wantPrefixes := []string{
+		"GET " + server.URL + "/auth: 200 (",
+		"GET " + server.URL + "/rci/ip/route: 200 (",
+		"GET " + server.URL + "/rci/missing: 404 (",
+	}
+	if len(logger.lines) != len(wantPrefixes) {
+		t.Fatalf("got %d log lines, want %d: %q", len(logger.lines), len(wantPrefixes), logger.lines)
+	}
+	for i, want := range wantPrefixes {
+		if !strings.HasPrefix(logger.lines[i], want) || !strings.HasSuffix(logger.lines[i], "ms)") {
+			t.Fatalf("line %d = %q, want prefix %q and duration", i, logger.lines[i], want)
+		}
+	}
+
+	// A nil logger restores the no-op default.
+	client.WithLogger(nil)
+	if _, err := client.GetRoutes(context.Background()); err != nil {
+		t.Fatalf("GetRoutes: %v", err)
+	}
+	if len(logger.lines) != len(wantPrefixes) {
+		t.Fatalf("logging continued after WithLogger(nil): %q", logger.lines)
+	}
+}
+
+func TestClientSessionFile(t *testing.T) {
+	var mu sync.Mutex
+	var authPosts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			if r.Method == http.MethodGet {
+				if c, err := r.Cookie("session"); err == nil && c.Value == "ok" {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				w.Header().Set("X-NDM-Realm", "realm")
+				w.Header().Set("X-NDM-Challenge", "challenge")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			mu.Lock()
+			authPosts++
+			mu.Unlock()
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sessionFile := filepath.Join(t.TempDir(), "session.json")
+	hookCalls := 0
+	for i := 0; i < 2; i++ {
+		client, err := NewClientWithSessionFile(server.URL, "user", "pass", sessionFile)
+		if err != nil {
+			t.Fatalf("NewClientWithSessionFile: %v", err)
+		}
+		client.WithAuthHook(func() { hookCalls++ })
+		if _, err := client.GetRoutes(context.Background()); err != nil {
+			t.Fatalf("GetRoutes: %v", err)
+		}
+		if err := client.SaveSession(); err != nil {
+			t.Fatalf("SaveSession: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if authPosts != 1 {
+		t.Fatalf("expected 1 auth POST across invocations, got %d", authPosts)
+	}
+	if hookCalls != 1 {
+		t.Fatalf("expected 1 auth hook call, got %d", hookCalls)
+	}
+}
+
+func TestClientAddRoutesBatchErrorOffset(t *testing.T) {
+	var mu sync.Mutex
+	var batchFirstHosts []string
+	rciCalls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/", "/rci":
+			var payload []map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			rciCalls++
+			// The second batch is rejected once.
+			if rciCalls == 2 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			ip := payload[0]["ip"].(map[string]any)
+			route := ip["route"].(map[string]any)
+			batchFirstHosts = append(batchFirstHosts, route["host"].(string))
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	entries := make([]routes.Route, routeBatchSize*3)
+	for i := range entries {
+		entries[i] = routes.Route{Host: fmt.Sprintf("10.0.%d.%d", i/250, i%250+1), Gateway: "10.0.0.1"}
+	}
+	err = client.AddRoutes(context.Background(), entries)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected *BatchError, got %v", err)
+	}
+	if batchErr.Offset != routeBatchSize {
+		t.Fatalf("Offset = %d, want %d", batchErr.Offset, routeBatchSize)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("add routes batch at %d: ", routeBatchSize)) {
+		t.Fatalf("unexpected message: %v", err)
+	}
+
+	if err := client.AddRoutes(context.Background(), entries[batchErr.Offset:]); err != nil {
+		t.Fatalf("resume AddRoutes: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{entries[0].Host, entries[routeBatchSize].Host, entries[2*routeBatchSize].Host}
+	if fmt.Sprint(batchFirstHosts) != fmt.Sprint(want) {
+		t.Fatalf("batches sent: got %v, want %v", batchFirstHosts, want)
+	}
+}
+
+func TestClientGetRoutesFiltered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{
+				{Host: strPtr("1.1.1.1"), Gateway: strPtr("10.0.0.1"), Comment: strPtr("a")},
+				{Host: strPtr("2.2.2.2"), Interface: strPtr("Wireguard1"), Comment: strPtr("b")},
+				{Host: strPtr("3.3.3.3"), Gateway: strPtr("10.0.0.1"), Comment: strPtr("b")},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	ctx := context.Background()
+
+	byGateway, err := client.GetRoutesByGateway(ctx, "10.0.0.1")
+	if err != nil || len(byGateway) != 2 {
+		t.Fatalf("GetRoutesByGateway: got %d routes, err %v", len(byGateway), err)
+	}
+	byIface, err := client.GetRoutesByInterface(ctx, "Wireguard1")
+	if err != nil || len(byIface) != 1 || byIface[0].HostValue() != "2.2.2.2" {
+		t.Fatalf("GetRoutesByInterface: got %+v, err %v", byIface, err)
+	}
+	byComment, err := client.GetRoutesByComment(ctx, "b")
+	if err != nil || len(byComment) != 2 {
+		t.Fatalf("GetRoutesByComment: got %d routes, err %v", len(byComment), err)
+	}
+	count, err := client.GetRoutesCount(ctx)
+	if err != nil || count != 3 {
+		t.Fatalf("GetRoutesCount: got %d, err %v", count, err)
+	}
+	if RouteCountChanged(count, 3) || !RouteCountChanged(count, 2) {
+		t.Fatalf("RouteCountChanged: unexpected result")
+	}
+}
+
+func TestClientRoutesExist(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{
+				{Host: strPtr("1.1.1.1"), Gateway: strPtr("10.0.0.1")},
+				{Network: strPtr("10.0.0.0"), Mask: strPtr("255.0.0.0"), Interface: strPtr("Wireguard1")},
+				{Network: strPtr("2.2.2.2"), Mask: strPtr("255.255.255.255"), Gateway: strPtr("10.0.0.1")},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	ctx := context.Background()
+
+	exists, err := client.RouteExists(ctx, "10.0.0.0/8")
+	if err != nil || !exists {
+		t.Fatalf("RouteExists(10.0.0.0/8): got %v, err %v", exists, err)
+	}
+	found, err := client.RoutesExist(ctx, []string{"1.1.1.1/32", "2.2.2.2", "10.0.0.0/16", "3.3.3.3"})
+	if err != nil {
+		t.Fatalf("RoutesExist: %v", err)
+	}
+	want := map[string]bool{"1.1.1.1/32": true, "2.2.2.2": true, "10.0.0.0/16": false, "3.3.3.3": false}
+	for h, w := range want {
+		if found[h] != w {
+			t.Fatalf("RoutesExist(%s): got %v, want %v", h, found[h], w)
+		}
+	}
+}
+
+func TestClientDeleteRouteByHost(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{
+				{Host: strPtr("1.1.1.1"), Gateway: strPtr("10.0.0.1")},
+				{Network: strPtr("192.168.0.0"), Mask: strPtr("255.255.255.0"), Gateway: strPtr("10.0.0.2")},
+				{Host: strPtr("3.3.3.3"), Gateway: strPtr("10.0.0.1")},
+			})
+		case "/rci/", "/rci":
+			var payload []map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			for _, p := range payload {
+				ip, ok := p["ip"].(map[string]any)
+				if !ok {
+					continue
+				}
+				route := ip["route"].(map[string]any)
+				if route["no"] != true {
+					continue
+				}
+				if h, ok := route["host"].(string); ok {
+					deleted = append(deleted, h)
+				} else {
+					deleted = append(deleted, route["network"].(string))
+				}
+			}
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := client.DeleteRouteByHost(ctx, "192.168.0.0/24"); err != nil {
+		t.Fatalf("DeleteRouteByHost: %v", err)
+	}
+	err = client.DeleteRouteByHost(ctx, "9.9.9.9")
+	if !errors.Is(err, ErrRouteNotFound) {
+		t.Fatalf("expected ErrRouteNotFound, got %v", err)
+	}
+	var notFound *RouteNotFoundError
+	if !errors.As(err, &notFound) || notFound.Host != "9.9.9.9" || notFound.Code != CodeRouteNotFound {
+		t.Fatalf("expected RouteNotFoundError for 9.9.9.9, got %#v", err)
+	}
+	if err := client.DeleteRoutes(ctx, []routes.Route{
+		{Host: "3.3.3.3", Gateway: "10.0.0.1"},
+		{Host: "1.1.1.1", Gateway: "10.0.0.9"},
+	}); err != nil {
+		t.Fatalf("DeleteRoutes: %v", err)
+	}
+	if err := client.DeleteRoutesByGateway(ctx, "10.0.0.1"); err != nil {
+		t.Fatalf("DeleteRoutesByGateway: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"192.168.0.0", "3.3.3.3", "1.1.1.1", "3.3.3.3"}
+	if fmt.Sprint(deleted) != fmt.Sprint(want) {
+		t.Fatalf("deleted: got %v, want %v", deleted, want)
+	}
+}
+
+// routeChanges records the routes added and deleted through a test router.
+type routeChanges struct {
+	mu       sync.Mutex
+	added    []string
+	deleted  []string
+	rciCalls int
+	// gets counts route list requests.
+	gets int
+}
+
+// newRouteChangesServer serves three routes and records route changes sent to rci/.
+func newRouteChangesServer(t *testing.T, changes *routeChanges) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			changes.mu.Lock()
+			changes.gets++
+			changes.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{
+				{Host: strPtr("1.1.1.1"), Gateway: strPtr("10.0.0.1")},
+				{Host: strPtr("2.2.2.2"), Gateway: strPtr("10.0.0.1")},
+				{Network: strPtr("192.168.0.0"), Mask: strPtr("255.255.255.0"), Interface: strPtr("Wireguard1")},
+			})
+		case "/rci/", "/rci":
+			var payload []map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			changes.mu.Lock()
+			changes.rciCalls++
+			for _, p := range payload {
+				ip, ok := p["ip"].(map[string]any)
+				if !ok {
+					continue
+				}
+				route := ip["route"].(map[string]any)
+				dest, _ := route["host"].(string)
+				if dest == "" {
+					dest = route["network"].(string)
+				}
+				if gw, ok := route["gateway"].(string); ok {
+					dest += " via " + gw
+				}
+				if route["no"] == true {
+					changes.deleted = append(changes.deleted, dest)
+				} else {
+					changes.added = append(changes.added, dest)
+				}
+			}
+			changes.mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientReplaceRoutes(t *testing.T) {
+	changes := &routeChanges{}
+	server := newRouteChangesServer(t, changes)
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, _, err := client.ReplaceRoutes(ctx, []routes.Route{{Host: "10.0.0.0/33", Gateway: "10.0.0.1"}}); err == nil {
+		t.Fatalf("expected error for invalid CIDR")
+	}
+	changes.mu.Lock()
+	if len(changes.added)+len(changes.deleted) != 0 {
+		t.Fatalf("invalid entries changed routes: added %v, deleted %v", changes.added, changes.deleted)
+	}
+	changes.gets = 0
+	changes.mu.Unlock()
+
+	added, removed, err := client.ReplaceRoutes(ctx, []routes.Route{
+		{Host: "1.1.1.1", Gateway: "10.0.0.1"},
+		{Host: "2.2.2.2", Gateway: "10.0.0.2"},
+		{Host: "192.168.0.0/24", Interface: "Wireguard1"},
+		{Host: "3.3.3.3", Gateway: "10.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceRoutes: %v", err)
+	}
+	if len(added) != 2 || len(removed) != 1 || removed[0].Host != "2.2.2.2" {
+		t.Fatalf("returned diff: added %v, removed %v", added, removed)
+	}
+
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	if fmt.Sprint(changes.deleted) != "[2.2.2.2 via 10.0.0.1]" {
+		t.Fatalf("deleted: got %v", changes.deleted)
+	}
+	if fmt.Sprint(changes.added) != "[2.2.2.2 via 10.0.0.2 3.3.3.3 via 10.0.0.1]" {
+		t.Fatalf("added: got %v", changes.added)
+	}
+	if changes.rciCalls != 1 {
+		t.Fatalf("expected changes in one request, got %d", changes.rciCalls)
+	}
+	if changes.gets != 1 {
+		t.Fatalf("expected the routes to be fetched once, got %d", changes.gets)
+	}
+}
+
+func TestClientApplyDiff(t *testing.T) {
+	changes := &routeChanges{}
+	server := newRouteChangesServer(t, changes)
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+
+	// Changes that do not fit in one batch fall back to separate delete and add requests.
+	client.WithBatchSize(1)
+	err = client.ApplyDiff(context.Background(),
+		[]routes.Route{{Host: "4.4.4.4", Gateway: "10.0.0.1"}},
+		[]routes.Route{{Host: "1.1.1.1", Gateway: "10.0.0.1"}},
+	)
+	if err != nil {
+		t.Fatalf("ApplyDiff: %v", err)
+	}
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	if fmt.Sprint(changes.deleted) != "[1.1.1.1 via 10.0.0.1]" || fmt.Sprint(changes.added) != "[4.4.4.4 via 10.0.0.1]" {
+		t.Fatalf("got deleted %v, added %v", changes.deleted, changes.added)
+	}
+	if changes.rciCalls != 2 {
+		t.Fatalf("expected 2 requests, got %d", changes.rciCalls)
+	}
+}
+
+func TestClientUpdateRoute(t *testing.T) {
+	var mu sync.Mutex
+	var requests [][]map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/ip/route":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Route{
+				{Host: strPtr("1.1.1.1"), Gateway: strPtr("10.0.0.1")},
+			})
+		case "/rci/", "/rci":
+			var payload []map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			requests = append(requests, payload)
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	ctx := context.Background()
+
+	old := routes.Route{Host: "1.1.1.1", Gateway: "10.0.0.1"}
+	// A /32 destination matches the router's plain 1.1.1.1.
+	if err := client.UpdateRoute(ctx, routes.Route{Host: "1.1.1.1/32", Gateway: "10.0.0.1"}, routes.Route{Host: "1.1.1.1", Gateway: "10.0.0.2"}); err != nil {
+		t.Fatalf("UpdateRoute: %v", err)
+	}
+	if err := client.UpdateRoute(ctx, routes.Route{Host: "9.9.9.9", Gateway: "10.0.0.1"}, old); !errors.Is(err, ErrRouteNotFound) {
+		t.Fatalf("expected ErrRouteNotFound, got %v", err)
+	}
+	if err := client.UpdateRoute(ctx, old, routes.Route{Host: "1.1.1.1/33", Gateway: "10.0.0.2"}); err == nil {
+		t.Fatalf("expected error for invalid CIDR")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || len(requests[0]) != 3 {
+		t.Fatalf("expected one request with delete, add, and save, got %v", requests)
+	}
+	del := requests[0][0]["ip"].(map[string]any)["route"].(map[string]any)
+	add := requests[0][1]["ip"].(map[string]any)["route"].(map[string]any)
+	if del["no"] != true || del["gateway"] != "10.0.0.1" || add["no"] != nil || add["gateway"] != "10.0.0.2" {
+		t.Fatalf("unexpected payload: %v", requests[0])
+	}
+	if _, ok := requests[0][2]["system"]; !ok {
+		t.Fatalf("payload does not end with save: %v", requests[0])
+	}
+}
+
+func TestClientGetInterfaces(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "object", body: `{"Wireguard0": {"type": "Wireguard"}, "ISP": {"type": "GigabitEthernet"}}`, want: []string{"ISP", "Wireguard0"}},
+		{name: "list", body: `[{"id": "OpenVPN0"}, {"name": "ISP"}, {}]`, want: []string{"ISP", "OpenVPN0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/auth":
+					w.WriteHeader(http.StatusOK)
+				case "/rci/ip/interface":
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write([]byte(tt.body))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+			if err != nil {
+				t.Fatalf("NewClientWithHTTPClient: %v", err)
+			}
+			got, err := client.GetInterfaces(context.Background())
+			if err != nil {
+				t.Fatalf("GetInterfaces: %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientPing(t *testing.T) {
+	var mu sync.Mutex
+	var systemRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/show/version":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"release": "4.1.7", "title": "4.1"}`))
+		case "/rci/show/system":
+			mu.Lock()
+			systemRequests++
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"uptime": "60"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	version, err := client.FirmwareVersion(context.Background())
+	if err != nil || version != "4.1.7" {
+		t.Fatalf("FirmwareVersion: got %q, err %v", version, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if systemRequests != 0 {
+		t.Fatalf("version-only calls requested rci/show/system %d times", systemRequests)
+	}
+}
+
+func TestCheckFirmware(t *testing.T) {
+	tests := []struct {
+		version string
+		old     bool
+	}{
+		{"4.1.7", false},
+		{"3.0", false},
+		{"3.00.C.6.0-1", false},
+		{"2.16.D.12.0-3", true},
+		{"2.9", true},
+		{"", false},
+		{"beta", false},
+	}
+	for _, tt := range tests {
+		err := CheckFirmware(tt.version)
+		if got := errors.Is(err, ErrUnsupportedFirmware); got != tt.old {
+			t.Fatalf("CheckFirmware(%q) = %v, want unsupported=%v", tt.version, err, tt.old)
+		}
+	}
+}
+
+func TestClientCompatibilityCheck(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	release := "2.16.D.12.0-3"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/rci/show/version" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"release": %q}`, release)
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	if err := client.CompatibilityCheck(context.Background()); !errors.Is(err, ErrUnsupportedFirmware) {
+		t.Fatalf("CompatibilityCheck = %v, want ErrUnsupportedFirmware", err)
+	}
+	mu.Lock()
+	if strings.Join(paths, ",") != "/rci/show/version" {
+		mu.Unlock()
+		t.Fatalf("expected a single show/version request, got %v", paths)
+	}
+	release = ""
+	mu.Unlock()
+
+	err = client.CompatibilityCheck(context.Background())
+	if err == nil || errors.Is(err, ErrUnsupportedFirmware) || !strings.Contains(err.Error(), "read firmware version") {
+		t.Fatalf("expected a read error for a missing version, got %v", err)
+	}
+}
+
+func TestClientGetSystemInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusOK)
+		case "/rci/show/version":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"release": "4.1.7", "title": "4.1", "model": "Keenetic Giga", "device": "KN-1011"}`))
+		case "/rci/show/system":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"hostname": "Keenetic", "uptime": "93784"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithHTTPClient(server.URL, "user", "pass", &http.Client{})
+	if err != nil {
+		t.Fatalf("NewClientWithHTTPClient: %v", err)
+	}
+	info, err := client.GetSystemInfo(context.Background())
+	if err != nil {
+		t.Fatalf("GetSystemInfo: %v", err)
+	}
+	want := SystemInfo{FirmwareVersion: "4.1.7", Model: "Keenetic Giga", Uptime: 26*time.Hour + 3*time.Minute + 4*time.Second}
+	if *info != want {
+		t.Fatalf("GetSystemInfo = %+v, want %+v", *info, want)
+	}
+}
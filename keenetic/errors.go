@@ -0,0 +1,173 @@
+package keenetic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes carried by the typed errors below. They are stable, so scripts and
+// library users can rely on them instead of matching messages.
+const (
+	CodeAuthFailed          = "AUTH_FAILED"
+	CodeNetwork             = "NETWORK_ERROR"
+	CodeRouteNotFound       = "ROUTE_NOT_FOUND"
+	CodeUnsupportedFirmware = "UNSUPPORTED_FIRMWARE"
+	CodeHTTPStatus          = "HTTP_STATUS"
+)
+
+// AuthError is returned when the router rejects the credentials or the NDMS auth
+// handshake cannot be completed.
+type AuthError struct {
+	Code string
+	// Status is the HTTP status of the failed auth request, or zero.
+	Status int
+	Msg    string
+}
+
+func (e *AuthError) Error() string {
+	return e.Msg
+}
+
+// Is makes errors.Is(err, &AuthError{}) match any AuthError.
+func (e *AuthError) Is(target error) bool {
+	_, ok := target.(*AuthError)
+	return ok
+}
+
+// NetworkError is returned when the router cannot be reached: connection failures,
+// timeouts, and broken responses. Err is the underlying transport error.
+type NetworkError struct {
+	Code string
+	Op   string
+	Err  error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, &NetworkError{}) match any NetworkError.
+func (e *NetworkError) Is(target error) bool {
+	_, ok := target.(*NetworkError)
+	return ok
+}
+
+// RouteNotFoundError is returned when a route to delete or update does not exist on the router.
+type RouteNotFoundError struct {
+	Code string
+	Host string
+}
+
+func (e *RouteNotFoundError) Error() string {
+	if e.Host == "" {
+		return "route not found"
+	}
+	return fmt.Sprintf("route %s not found", e.Host)
+}
+
+// Is makes errors.Is(err, ErrRouteNotFound) match any RouteNotFoundError.
+func (e *RouteNotFoundError) Is(target error) bool {
+	_, ok := target.(*RouteNotFoundError)
+	return ok
+}
+
+// ErrRouteNotFound matches any RouteNotFoundError with errors.Is.
+var ErrRouteNotFound error = &RouteNotFoundError{Code: CodeRouteNotFound}
+
+// UnsupportedFirmwareError is returned by CompatibilityCheck when the router runs a
+// firmware older than MinSupportedFirmware. Callers usually report it as a warning.
+type UnsupportedFirmwareError struct {
+	Code    string
+	Version string
+	Minimum string
+}
+
+func (e *UnsupportedFirmwareError) Error() string {
+	return fmt.Sprintf("firmware %s is older than the minimum supported %s; some RCI requests may fail", e.Version, e.Minimum)
+}
+
+// Is makes errors.Is(err, ErrUnsupportedFirmware) match any UnsupportedFirmwareError.
+func (e *UnsupportedFirmwareError) Is(target error) bool {
+	_, ok := target.(*UnsupportedFirmwareError)
+	return ok
+}
+
+// ErrUnsupportedFirmware matches any UnsupportedFirmwareError with errors.Is.
+var ErrUnsupportedFirmware error = &UnsupportedFirmwareError{Code: CodeUnsupportedFirmware}
+
+// StatusError is returned when the router answers a request with an unexpected HTTP status.
+type StatusError struct {
+	Code   string
+	Op     string
+	Status int
+	// Body is the response body, which usually explains the failure.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s: status %d", e.Op, e.Status)
+	}
+	return fmt.Sprintf("%s: status %d: %s", e.Op, e.Status, e.Body)
+}
+
+// Is makes errors.Is(err, &StatusError{}) match any StatusError.
+func (e *StatusError) Is(target error) bool {
+	_, ok := target.(*StatusError)
+	return ok
+}
+
+// ErrorCode returns the code of the first typed error in err's chain, or "" if there is none.
+func ErrorCode(err error) string {
+	var (
+		authErr     *AuthError
+		networkErr  *NetworkError
+		statusErr   *StatusError
+		notFoundErr *RouteNotFoundError
+		firmwareErr *UnsupportedFirmwareError
+	)
+	switch {
+	case errors.As(err, &authErr):
+		return authErr.Code
+	case errors.As(err, &networkErr):
+		return networkErr.Code
+	case errors.As(err, &statusErr):
+		return statusErr.Code
+	case errors.As(err, &notFoundErr):
+		return notFoundErr.Code
+	case errors.As(err, &firmwareErr):
+		return firmwareErr.Code
+	}
+	return ""
+}
+
+// BatchError is returned by AddRoutes when a batch fails. Routes before Offset were added;
+// the rest were not sent.
+type BatchError struct {
+	Offset int
+	Err    error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("add routes batch at %d: %v", e.Offset, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+func newAuthError(status int, format string, args ...any) *AuthError {
+	return &AuthError{Code: CodeAuthFailed, Status: status, Msg: fmt.Sprintf(format, args...)}
+}
+
+func newStatusError(op string, status int, body []byte) *StatusError {
+	return &StatusError{Code: CodeHTTPStatus, Op: op, Status: status, Body: string(body)}
+}
+
+func newNetworkError(op string, err error) *NetworkError {
+	return &NetworkError{Code: CodeNetwork, Op: op, Err: err}
+}
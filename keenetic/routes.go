@@ -5,8 +5,10 @@ package keenetic
 //   Поиск по сайту: "NDMS RCI" или "rci/ip/route"
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
 	"net"
 	"strconv"
@@ -15,8 +17,46 @@ import (
 	"github.com/vladpi/keenetic-routes/routes"
 )
 
+// routeBatchSize is the default number of routes sent per RCI request.
 const routeBatchSize = 50
 
+// Bounds for the number of routes sent per RCI request.
+const (
+	MinBatchSize = 1
+	MaxBatchSize = 200
+)
+
+// ValidateBatchSize checks that n is within [MinBatchSize, MaxBatchSize].
+func ValidateBatchSize(n int) error {
+	if n < MinBatchSize || n > MaxBatchSize {
+		return fmt.Errorf("batch size must be between %d and %d, got %d", MinBatchSize, MaxBatchSize, n)
+	}
+	return nil
+}
+
+// WithBatchSize sets the number of routes sent per request in AddRoutes.
+// Values outside [MinBatchSize, MaxBatchSize] are ignored.
+func (c *Client) WithBatchSize(n int) *Client {
+	if ValidateBatchSize(n) == nil {
+		c.batchSize = n
+	}
+	return c
+}
+
+// SetAutoSave controls whether route changes end with a system configuration save.
+// Disabling it keeps changes only until the router reboots. Saving is enabled by default.
+func (c *Client) SetAutoSave(enabled bool) {
+	c.skipSave = !enabled
+}
+
+// appendSave appends the configuration save command to payload unless auto-save is disabled.
+func (c *Client) appendSave(payload []any) []any {
+	if c.skipSave {
+		return payload
+	}
+	return append(payload, saveConfigPayload())
+}
+
 type Stringish string
 
 func (s *Stringish) UnmarshalJSON(data []byte) error {
@@ -39,6 +79,11 @@ func (s Stringish) String() string {
 	return string(s)
 }
 
+// MarshalJSON encodes s as a plain JSON string.
+func (s Stringish) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(s))
+}
+
 type Boolish bool
 
 func (b *Boolish) UnmarshalJSON(data []byte) error {
@@ -69,6 +114,11 @@ func (b *Boolish) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalJSON encodes b as a JSON boolean.
+func (b Boolish) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bool(b))
+}
+
 type Intish int
 
 func (i *Intish) UnmarshalJSON(data []byte) error {
@@ -95,6 +145,11 @@ func (i *Intish) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalJSON encodes i as a JSON number.
+func (i Intish) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int(i))
+}
+
 type Route struct {
 	Host      *Stringish `json:"host,omitempty"`
 	Network   *Stringish `json:"network,omitempty"`
@@ -242,8 +297,8 @@ func toDomainRoutes(raw []Route) ([]routes.Route, error) {
 }
 
 // GetRoutes returns current static routes from the router (GET rci/ip/route).
-func (c *Client) GetRoutes() ([]Route, error) {
-	data, err := c.Request("rci/ip/route", nil)
+func (c *Client) GetRoutes(ctx context.Context) ([]Route, error) {
+	data, err := c.Request(ctx, "rci/ip/route", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -254,9 +309,104 @@ func (c *Client) GetRoutes() ([]Route, error) {
 	return routes, nil
 }
 
+// GetRoutesCount returns the number of current static routes. NDMS RCI has no count
+// endpoint, so the full route list is still fetched.
+func (c *Client) GetRoutesCount(ctx context.Context) (int, error) {
+	routes, err := c.GetRoutes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(routes), nil
+}
+
+// RouteCountChanged reports whether the route count differs between two polls.
+func RouteCountChanged(prev, curr int) bool {
+	return prev != curr
+}
+
+// RouteExists reports whether a route to host (IP or CIDR) exists on the router, whatever
+// its gateway or interface. A host route and a network route with a full-length mask
+// are the same destination.
+func (c *Client) RouteExists(ctx context.Context, host string) (bool, error) {
+	found, err := c.RoutesExist(ctx, []string{host})
+	if err != nil {
+		return false, err
+	}
+	return found[host], nil
+}
+
+// RoutesExist is like RouteExists for several hosts, fetching the routes once.
+// The result has an entry for each of hosts.
+func (c *Client) RoutesExist(ctx context.Context, hosts []string) (map[string]bool, error) {
+	all, err := c.GetRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]struct{}, len(all))
+	for _, r := range all {
+		if dest := canonicalDest(routes.RouteDest(r)); dest != "" {
+			present[dest] = struct{}{}
+		}
+	}
+	found := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		_, found[h] = present[canonicalDest(h)]
+	}
+	return found, nil
+}
+
+// canonicalDest normalizes an IP or CIDR so that equal destinations compare equal:
+// full-length prefixes become plain addresses and networks drop host bits.
+func canonicalDest(dest string) string {
+	dest = strings.TrimSpace(dest)
+	if !strings.Contains(dest, "/") {
+		if ip := net.ParseIP(dest); ip != nil {
+			return ip.String()
+		}
+		return dest
+	}
+	_, ipNet, err := net.ParseCIDR(dest)
+	if err != nil {
+		return dest
+	}
+	if ones, bits := ipNet.Mask.Size(); ones == bits {
+		return ipNet.IP.String()
+	}
+	return ipNet.String()
+}
+
+// GetRoutesByGateway returns current static routes that use gateway.
+func (c *Client) GetRoutesByGateway(ctx context.Context, gateway string) ([]Route, error) {
+	return c.getRoutesMatching(ctx, func(r Route) bool { return r.GatewayValue() == gateway })
+}
+
+// GetRoutesByInterface returns current static routes bound to iface.
+func (c *Client) GetRoutesByInterface(ctx context.Context, iface string) ([]Route, error) {
+	return c.getRoutesMatching(ctx, func(r Route) bool { return r.InterfaceValue() == iface })
+}
+
+// GetRoutesByComment returns current static routes with the given comment.
+func (c *Client) GetRoutesByComment(ctx context.Context, comment string) ([]Route, error) {
+	return c.getRoutesMatching(ctx, func(r Route) bool { return r.CommentValue() == comment })
+}
+
+func (c *Client) getRoutesMatching(ctx context.Context, match func(Route) bool) ([]Route, error) {
+	all, err := c.GetRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []Route
+	for _, r := range all {
+		if match(r) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
 // GetDomainRoutes returns current static routes converted to the domain model.
-func (c *Client) GetDomainRoutes() ([]routes.Route, error) {
-	raw, err := c.GetRoutes()
+func (c *Client) GetDomainRoutes(ctx context.Context) ([]routes.Route, error) {
+	raw, err := c.GetRoutes(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -264,43 +414,248 @@ func (c *Client) GetDomainRoutes() ([]routes.Route, error) {
 }
 
 // DeleteAllRoutes fetches current routes and sends delete (no: true) for each, then save.
-func (c *Client) DeleteAllRoutes() error {
-	routes, err := c.GetRoutes()
+func (c *Client) DeleteAllRoutes(ctx context.Context) error {
+	routes, err := c.GetRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	return c.deleteRoutes(ctx, routes)
+}
+
+// DeleteRouteByHost deletes the route whose destination is host (IP or CIDR), then save.
+// Returns ErrRouteNotFound if no such route exists.
+func (c *Client) DeleteRouteByHost(ctx context.Context, host string) error {
+	host = strings.TrimSpace(host)
+	matched, err := c.getRoutesMatching(ctx, func(r Route) bool { return routes.RouteDest(r) == host })
+	if err != nil {
+		return err
+	}
+	if len(matched) == 0 {
+		return fmt.Errorf("delete route: %w", &RouteNotFoundError{Code: CodeRouteNotFound, Host: host})
+	}
+	return c.deleteRoutes(ctx, matched)
+}
+
+// DeleteRoutes deletes routes matching entries by destination, gateway, and interface, then save.
+// Entries without a matching route on the router are ignored.
+func (c *Client) DeleteRoutes(ctx context.Context, entries []routes.Route) error {
+	all, err := c.GetRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	matched := matchEntries(all, entries)
+	if len(matched) == 0 {
+		return nil
+	}
+	return c.deleteRoutes(ctx, matched)
+}
+
+// matchEntries returns the routes of list that match entries by destination, gateway,
+// and interface. Destinations are compared in canonical form, so 1.1.1.1/32 matches 1.1.1.1.
+func matchEntries(list []Route, entries []routes.Route) []Route {
+	want := make(map[[3]string]struct{}, len(entries))
+	for _, e := range entries {
+		want[[3]string{canonicalDest(e.Host), e.Gateway, e.Interface}] = struct{}{}
+	}
+	var out []Route
+	for _, r := range list {
+		if _, ok := want[[3]string{canonicalDest(routes.RouteDest(r)), r.GatewayValue(), r.InterfaceValue()}]; ok {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// ReplaceRoutes makes the router routes match entries without a full clear: routes missing
+// from entries are deleted, entries missing on the router are added, and routes present in
+// both are left untouched. Entries are validated before anything is changed. The router
+// routes are fetched once; the added and removed routes are returned for reporting.
+func (c *Client) ReplaceRoutes(ctx context.Context, entries []routes.Route) (added, removed []routes.Route, err error) {
+	raw, err := c.GetRoutes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := toDomainRoutes(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	added, removed = routes.DiffRoutes(entries, current)
+	if err := c.applyDiff(ctx, raw, added, removed); err != nil {
+		return nil, nil, err
+	}
+	return added, removed, nil
+}
+
+// ApplyDiff deletes the routes matching removed (by destination, gateway, and interface)
+// and adds the added routes. Added routes are validated before anything is changed.
+// When all changes fit in one batch they are sent as a single request followed by save,
+// so the router never sits between the delete and the add.
+func (c *Client) ApplyDiff(ctx context.Context, added, removed []routes.Route) error {
+	var raw []Route
+	if len(removed) > 0 {
+		var err error
+		if raw, err = c.GetRoutes(ctx); err != nil {
+			return err
+		}
+	}
+	return c.applyDiff(ctx, raw, added, removed)
+}
+
+func (c *Client) applyDiff(ctx context.Context, raw []Route, added, removed []routes.Route) error {
+	batches, err := c.addRoutesBatches(added)
+	if err != nil {
+		return err
+	}
+	stale := matchEntries(raw, removed)
+	if len(stale)+len(added) <= c.effectiveBatchSize() {
+		if len(stale)+len(added) == 0 {
+			return nil
+		}
+		var payload []any
+		for i := range stale {
+			stale[i].No = boolPtr(true)
+			payload = append(payload, routeEnvelope(stale[i]))
+		}
+		if len(batches) > 0 {
+			// Drop the save command of the add batch; appendSave adds it at the end.
+			payload = append(payload, batches[0][:len(added)]...)
+		}
+		_, err := c.Request(ctx, "rci/", c.appendSave(payload))
+		return err
+	}
+	if len(stale) > 0 {
+		if err := c.deleteRoutes(ctx, stale); err != nil {
+			return fmt.Errorf("delete stale routes: %w", err)
+		}
+	}
+	return c.AddRoutes(ctx, added)
+}
+
+// UpdateRoute replaces the route matching old (by destination, gateway, and interface) with
+// updated, e.g. to move it to another gateway. Returns ErrRouteNotFound if old does not exist.
+//
+// NDMS RCI has no update command for static routes, so the delete of the old route and the
+// add of the new one are sent in one request, followed by save. This is not atomic: the
+// router runs the commands in order, and if it rejects the add the old route stays deleted.
+// updated is validated before anything is sent to make that unlikely.
+func (c *Client) UpdateRoute(ctx context.Context, old, updated routes.Route) error {
+	next, err := buildRoute(updated)
+	if err != nil {
+		return fmt.Errorf("update route: %w", err)
+	}
+	all, err := c.GetRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	matched := matchEntries(all, []routes.Route{old})
+	if len(matched) == 0 {
+		return fmt.Errorf("update route: %w", &RouteNotFoundError{Code: CodeRouteNotFound, Host: old.Host})
+	}
+	var payload []any
+	for i := range matched {
+		matched[i].No = boolPtr(true)
+		payload = append(payload, routeEnvelope(matched[i]))
+	}
+	payload = append(payload, routeEnvelope(next))
+	payload = c.appendSave(payload)
+	_, err = c.Request(ctx, "rci/", payload)
+	return err
+}
+
+// DeleteRoutesByGateway deletes all routes that use gateway, then save.
+func (c *Client) DeleteRoutesByGateway(ctx context.Context, gateway string) error {
+	matched, err := c.GetRoutesByGateway(ctx, gateway)
 	if err != nil {
 		return err
 	}
+	if len(matched) == 0 {
+		return nil
+	}
+	return c.deleteRoutes(ctx, matched)
+}
+
+// deleteRoutes sends delete (no: true) for each route, then save. Nothing is sent when
+// there is neither a route to delete nor a save to run.
+func (c *Client) deleteRoutes(ctx context.Context, list []Route) error {
 	var payload []any
-	for i := range routes {
-		routes[i].No = boolPtr(true)
-		payload = append(payload, routeEnvelope(routes[i]))
+	for i := range list {
+		list[i].No = boolPtr(true)
+		payload = append(payload, routeEnvelope(list[i]))
 	}
-	payload = append(payload, saveConfigPayload())
-	_, err = c.Request("rci/", payload)
+	payload = c.appendSave(payload)
+	if len(payload) == 0 {
+		return nil
+	}
+	_, err := c.Request(ctx, "rci/", payload)
 	return err
 }
 
 // AddRoutes adds static routes from entries (each with its own params), then save. Sends in batches.
-func (c *Client) AddRoutes(entries []routes.Route) error {
+// All entries are validated before anything is sent. If a batch fails, the error is a *BatchError
+// whose Offset is the index of the first entry not added, so the caller can resume with
+// entries[Offset:] instead of starting over.
+func (c *Client) AddRoutes(ctx context.Context, entries []routes.Route) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	batches, err := c.addRoutesBatches(entries)
+	if err != nil {
+		return err
+	}
+
+	batchSize := c.effectiveBatchSize()
+	for i, batch := range batches {
+		if _, err := c.Request(ctx, "rci/", batch); err != nil {
+			return &BatchError{Offset: i * batchSize, Err: err}
+		}
+	}
+	return nil
+}
+
+// DryRunAddRoutes writes the JSON payload batches AddRoutes would send to out, one per line,
+// without making any requests.
+func (c *Client) DryRunAddRoutes(entries []routes.Route, out io.Writer) error {
 	if len(entries) == 0 {
 		return nil
 	}
-	for i := 0; i < len(entries); i += routeBatchSize {
-		end := min(i+routeBatchSize, len(entries))
-		batch := entries[i:end]
+	batches, err := c.addRoutesBatches(entries)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	for _, batch := range batches {
+		if err := enc.Encode(batch); err != nil {
+			return fmt.Errorf("encode payload: %w", err)
+		}
+	}
+	return nil
+}
+
+// addRoutesBatches builds the RCI payloads for entries, each batch ending with a save command.
+func (c *Client) addRoutesBatches(entries []routes.Route) ([][]any, error) {
+	batchSize := c.effectiveBatchSize()
+	var batches [][]any
+	for i := 0; i < len(entries); i += batchSize {
+		end := min(i+batchSize, len(entries))
 		var payload []any
-		for _, e := range batch {
+		for _, e := range entries[i:end] {
 			route, err := buildRoute(e)
 			if err != nil {
-				return fmt.Errorf("add routes: %w", err)
+				return nil, fmt.Errorf("add routes: %w", err)
 			}
 			payload = append(payload, routeEnvelope(route))
 		}
-		payload = append(payload, saveConfigPayload())
-		if _, err := c.Request("rci/", payload); err != nil {
-			return fmt.Errorf("add routes batch at %d: %w", i, err)
-		}
+		payload = c.appendSave(payload)
+		batches = append(batches, payload)
 	}
-	return nil
+	return batches, nil
+}
+
+func (c *Client) effectiveBatchSize() int {
+	if c.batchSize == 0 {
+		return routeBatchSize
+	}
+	return c.batchSize
 }
 
 func buildRoute(e routes.Route) (Route, error) {
@@ -1,6 +1,9 @@
 package keenetic
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/vladpi/keenetic-routes/routes"
@@ -28,3 +31,86 @@ func TestBuildRouteIPv6CIDR(t *testing.T) {
 		t.Fatalf("interface: got %v", route.Interface)
 	}
 }
+
+func TestValidateBatchSize(t *testing.T) {
+	for _, n := range []int{MinBatchSize, 50, MaxBatchSize} {
+		if err := ValidateBatchSize(n); err != nil {
+			t.Fatalf("ValidateBatchSize(%d): %v", n, err)
+		}
+	}
+	for _, n := range []int{0, -1, MaxBatchSize + 1} {
+		if err := ValidateBatchSize(n); err == nil {
+			t.Fatalf("ValidateBatchSize(%d): expected error", n)
+		}
+	}
+}
+
+func TestDryRunAddRoutes(t *testing.T) {
+	client, err := NewClient("http://127.0.0.1:0", "user", "pass")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	client.WithBatchSize(2)
+	entries := []routes.Route{
+		{Host: "1.1.1.1", Gateway: "10.0.0.1"},
+		{Host: "10.0.0.0/8", Gateway: "10.0.0.1"},
+		{Host: "3.3.3.3", Interface: "Wireguard1"},
+	}
+	var buf bytes.Buffer
+	if err := client.DryRunAddRoutes(entries, &buf); err != nil {
+		t.Fatalf("DryRunAddRoutes: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 batches, got %d: %s", len(lines), buf.String())
+	}
+	var first []map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("decode batch: %v", err)
+	}
+	if len(first) != 3 {
+		t.Fatalf("first batch: expected 2 routes and save, got %d items", len(first))
+	}
+	if _, ok := first[2]["system"]; !ok {
+		t.Fatalf("first batch does not end with save: %v", first[2])
+	}
+
+	if err := client.DryRunAddRoutes([]routes.Route{{Host: "10.0.0.0/33"}}, &buf); err == nil {
+		t.Fatalf("expected error for invalid CIDR")
+	}
+
+	client.SetAutoSave(false)
+	buf.Reset()
+	if err := client.DryRunAddRoutes(entries[:1], &buf); err != nil {
+		t.Fatalf("DryRunAddRoutes: %v", err)
+	}
+	if strings.Contains(buf.String(), `"system"`) {
+		t.Fatalf("expected no save with auto-save disabled: %s", buf.String())
+	}
+}
+
+func TestMixedTypesJSONRoundTrip(t *testing.T) {
+	type payload struct {
+		S Stringish `json:"s"`
+		B Boolish   `json:"b"`
+		I Intish    `json:"i"`
+	}
+	var in payload
+	if err := json.Unmarshal([]byte(`{"s":42,"b":"yes","i":"24"}`), &in); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if string(data) != `{"s":"42","b":true,"i":24}` {
+		t.Fatalf("marshal: got %s", data)
+	}
+	var out payload
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal round trip: %v", err)
+	}
+	if out != in {
+		t.Fatalf("round trip: got %+v, want %+v", out, in)
+	}
+}
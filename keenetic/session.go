@@ -0,0 +1,75 @@
+package keenetic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// sessionState is the on-disk form of a client session.
+type sessionState struct {
+	BaseURL string          `json:"base_url"`
+	Login   string          `json:"login"`
+	Cookies []sessionCookie `json:"cookies"`
+}
+
+type sessionCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// UseSessionFile restores session cookies from path into the client's cookie jar and
+// remembers path for SaveSession. Sessions saved for another router or login are ignored.
+func (c *Client) UseSessionFile(path string) {
+	c.sessionFile = path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var state sessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return
+	}
+	if state.BaseURL != c.baseURL || state.Login != c.login || c.httpClient.Jar == nil {
+		return
+	}
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return
+	}
+	cookies := make([]*http.Cookie, 0, len(state.Cookies))
+	for _, sc := range state.Cookies {
+		cookies = append(cookies, &http.Cookie{Name: sc.Name, Value: sc.Value, Path: "/"})
+	}
+	c.httpClient.Jar.SetCookies(u, cookies)
+}
+
+// SaveSession writes current session cookies to the session file. It is a no-op
+// if the client was not created with a session file.
+func (c *Client) SaveSession() error {
+	if c.sessionFile == "" || c.httpClient.Jar == nil {
+		return nil
+	}
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return fmt.Errorf("save session: %w", err)
+	}
+	state := sessionState{BaseURL: c.baseURL, Login: c.login}
+	for _, ck := range c.httpClient.Jar.Cookies(u) {
+		state.Cookies = append(state.Cookies, sessionCookie{Name: ck.Name, Value: ck.Value})
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("save session: marshal: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.sessionFile), 0700); err != nil {
+		return fmt.Errorf("save session: create directory: %w", err)
+	}
+	if err := os.WriteFile(c.sessionFile, data, 0600); err != nil {
+		return fmt.Errorf("save session: write file: %w", err)
+	}
+	return nil
+}
@@ -1,17 +1,39 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/vladpi/keenetic-routes/app"
 	"github.com/vladpi/keenetic-routes/config"
+	"github.com/vladpi/keenetic-routes/keenetic"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 func main() {
-	var hostFlag, userFlag, passwordFlag string
+	var hostFlags []string
+	var userFlag, passwordFlag, profileFlag string
+	var insecureFlag bool
+	var proxyFlag string
+	var ipv6Flag bool
+	var skipVersionCheckFlag bool
+	var quietFlag, verboseFlag, logBodiesFlag, noColorFlag bool
+	var outputFlag string
+	var outputFile *os.File
+	var metricsAddrFlag, metricsStateFlag string
+	var metrics *app.Metrics
+	var stopMetrics func()
 	service := app.NewService()
 
 	var rootCmd = &cobra.Command{
@@ -19,34 +41,194 @@ func main() {
 		Short:   "Manage Keenetic static routes via RCI API",
 		Long:    "Upload, backup, and clear static routes on Keenetic routers using the NDMS RCI interface.",
 		Version: "1.1.0",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			service.SetIPv6(ipv6Flag)
+			service.SetSkipVersionCheck(skipVersionCheckFlag)
+			// Registered only on commands that load routes files; elsewhere these read false.
+			expandEnv, _ := cmd.Flags().GetBool("expand-env")
+			service.SetExpandEnv(expandEnv)
+			allowEmptyVars, _ := cmd.Flags().GetBool("allow-empty-vars")
+			service.SetAllowEmptyVars(allowEmptyVars)
+			if outputFlag != "" {
+				f, err := os.Create(outputFlag)
+				if err != nil {
+					return fmt.Errorf("open output file: %w", err)
+				}
+				outputFile = f
+				service.SetOutput(f)
+				cmd.Root().SetOut(f)
+			}
+			service.SetQuiet(quietFlag)
+			service.SetWarningOutput(os.Stderr)
+			service.SetLogBodies(logBodiesFlag)
+			if verboseFlag {
+				service.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+			}
+			service.SetColor(!noColorFlag && outputFile == nil && term.IsTerminal(int(os.Stdout.Fd())))
+			if metricsAddrFlag == "" && metricsStateFlag == "" {
+				return nil
+			}
+			m, err := app.NewMetrics(metricsStateFlag)
+			if err != nil {
+				return err
+			}
+			metrics = m
+			service.SetMetrics(m)
+			if metricsAddrFlag != "" {
+				if stopMetrics, err = m.Serve(metricsAddrFlag); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
 	}
 
-	rootCmd.PersistentFlags().StringVar(&hostFlag, "host", "", "Keenetic router host (e.g., 192.168.100.1:280)")
+	keenetic.Version = rootCmd.Version
+
+	rootCmd.PersistentFlags().StringArrayVar(&hostFlags, "host", nil, "Keenetic router host (e.g., 192.168.100.1:280 or https://router.example.com:443); repeat to target several routers with upload and clear")
 	rootCmd.PersistentFlags().StringVar(&userFlag, "user", "", "Keenetic router username")
 	rootCmd.PersistentFlags().StringVar(&passwordFlag, "password", "", "Keenetic router password")
+	rootCmd.PersistentFlags().StringVar(&profileFlag, "profile", "", "named router profile from the config file")
+	rootCmd.PersistentFlags().BoolVar(&insecureFlag, "insecure", false, "skip TLS certificate verification for https:// hosts")
+	rootCmd.PersistentFlags().StringVar(&proxyFlag, "proxy", "", "HTTP proxy URL (e.g., http://proxy.local:3128)")
+	rootCmd.PersistentFlags().BoolVar(&ipv6Flag, "ipv6", false, "allow IPv6 hosts and networks in routes files")
+	rootCmd.PersistentFlags().BoolVar(&skipVersionCheckFlag, "skip-version-check", false, "do not warn about router firmware older than "+keenetic.MinSupportedFirmware)
+	rootCmd.PersistentFlags().BoolVarP(&quietFlag, "quiet", "q", false, "print only errors and warnings")
+	rootCmd.PersistentFlags().BoolVarP(&verboseFlag, "verbose", "v", false, "log router HTTP requests with status and duration to stderr")
+	rootCmd.PersistentFlags().BoolVar(&logBodiesFlag, "log-bodies", false, "log router HTTP requests and response bodies (first 500 bytes) to stderr")
+	rootCmd.PersistentFlags().StringVarP(&outputFlag, "output", "o", "", "write command output to this file instead of stdout")
+	rootCmd.PersistentFlags().BoolVar(&noColorFlag, "no-color", false, "disable colored output")
+	rootCmd.PersistentFlags().StringVar(&metricsAddrFlag, "metrics-addr", "", "serve Prometheus metrics at /metrics on this address (e.g., :9090)")
+	rootCmd.PersistentFlags().StringVar(&metricsStateFlag, "metrics-state", "", "file keeping metric totals between invocations")
 
-	loadValidatedConfig := func() (*config.Config, error) {
-		cfg, err := config.LoadConfig(hostFlag, userFlag, passwordFlag)
+	loadValidatedConfigForHost := func(host string) (*config.Config, error) {
+		cfg, err := config.LoadConfigWithProfile(profileFlag, host, userFlag, passwordFlag)
 		if err != nil {
 			return nil, err
 		}
+		if insecureFlag {
+			cfg.Insecure = true
+		}
+		if proxyFlag != "" {
+			cfg.Proxy = proxyFlag
+		}
 		if err := cfg.Validate(); err != nil {
 			return nil, err
 		}
 		return cfg, nil
 	}
 
+	// loadValidatedConfigs returns one config per --host flag (or a single config when none is given).
+	loadValidatedConfigs := func() ([]*config.Config, error) {
+		hosts := hostFlags
+		if len(hosts) == 0 {
+			hosts = []string{""}
+		}
+		cfgs := make([]*config.Config, 0, len(hosts))
+		for _, host := range hosts {
+			cfg, err := loadValidatedConfigForHost(host)
+			if err != nil {
+				return nil, err
+			}
+			cfgs = append(cfgs, cfg)
+		}
+		return cfgs, nil
+	}
+
+	loadValidatedConfig := func() (*config.Config, error) {
+		if len(hostFlags) > 1 {
+			return nil, fmt.Errorf("multiple --host values are supported only by upload and clear")
+		}
+		cfgs, err := loadValidatedConfigs()
+		if err != nil {
+			return nil, err
+		}
+		return cfgs[0], nil
+	}
+
 	var uploadCmd = &cobra.Command{
 		Use:   "upload",
 		Short: "Upload static routes from a file",
 		Long:  "Parse IP/CIDR entries from a file and upload them as static routes to the router.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			cfg, err := loadValidatedConfig()
+			cfgs, err := loadValidatedConfigs()
 			if err != nil {
 				return err
 			}
-			file, _ := cmd.Flags().GetString("file")
-			return service.Upload(file, cfg)
+			files, _ := cmd.Flags().GetStringArray("file")
+			includeDisabled, _ := cmd.Flags().GetBool("include-disabled")
+			service.SetIncludeDisabled(includeDisabled)
+			tags, _ := cmd.Flags().GetStringSlice("tags")
+			service.SetTags(tags)
+			gateway, _ := cmd.Flags().GetString("gateway")
+			iface, _ := cmd.Flags().GetString("interface")
+			comment, _ := cmd.Flags().GetString("comment")
+			service.SetGroupParams(gateway, iface, comment)
+			noSave, _ := cmd.Flags().GetBool("no-save")
+			service.SetNoSave(noSave)
+			appendMode, _ := cmd.Flags().GetBool("append")
+			service.SetAppend(appendMode)
+			webhookURL, _ := cmd.Flags().GetString("webhook-url")
+			service.SetWebhookURL(webhookURL)
+			strictNoOverlap, _ := cmd.Flags().GetBool("strict-no-overlap")
+			service.SetStrictNoOverlap(strictNoOverlap)
+			aggregate, _ := cmd.Flags().GetBool("aggregate")
+			service.SetAggregate(aggregate)
+			limit, _ := cmd.Flags().GetInt("limit")
+			if limit < 0 {
+				return fmt.Errorf("--limit must not be negative")
+			}
+			service.SetLimit(limit)
+			if pattern, _ := cmd.Flags().GetString("comment-filter"); pattern != "" {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return fmt.Errorf("invalid --comment-filter: %w", err)
+				}
+				service.SetCommentFilter(re)
+			}
+			if cmd.Flags().Changed("batch-size") {
+				batchSize, _ := cmd.Flags().GetInt("batch-size")
+				// Zero would mean "not set" in the config, so reject it here rather than fall back.
+				if err := keenetic.ValidateBatchSize(batchSize); err != nil {
+					return fmt.Errorf("invalid --batch-size: %w", err)
+				}
+				for _, cfg := range cfgs {
+					cfg.BatchSize = batchSize
+				}
+			}
+			if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+				return service.DryRunUpload(files, cfgs[0], cmd.OutOrStdout())
+			}
+			if autoBackup, _ := cmd.Flags().GetBool("auto-backup"); autoBackup {
+				dir, _ := cmd.Flags().GetString("auto-backup-dir")
+				keep, _ := cmd.Flags().GetInt("keep-backups")
+				service.SetKeepBackups(keep)
+				if err := service.AutoBackup(cmd.Context(), dir, cfgs); err != nil {
+					return err
+				}
+			}
+			merge, _ := cmd.Flags().GetBool("merge")
+			var stdinData []byte
+			useStdin, _ := cmd.Flags().GetBool("stdin")
+			if useStdin {
+				if stdinData, err = io.ReadAll(cmd.InOrStdin()); err != nil {
+					return fmt.Errorf("read stdin: %w", err)
+				}
+			}
+			format, _ := cmd.Flags().GetString("format")
+			upload := func(s *app.Service, cfg *config.Config) error {
+				if useStdin {
+					return s.UploadFromReader(cmd.Context(), bytes.NewReader(stdinData), format, cfg)
+				}
+				if merge {
+					return s.MergeUpload(cmd.Context(), files, cfg)
+				}
+				return s.Upload(cmd.Context(), files, cfg)
+			}
+			if len(cfgs) == 1 {
+				return upload(service, cfgs[0])
+			}
+			return broadcastError(service.Broadcast(cfgs, upload))
 		},
 	}
 
@@ -56,7 +238,24 @@ func main() {
 		Long:  "Resolve domain entries in route groups and merge IPv4 results into hosts.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			file, _ := cmd.Flags().GetString("file")
-			return service.ResolveDomains(file)
+			dnsServer, _ := cmd.Flags().GetString("dns-server")
+			dohURL, _ := cmd.Flags().GetString("dns-doh-url")
+			fallback, _ := cmd.Flags().GetStringArray("dns-fallback")
+			noDefault, _ := cmd.Flags().GetBool("no-default-resolver")
+			unresolved, _ := cmd.Flags().GetString("unresolved-output")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			return service.ResolveDomains(file, app.ResolveDomainsOptions{
+				DNS: app.DNSOptions{
+					Server:            dnsServer,
+					DoHURL:            dohURL,
+					Fallback:          fallback,
+					NoDefaultResolver: noDefault,
+				},
+				UnresolvedOutput: unresolved,
+				DryRun:           dryRun,
+				Workers:          parallel,
+			})
 		},
 	}
 
@@ -69,8 +268,16 @@ func main() {
 			if err != nil {
 				return err
 			}
-			output, _ := cmd.Flags().GetString("output")
-			return service.Backup(output, cfg)
+			file, _ := cmd.Flags().GetString("file")
+			tags, _ := cmd.Flags().GetStringSlice("tags")
+			format, _ := cmd.Flags().GetString("format")
+			service.SetTags(tags)
+			if toStdout, _ := cmd.Flags().GetBool("stdout"); toStdout {
+				return service.BackupToWriter(cmd.Context(), cmd.OutOrStdout(), format, cfg)
+			}
+			keep, _ := cmd.Flags().GetInt("keep-backups")
+			service.SetKeepBackups(keep)
+			return service.Backup(cmd.Context(), file, format, cfg)
 		},
 	}
 
@@ -78,14 +285,258 @@ func main() {
 		Use:   "clear",
 		Short: "Clear all static routes",
 		Long:  "Remove all static routes from the router and save configuration.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfgs, err := loadValidatedConfigs()
+			if err != nil {
+				return err
+			}
+			noSave, _ := cmd.Flags().GetBool("no-save")
+			service.SetNoSave(noSave)
+			webhookURL, _ := cmd.Flags().GetString("webhook-url")
+			service.SetWebhookURL(webhookURL)
+			if noBackup, _ := cmd.Flags().GetBool("no-backup"); !noBackup {
+				dir, _ := cmd.Flags().GetString("auto-backup-dir")
+				keep, _ := cmd.Flags().GetInt("keep-backups")
+				service.SetKeepBackups(keep)
+				if err := service.AutoBackup(cmd.Context(), dir, cfgs); err != nil {
+					return err
+				}
+			}
+			if tags, _ := cmd.Flags().GetStringSlice("tags"); len(tags) > 0 {
+				file, _ := cmd.Flags().GetString("file")
+				if file == "" {
+					return fmt.Errorf("--tags requires --file to select tagged groups")
+				}
+				service.SetTags(tags)
+				if len(cfgs) == 1 {
+					return service.ClearTagged(cmd.Context(), file, cfgs[0])
+				}
+				return broadcastError(service.ClearTaggedAll(cmd.Context(), file, cfgs))
+			}
+			if len(cfgs) == 1 {
+				return service.Clear(cmd.Context(), cfgs[0])
+			}
+			return broadcastError(service.ClearAll(cmd.Context(), cfgs))
+		},
+	}
+
+	var rollbackCmd = &cobra.Command{
+		Use:   "rollback",
+		Short: "Restore static routes from a backup file",
+		Long:  "Replace all static routes on the router with routes from a backup file created by the backup command.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadValidatedConfig()
+			if err != nil {
+				return err
+			}
+			backupFile, _ := cmd.Flags().GetString("backup-file")
+			return service.Rollback(cmd.Context(), backupFile, cfg)
+		},
+	}
+
+	var applyCmd = &cobra.Command{
+		Use:   "apply",
+		Short: "Make router routes match a routes file",
+		Long:  "Add routes missing on the router and delete routes missing from the file, leaving the rest untouched. Safe to run repeatedly.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadValidatedConfig()
+			if err != nil {
+				return err
+			}
+			file, _ := cmd.Flags().GetString("file")
+			noSave, _ := cmd.Flags().GetBool("no-save")
+			service.SetNoSave(noSave)
+			webhookURL, _ := cmd.Flags().GetString("webhook-url")
+			service.SetWebhookURL(webhookURL)
+			return service.Apply(cmd.Context(), file, cfg)
+		},
+	}
+
+	var watchCmd = &cobra.Command{
+		Use:   "watch",
+		Short: "Upload routes whenever the routes file changes",
+		Long:  "Watch routes files and upload them to the router after every change, until interrupted with Ctrl+C.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadValidatedConfig()
+			if err != nil {
+				return err
+			}
+			files, _ := cmd.Flags().GetStringArray("file")
+			debounce, _ := cmd.Flags().GetDuration("debounce")
+			return service.Watch(cmd.Context(), files, cfg, debounce)
+		},
+	}
+
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List router routes",
+		Long:  "Print router static routes as an aligned table, JSON, or CSV. With --local, print the hosts and domains of a routes file instead.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			noHeader, _ := cmd.Flags().GetBool("no-header")
+			if local, _ := cmd.Flags().GetBool("local"); local {
+				file, _ := cmd.Flags().GetString("file")
+				return service.ListLocal(file, format, noHeader)
+			}
+			cfg, err := loadValidatedConfig()
+			if err != nil {
+				return err
+			}
+			return service.List(cmd.Context(), cfg, format, noHeader)
+		},
+	}
+	listCmd.Flags().String("format", "table", "output format: table, json, or csv")
+	listCmd.Flags().Bool("no-header", false, "omit the header row of table and csv output")
+	listCmd.Flags().Bool("local", false, "list the routes file given with --file instead of router routes")
+	listCmd.Flags().StringP("file", "f", "", "path to YAML routes file for --local")
+	listCmd.MarkFlagsRequiredTogether("local", "file")
+
+	var statsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "Show route statistics",
+		Long:  "Print counts of router routes by type, gateway, interface, and comment.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadValidatedConfig()
+			if err != nil {
+				return err
+			}
+			format, _ := cmd.Flags().GetString("format")
+			return service.Stats(cmd.Context(), cfg, format)
+		},
+	}
+	statsCmd.Flags().String("format", "text", "output format: text or json")
+
+	var countCmd = &cobra.Command{
+		Use:   "count",
+		Short: "Print the number of static routes",
+		Long:  "Print the number of static routes on the router, for scripts and monitoring.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadValidatedConfig()
+			if err != nil {
+				return err
+			}
+			return service.Count(cmd.Context(), cfg)
+		},
+	}
+
+	var listInterfacesCmd = &cobra.Command{
+		Use:   "list-interfaces",
+		Short: "List router interfaces",
+		Long:  "Print names of router interfaces that can be used as interface: in route groups.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadValidatedConfig()
+			if err != nil {
+				return err
+			}
+			return service.ListInterfaces(cmd.Context(), cfg)
+		},
+	}
+
+	var diffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Compare routes file with router routes",
+		Long: "Show routes that are in the file but not on the router (+) and routes on the router but not in the file (-).\n" +
+			"With --file-a and --file-b, compare two files locally without contacting the router.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileA, _ := cmd.Flags().GetString("file-a")
+			fileB, _ := cmd.Flags().GetString("file-b")
+			if fileA != "" || fileB != "" {
+				if fileA == "" || fileB == "" {
+					return fmt.Errorf("--file-a and --file-b must be used together")
+				}
+				return service.DiffFiles(fileA, fileB)
+			}
+			file, _ := cmd.Flags().GetString("file")
+			if file == "" {
+				return fmt.Errorf("required flag \"file\" not set (or use --file-a and --file-b)")
+			}
+			cfg, err := loadValidatedConfig()
+			if err != nil {
+				return err
+			}
+			return service.ShowDiff(cmd.Context(), file, cfg)
+		},
+	}
+
+	var normalizeCmd = &cobra.Command{
+		Use:   "normalize",
+		Short: "Sort and deduplicate a routes file in place",
+		Long:  "Sort groups by comment, gateway, and interface, sort hosts, drop repeated hosts, and save the YAML file back. Useful as a pre-commit hook.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, _ := cmd.Flags().GetString("file")
+			return service.Normalize(file)
+		},
+	}
+
+	var lintCmd = &cobra.Command{
+		Use:   "lint",
+		Short: "Validate a routes file without connecting to the router",
+		Long:  "Check hosts, gateways, targets, and domains of every group and report all problems. Duplicate hosts are warnings; invalid values are errors and make the command exit with status 1. Useful as a pre-commit hook.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, _ := cmd.Flags().GetString("file")
+			return service.Lint(file)
+		},
+	}
+
+	var fixCmd = &cobra.Command{
+		Use:   "fix",
+		Short: "Fix common issues of a routes file in place",
+		Long:  "Trim whitespace, drop empty and duplicate hosts, correct CIDRs with host bits set, sort hosts, and save the YAML file back atomically.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, _ := cmd.Flags().GetString("file")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			return service.Fix(file, dryRun)
+		},
+	}
+
+	var generateCmd = &cobra.Command{
+		Use:     "generate",
+		Aliases: []string{"init-routes"},
+		Short:   "Create an example routes file",
+		Long:    "Write an example routes.yaml with two groups and all optional fields explained in comments. Asks before overwriting an existing file.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, _ := cmd.Flags().GetString("file")
+			return service.Generate(path)
+		},
+	}
+	generateCmd.Flags().StringP("file", "f", "routes.yaml", "path of the routes file to create")
+
+	var aggregateCmd = &cobra.Command{
+		Use:   "aggregate",
+		Short: "Merge contiguous hosts of a routes file into fewer CIDRs",
+		Long:  "Replace contiguous and overlapping hosts of each group with the fewest covering CIDRs (e.g. 10.0.0.0/25 and 10.0.0.128/25 become 10.0.0.0/24) and save the YAML file back.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, _ := cmd.Flags().GetString("file")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			return service.Aggregate(file, dryRun)
+		},
+	}
+
+	var findRoutesCmd = &cobra.Command{
+		Use:   "find-routes",
+		Short: "Find routes of a file that cover an IP address",
+		Long:  "Print the routes of a routes file whose destination contains the given IP address, to debug which gateway or interface traffic to it would use.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, _ := cmd.Flags().GetString("file")
+			ip, _ := cmd.Flags().GetString("ip")
+			return service.FindRoutes(file, ip)
+		},
+	}
+
+	var checkCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Check router connectivity",
+		Long:  "Verify that the router is reachable and the credentials are accepted.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := loadValidatedConfig()
 			if err != nil {
 				return err
 			}
-			return service.Clear(cfg)
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			return service.Check(cmd.Context(), cfg, timeout)
 		},
 	}
+	checkCmd.Flags().Duration("timeout", 10*time.Second, "give up if the router does not answer within this time")
 
 	var configCmd = &cobra.Command{
 		Use:   "config",
@@ -98,36 +549,285 @@ func main() {
 		Short: "Initialize configuration file",
 		Long:  "Create a new configuration file interactively.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return service.InitConfig()
+			useKeyring, _ := cmd.Flags().GetBool("keyring")
+			return service.InitConfig(useKeyring)
+		},
+	}
+	configInitCmd.Flags().Bool("keyring", false, "store the password in the OS keyring instead of the config file")
+
+	var configShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Show resolved configuration",
+		Long:  "Print the configuration merged from config file, environment variables, and .env file.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reveal, _ := cmd.Flags().GetBool("reveal-password")
+			return service.ShowConfig(profileFlag, reveal)
+		},
+	}
+	configShowCmd.Flags().Bool("reveal-password", false, "show the actual password instead of a mask")
+
+	var configValidateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Validate configuration",
+		Long:  "Check that the configuration is parseable and all required fields are set.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return service.ValidateConfig(profileFlag)
+		},
+	}
+
+	var configDeleteCmd = &cobra.Command{
+		Use:   "delete",
+		Short: "Delete configuration file",
+		Long:  "Remove the configuration file and any passwords it stored in the OS keyring.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			force, _ := cmd.Flags().GetBool("force")
+			return service.DeleteConfig(force)
+		},
+	}
+	configDeleteCmd.Flags().Bool("force", false, "delete without asking for confirmation")
+
+	var configEditCmd = &cobra.Command{
+		Use:   "edit",
+		Short: "Edit configuration file",
+		Long:  "Open the configuration file in $EDITOR and validate it after the editor exits.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return service.EditConfig(profileFlag)
+		},
+	}
+
+	var configPathCmd = &cobra.Command{
+		Use:   "path",
+		Short: "Print configuration file path",
+		Long:  "Print the path of the configuration file, e.g. for cat $(keenetic-routes config path).",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprintln(cmd.OutOrStdout(), config.GetConfigFilePath())
 		},
 	}
 
-	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(configInitCmd, configShowCmd, configValidateCmd, configEditCmd, configPathCmd, configDeleteCmd)
+
+	var completionCmd = &cobra.Command{
+		Use:   "completion",
+		Short: "Generate shell completion script",
+		Long:  "Generate shell completion script for bash, zsh, or fish.",
+	}
+
+	var completionBashCmd = &cobra.Command{
+		Use:   "bash",
+		Short: "Generate bash completion script",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCmd.GenBashCompletion(cmd.OutOrStdout())
+		},
+	}
 
-	uploadCmd.Flags().StringP("file", "f", "", "path to YAML routes file (required)")
-	if err := markRequired(uploadCmd, "file"); err != nil {
+	var completionZshCmd = &cobra.Command{
+		Use:   "zsh",
+		Short: "Generate zsh completion script",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCmd.GenZshCompletion(cmd.OutOrStdout())
+		},
+	}
+
+	var completionFishCmd = &cobra.Command{
+		Use:   "fish",
+		Short: "Generate fish completion script",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rootCmd.GenFishCompletion(cmd.OutOrStdout(), true)
+		},
+	}
+
+	completionCmd.AddCommand(completionBashCmd, completionZshCmd, completionFishCmd)
+
+	uploadCmd.Flags().StringArrayP("file", "f", nil, "path to YAML routes file or plain-text IP list (required unless --stdin); repeat to merge several files")
+	uploadCmd.Flags().Bool("dry-run", false, "print RCI payloads that would be sent without contacting the router")
+	uploadCmd.Flags().Bool("merge", false, "skip routes that already exist on the router")
+	uploadCmd.Flags().Bool("append", false, "additive mode: do not check for or remove existing routes (default behavior)")
+	uploadCmd.MarkFlagsMutuallyExclusive("merge", "append")
+	uploadCmd.Flags().Bool("include-disabled", false, "also upload groups marked enabled: false")
+	uploadCmd.Flags().StringSlice("tags", nil, "upload only groups with at least one of these tags")
+	uploadCmd.Flags().String("gateway", "", "gateway for all route groups (required for plain-text files unless --interface is set)")
+	uploadCmd.Flags().String("interface", "", "interface for all route groups (required for plain-text files unless --gateway is set)")
+	uploadCmd.Flags().String("comment", "", "comment for all route groups")
+	uploadCmd.MarkFlagsMutuallyExclusive("gateway", "interface")
+	uploadCmd.Flags().Bool("no-save", false, "do not save the router configuration, so the routes are lost on reboot")
+	uploadCmd.Flags().String("webhook-url", "", "POST a JSON report of the result to this URL")
+	uploadCmd.Flags().Bool("auto-backup", false, "back up router routes to routes-backup-YYYYMMDDHHMMSS.yaml before uploading")
+	uploadCmd.Flags().String("auto-backup-dir", "", "directory for --auto-backup files (default current directory)")
+	uploadCmd.Flags().Int("keep-backups", 10, "keep only this many most recent routes-backup-*.yaml files, 0 keeps all")
+	uploadCmd.Flags().Bool("strict-no-overlap", false, "fail instead of warning when route destinations overlap")
+	uploadCmd.Flags().Bool("aggregate", false, "merge contiguous destinations with the same parameters into fewer CIDRs")
+	uploadCmd.Flags().Int("limit", 0, "upload at most this many routes, 0 uploads all")
+	uploadCmd.Flags().String("comment-filter", "", "upload only groups whose comment matches this Go regexp")
+	uploadCmd.Flags().Int("batch-size", 0, "number of routes sent per request, 1-200 (default 50, or KEENETIC_BATCH_SIZE)")
+	uploadCmd.Flags().Bool("stdin", false, "read routes from standard input instead of --file")
+	uploadCmd.Flags().String("format", "yaml", "format of routes read with --stdin: yaml, json, or text")
+	uploadCmd.MarkFlagsOneRequired("file", "stdin")
+	uploadCmd.MarkFlagsMutuallyExclusive("file", "stdin")
+	uploadCmd.MarkFlagsMutuallyExclusive("stdin", "merge")
+	uploadCmd.MarkFlagsMutuallyExclusive("stdin", "dry-run")
+
+	applyCmd.Flags().StringP("file", "f", "", "path to YAML routes file or plain-text IP list (required)")
+	applyCmd.Flags().Bool("no-save", false, "do not save the router configuration, so the changes are lost on reboot")
+	applyCmd.Flags().String("webhook-url", "", "POST a JSON report of the result to this URL")
+	if err := markRequired(applyCmd, "file"); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	watchCmd.Flags().StringArrayP("file", "f", nil, "path to YAML routes file or plain-text IP list to watch (required); repeatable")
+	watchCmd.Flags().Duration("debounce", app.DefaultWatchDebounce, "wait this long after the last change before uploading")
+	if err := markRequired(watchCmd, "file"); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
 	resolveDomainsCmd.Flags().StringP("file", "f", "", "path to YAML routes file (required)")
+	resolveDomainsCmd.Flags().String("dns-server", "", "DNS server address for lookups (e.g., 1.1.1.1:53); defaults to KEENETIC_DNS_SERVER or system resolver")
+	resolveDomainsCmd.Flags().String("dns-doh-url", "", "DNS over HTTPS endpoint for lookups (e.g., https://cloudflare-dns.com/dns-query); defaults to KEENETIC_DNS_DOH_URL")
+	resolveDomainsCmd.Flags().StringArray("dns-fallback", nil, "DNS server or DoH URL to try when the primary resolver fails (repeatable)")
+	resolveDomainsCmd.Flags().Bool("no-default-resolver", false, "do not fall back to the system resolver")
+	resolveDomainsCmd.Flags().String("unresolved-output", "", "save groups with domains that failed to resolve to this YAML file")
+	resolveDomainsCmd.Flags().Bool("dry-run", false, "print IPs that would be added without modifying the file")
+	resolveDomainsCmd.Flags().Int("parallel", 10, "number of concurrent DNS lookups")
+	resolveDomainsCmd.MarkFlagsMutuallyExclusive("dns-server", "dns-doh-url")
 	if err := markRequired(resolveDomainsCmd, "file"); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	backupCmd.Flags().StringP("output", "o", "", "output YAML file path (required)")
-	if err := markRequired(backupCmd, "output"); err != nil {
+	diffCmd.Flags().StringP("file", "f", "", "path to YAML routes file to compare with the router")
+	diffCmd.Flags().String("file-a", "", "old routes file for a local comparison with --file-b")
+	diffCmd.Flags().String("file-b", "", "new routes file for a local comparison with --file-a")
+	diffCmd.MarkFlagsMutuallyExclusive("file", "file-a")
+	diffCmd.MarkFlagsMutuallyExclusive("file", "file-b")
+
+	backupCmd.Flags().StringP("file", "f", "", "backup file path (required unless --stdout)")
+	backupCmd.Flags().Bool("stdout", false, "write the backup to standard output instead of a file")
+	backupCmd.MarkFlagsOneRequired("file", "stdout")
+	backupCmd.MarkFlagsMutuallyExclusive("file", "stdout")
+	backupCmd.Flags().StringSlice("tags", nil, "keep only groups with at least one of these tags (taken from the existing backup file)")
+	backupCmd.MarkFlagsMutuallyExclusive("stdout", "tags")
+	backupCmd.Flags().Int("keep-backups", 10, "keep only this many most recent routes-backup-*.yaml files, 0 keeps all")
+	backupCmd.Flags().String("format", "yaml", "output format: yaml, json, csv, or text (one destination per line)")
+
+	clearCmd.Flags().StringP("file", "f", "", "path to YAML routes file whose tagged groups are removed (with --tags)")
+	clearCmd.Flags().StringSlice("tags", nil, "remove only routes of file groups with at least one of these tags")
+	clearCmd.Flags().Bool("no-save", false, "do not save the router configuration, so the routes come back on reboot")
+	clearCmd.Flags().String("webhook-url", "", "POST a JSON report of the result to this URL")
+	clearCmd.Flags().Bool("no-backup", false, "do not back up router routes to routes-backup-YYYYMMDDHHMMSS.yaml before clearing")
+	clearCmd.Flags().String("auto-backup-dir", "", "directory for the backup made before clearing (default current directory)")
+	clearCmd.Flags().Int("keep-backups", 10, "keep only this many most recent routes-backup-*.yaml files, 0 keeps all")
+	clearCmd.MarkFlagsMutuallyExclusive("no-backup", "auto-backup-dir")
+
+	if err := rootCmd.RegisterFlagCompletionFunc("profile", completeProfile); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if err := rootCmd.RegisterFlagCompletionFunc("host", completeHost); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	normalizeCmd.Flags().StringP("file", "f", "", "path to YAML routes file (required)")
+	if err := markRequired(normalizeCmd, "file"); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	rootCmd.AddCommand(uploadCmd, resolveDomainsCmd, backupCmd, clearCmd, configCmd)
+	lintCmd.Flags().StringP("file", "f", "", "path to YAML routes file (required)")
+	if err := markRequired(lintCmd, "file"); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	fixCmd.Flags().StringP("file", "f", "", "path to YAML routes file (required)")
+	fixCmd.Flags().Bool("dry-run", false, "print what would be fixed without modifying the file")
+	if err := markRequired(fixCmd, "file"); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 
-	if err := rootCmd.Execute(); err != nil {
+	aggregateCmd.Flags().StringP("file", "f", "", "path to YAML routes file (required)")
+	aggregateCmd.Flags().Bool("dry-run", false, "print how many hosts would remain without modifying the file")
+	if err := markRequired(aggregateCmd, "file"); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
+
+	findRoutesCmd.Flags().StringP("file", "f", "", "path to YAML routes file or plain-text IP list (required)")
+	findRoutesCmd.Flags().String("ip", "", "IP address to look up (required)")
+	if err := markRequired(findRoutesCmd, "file", "ip"); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	for _, cmd := range []*cobra.Command{uploadCmd, applyCmd, watchCmd, resolveDomainsCmd, diffCmd, clearCmd, normalizeCmd, lintCmd, fixCmd, aggregateCmd, findRoutesCmd, listCmd} {
+		if err := cmd.RegisterFlagCompletionFunc("file", completeYAMLFiles); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, cmd := range []*cobra.Command{uploadCmd, applyCmd, watchCmd, diffCmd, clearCmd, findRoutesCmd} {
+		cmd.Flags().Bool("expand-env", false, "expand $VAR and ${VAR} in routes files from environment variables")
+		cmd.Flags().Bool("allow-empty-vars", false, "with --expand-env, expand unset variables to empty strings instead of failing")
+	}
+	for _, name := range []string{"file-a", "file-b"} {
+		if err := diffCmd.RegisterFlagCompletionFunc(name, completeYAMLFiles); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	rollbackCmd.Flags().StringP("backup-file", "b", "", "path to YAML backup file (required)")
+	if err := markRequired(rollbackCmd, "backup-file"); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	rootCmd.AddCommand(uploadCmd, applyCmd, watchCmd, resolveDomainsCmd, diffCmd, normalizeCmd, lintCmd, fixCmd, aggregateCmd, findRoutesCmd, generateCmd, backupCmd, rollbackCmd, clearCmd, listCmd, statsCmd, countCmd, listInterfacesCmd, checkCmd, configCmd, completionCmd)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		metrics.RecordError()
+	}
+	if stopMetrics != nil {
+		stopMetrics()
+	}
+	if outputFile != nil {
+		if closeErr := outputFile.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close output file: %w", closeErr)
+		}
+	}
+	if saveErr := metrics.SaveState(); saveErr != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", saveErr)
+	}
+	if err != nil {
+		if code := keenetic.ErrorCode(err); code != "" {
+			fmt.Fprintf(os.Stderr, "%v [%s]\n", err, code)
+		} else {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// broadcastError summarizes per-router errors returned by a broadcast operation.
+func broadcastError(errs []error) error {
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d routers failed", failed, len(errs))
 }
 
 func markRequired(cmd *cobra.Command, names ...string) error {
@@ -138,3 +838,47 @@ func markRequired(cmd *cobra.Command, names ...string) error {
 	}
 	return nil
 }
+
+// completeYAMLFiles lists YAML files in the current directory.
+func completeYAMLFiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			if strings.HasPrefix(m, toComplete) {
+				files = append(files, m)
+			}
+		}
+	}
+	return files, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeHost suggests hosts stored in the config file, if any.
+func completeHost(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	f, err := config.LoadFile()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var hosts []string
+	if f.Host != "" {
+		hosts = append(hosts, f.Host)
+	}
+	for _, name := range f.ProfileNames() {
+		if h := f.Profiles[name].Host; h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeProfile suggests profile names from the config file.
+func completeProfile(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	f, err := config.LoadFile()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return f.ProfileNames(), cobra.ShellCompDirectiveNoFileComp
+}
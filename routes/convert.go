@@ -1,5 +1,15 @@
 package routes
 
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
 // routeGroupKey identifies a unique group by its shared route parameters.
 type routeGroupKey struct {
 	comment string
@@ -7,15 +17,18 @@ type routeGroupKey struct {
 	iface   string
 	auto    bool
 	reject  bool
+	// disabled keeps groups with enabled: false apart from enabled ones.
+	disabled bool
 }
 
 // ToYAML builds a RoutesFile from domain routes, grouping by comment and params.
+// Groups are marked enabled so a backup restores everything it contains.
 func ToYAML(routesList []Route) *RoutesFile {
-	grouped := make(map[routeGroupKey][]string)
+	grouped := make(map[routeGroupKey]HostList)
 	var order []routeGroupKey
 
 	for _, r := range routesList {
-		if r.Host == "" || !isIPOrCIDR(r.Host) {
+		if r.Host == "" || !isValidIPOrCIDR(r.Host) {
 			continue
 		}
 		k := routeGroupKey{
@@ -28,12 +41,14 @@ func ToYAML(routesList []Route) *RoutesFile {
 		if _, exists := grouped[k]; !exists {
 			order = append(order, k)
 		}
-		grouped[k] = append(grouped[k], r.Host)
+		grouped[k] = append(grouped[k], HostEntry{Host: r.Host})
 	}
 
+	enabled := true
 	groups := make([]RouteGroup, 0, len(order))
 	for _, k := range order {
 		groups = append(groups, RouteGroup{
+			Enabled:   &enabled,
 			Comment:   k.comment,
 			Gateway:   k.gateway,
 			Interface: k.iface,
@@ -44,3 +59,588 @@ func ToYAML(routesList []Route) *RoutesFile {
 	}
 	return &RoutesFile{Routes: groups}
 }
+
+// CopyTags sets tags on groups of dst from groups of src with the same comment and
+// parameters, so that a fresh backup keeps the tags of the file it overwrites.
+func CopyTags(dst, src *RoutesFile) {
+	if dst == nil || src == nil {
+		return
+	}
+	tags := make(map[routeGroupKey][]string)
+	for _, g := range src.Routes {
+		if len(g.Tags) > 0 {
+			tags[groupKeyOf(g)] = g.Tags
+		}
+	}
+	for i := range dst.Routes {
+		if t, ok := tags[groupKeyOf(dst.Routes[i])]; ok {
+			dst.Routes[i].Tags = append([]string(nil), t...)
+		}
+	}
+}
+
+func groupKeyOf(g RouteGroup) routeGroupKey {
+	return routeGroupKey{
+		comment:  g.Comment,
+		gateway:  g.Gateway,
+		iface:    g.Interface,
+		auto:     g.Auto,
+		reject:   g.Reject,
+		disabled: !g.IsEnabled(),
+	}
+}
+
+// MergeFiles concatenates the groups of files and merges groups with the same comment,
+// parameters, and enabled state into the first of them, dropping repeated hosts, domains,
+// and tags.
+func MergeFiles(files ...*RoutesFile) *RoutesFile {
+	out := &RoutesFile{Routes: []RouteGroup{}}
+	index := make(map[routeGroupKey]int)
+	for _, rf := range files {
+		if rf == nil {
+			continue
+		}
+		for _, g := range rf.Routes {
+			k := groupKeyOf(g)
+			i, exists := index[k]
+			if !exists {
+				index[k] = len(out.Routes)
+				g.Hosts = appendUniqueHosts(nil, g.Hosts)
+				g.Domains = appendUnique(nil, g.Domains)
+				g.Tags = appendUnique(nil, g.Tags)
+				out.Routes = append(out.Routes, g)
+				continue
+			}
+			merged := &out.Routes[i]
+			merged.Hosts = appendUniqueHosts(merged.Hosts, g.Hosts)
+			merged.Domains = appendUnique(merged.Domains, g.Domains)
+			merged.Tags = appendUnique(merged.Tags, g.Tags)
+		}
+	}
+	return out
+}
+
+func appendUniqueHosts(dst, src HostList) HostList {
+	seen := make(map[string]struct{}, len(dst))
+	for _, e := range dst {
+		seen[e.Host] = struct{}{}
+	}
+	for _, e := range src {
+		if _, exists := seen[e.Host]; exists {
+			continue
+		}
+		seen[e.Host] = struct{}{}
+		dst = append(dst, e)
+	}
+	return dst
+}
+
+func appendUnique(dst, src []string) []string {
+	seen := make(map[string]struct{}, len(dst))
+	for _, v := range dst {
+		seen[v] = struct{}{}
+	}
+	for _, v := range src {
+		if _, exists := seen[v]; exists {
+			continue
+		}
+		seen[v] = struct{}{}
+		dst = append(dst, v)
+	}
+	return dst
+}
+
+// DiffFiles compares two routes files, e.g. backups taken at different times.
+// added are routes only in b; removed are routes only in a.
+func DiffFiles(a, b *RoutesFile) (added, removed []Route, err error) {
+	oldEntries, _, err := FlattenToEntries(a)
+	if err != nil {
+		return nil, nil, fmt.Errorf("first file: %w", err)
+	}
+	newEntries, _, err := FlattenToEntries(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("second file: %w", err)
+	}
+	added, removed = DiffRoutes(newEntries, oldEntries)
+	return added, removed, nil
+}
+
+// FilterFile returns a new RoutesFile with only the groups for which fn returns true.
+func FilterFile(rf *RoutesFile, fn func(RouteGroup) bool) *RoutesFile {
+	out := &RoutesFile{Routes: []RouteGroup{}}
+	if rf == nil {
+		return out
+	}
+	for _, g := range rf.Routes {
+		if fn(g) {
+			out.Routes = append(out.Routes, g)
+		}
+	}
+	return out
+}
+
+// FilterByGateway returns the groups of rf that route via gw.
+func FilterByGateway(rf *RoutesFile, gw string) *RoutesFile {
+	return FilterFile(rf, func(g RouteGroup) bool { return g.Gateway == gw })
+}
+
+// FilterByComment returns the groups of rf with the given comment.
+func FilterByComment(rf *RoutesFile, comment string) *RoutesFile {
+	return FilterFile(rf, func(g RouteGroup) bool { return g.Comment == comment })
+}
+
+// FilterByInterface returns the groups of rf bound to iface.
+func FilterByInterface(rf *RoutesFile, iface string) *RoutesFile {
+	return FilterFile(rf, func(g RouteGroup) bool { return g.Interface == iface })
+}
+
+// SortFile sorts groups of rf by comment, gateway, and interface, and the hosts of each
+// group lexicographically, so that files written from the same routes are identical.
+func SortFile(rf *RoutesFile) {
+	if rf == nil {
+		return
+	}
+	sort.SliceStable(rf.Routes, func(i, j int) bool { return groupLess(rf.Routes[i], rf.Routes[j]) })
+	for _, g := range rf.Routes {
+		sort.SliceStable(g.Hosts, func(i, j int) bool { return g.Hosts[i].Host < g.Hosts[j].Host })
+	}
+}
+
+// IsSorted reports whether rf is already in the order SortFile produces.
+func IsSorted(rf *RoutesFile) bool {
+	if rf == nil {
+		return true
+	}
+	if !sort.SliceIsSorted(rf.Routes, func(i, j int) bool { return groupLess(rf.Routes[i], rf.Routes[j]) }) {
+		return false
+	}
+	for _, g := range rf.Routes {
+		if !sort.SliceIsSorted(g.Hosts, func(i, j int) bool { return g.Hosts[i].Host < g.Hosts[j].Host }) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupLess orders groups by comment, gateway, and interface.
+func groupLess(a, b RouteGroup) bool {
+	if a.Comment != b.Comment {
+		return a.Comment < b.Comment
+	}
+	if a.Gateway != b.Gateway {
+		return a.Gateway < b.Gateway
+	}
+	return a.Interface < b.Interface
+}
+
+// DedupeHosts removes repeated hosts within each group of rf and returns how many were removed.
+func DedupeHosts(rf *RoutesFile) int {
+	if rf == nil {
+		return 0
+	}
+	removed := 0
+	for i := range rf.Routes {
+		g := &rf.Routes[i]
+		for j := range g.Hosts {
+			g.Hosts[j].Host = strings.TrimSpace(g.Hosts[j].Host)
+		}
+		unique := appendUniqueHosts(nil, g.Hosts)
+		removed += len(g.Hosts) - len(unique)
+		g.Hosts = unique
+	}
+	return removed
+}
+
+// ToText renders the destinations of entries one per line, in the format read by LoadText.
+// Repeated destinations are written once.
+func ToText(entries []Route) string {
+	var b strings.Builder
+	seen := make(map[string]struct{}, len(entries))
+	for _, r := range entries {
+		host := strings.TrimSpace(r.Host)
+		if host == "" {
+			continue
+		}
+		if _, ok := seen[host]; ok {
+			continue
+		}
+		seen[host] = struct{}{}
+		b.WriteString(host)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// RouteStats summarizes a list of routes.
+type RouteStats struct {
+	Total         int `json:"total"`
+	HostRoutes    int `json:"host_routes"`
+	NetworkRoutes int `json:"network_routes"`
+	// IPCount is the number of IPv4 addresses covered, see IPCount.
+	IPCount     int            `json:"ip_count"`
+	ByGateway   map[string]int `json:"by_gateway"`
+	ByInterface map[string]int `json:"by_interface"`
+	ByComment   map[string]int `json:"by_comment"`
+}
+
+// Summarize counts entries by kind, gateway, interface, and comment.
+// Addresses without a prefix or with a full-length one (/32 for IPv4, /128 for IPv6)
+// are counted as host routes.
+func Summarize(entries []Route) RouteStats {
+	stats := RouteStats{
+		Total:       len(entries),
+		ByGateway:   make(map[string]int),
+		ByInterface: make(map[string]int),
+		ByComment:   make(map[string]int),
+	}
+	stats.HostRoutes, stats.NetworkRoutes = HostRouteCount(entries)
+	stats.IPCount = IPCount(entries)
+	for _, r := range entries {
+		if r.Gateway != "" {
+			stats.ByGateway[r.Gateway]++
+		}
+		if r.Interface != "" {
+			stats.ByInterface[r.Interface]++
+		}
+		stats.ByComment[r.Comment]++
+	}
+	return stats
+}
+
+// HostRouteCount counts host routes (addresses without a prefix or with a full-length one)
+// and network routes among entries.
+func HostRouteCount(entries []Route) (hosts, networks int) {
+	for _, r := range entries {
+		if isHostRoute(r.Host) {
+			hosts++
+		} else {
+			networks++
+		}
+	}
+	return hosts, networks
+}
+
+// IPCount estimates the IPv4 address space covered by entries by adding up 2^(32-prefix)
+// for each of them; overlapping destinations are counted more than once. IPv6 and invalid
+// destinations are not counted.
+func IPCount(entries []Route) int {
+	total := 0
+	for _, r := range entries {
+		if first, last, ok := ipv4Range(r.Host); ok {
+			total += int(last - first + 1)
+		}
+	}
+	return total
+}
+
+// isHostRoute reports whether host is a single address: no prefix or a full-length one.
+func isHostRoute(host string) bool {
+	if !strings.Contains(host, "/") {
+		return true
+	}
+	_, ipnet, err := net.ParseCIDR(host)
+	if err != nil {
+		return false
+	}
+	ones, bits := ipnet.Mask.Size()
+	return ones == bits
+}
+
+// AggregateRoutes merges contiguous and overlapping IPv4 destinations of entries with the
+// same comment and parameters into the fewest covering CIDRs, e.g. 10.0.0.0/25 and
+// 10.0.0.128/25 into 10.0.0.0/24. Single addresses are written without a prefix. Entries
+// keep the order in which their parameters first appear, with destinations sorted within
+// them; IPv6 and invalid destinations are passed through unchanged.
+func AggregateRoutes(entries []Route) []Route {
+	type ipRange struct{ first, last uint64 }
+	ranges := make(map[Route][]ipRange)
+	passthrough := make(map[Route][]Route)
+	var order []Route
+	for _, r := range entries {
+		k := r
+		k.Host = ""
+		if _, ok := ranges[k]; !ok {
+			if _, ok := passthrough[k]; !ok {
+				order = append(order, k)
+			}
+		}
+		first, last, ok := ipv4Range(r.Host)
+		if !ok {
+			passthrough[k] = append(passthrough[k], r)
+			continue
+		}
+		ranges[k] = append(ranges[k], ipRange{first, last})
+	}
+
+	out := make([]Route, 0, len(entries))
+	for _, k := range order {
+		rs := ranges[k]
+		sort.Slice(rs, func(i, j int) bool { return rs[i].first < rs[j].first })
+		var merged []ipRange
+		for _, r := range rs {
+			if n := len(merged); n > 0 && r.first <= merged[n-1].last+1 {
+				merged[n-1].last = max(merged[n-1].last, r.last)
+				continue
+			}
+			merged = append(merged, r)
+		}
+		for _, r := range merged {
+			for _, host := range rangeToCIDRs(r.first, r.last) {
+				route := k
+				route.Host = host
+				out = append(out, route)
+			}
+		}
+		out = append(out, passthrough[k]...)
+	}
+	return out
+}
+
+// ipv4Range returns the first and last address of an IPv4 address or CIDR as integers.
+func ipv4Range(host string) (first, last uint64, ok bool) {
+	host = strings.TrimSpace(host)
+	if !strings.Contains(host, "/") {
+		ip := net.ParseIP(host).To4()
+		if ip == nil {
+			return 0, 0, false
+		}
+		n := uint64(binary.BigEndian.Uint32(ip))
+		return n, n, true
+	}
+	_, ipnet, err := net.ParseCIDR(host)
+	if err != nil {
+		return 0, 0, false
+	}
+	ip := ipnet.IP.To4()
+	ones, bits := ipnet.Mask.Size()
+	if ip == nil || bits != 32 {
+		return 0, 0, false
+	}
+	first = uint64(binary.BigEndian.Uint32(ip))
+	return first, first + 1<<(32-ones) - 1, true
+}
+
+// rangeToCIDRs splits the inclusive IPv4 range [first, last] into the fewest aligned CIDRs.
+func rangeToCIDRs(first, last uint64) []string {
+	var out []string
+	for first <= last {
+		size := uint64(1) << 32
+		if first != 0 {
+			size = first & -first
+		}
+		for first+size-1 > last {
+			size >>= 1
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(first))
+		prefix := 32
+		for s := size; s > 1; s >>= 1 {
+			prefix--
+		}
+		if prefix == 32 {
+			out = append(out, ip.String())
+		} else {
+			out = append(out, fmt.Sprintf("%s/%d", ip, prefix))
+		}
+		first += size
+	}
+	return out
+}
+
+// AggregateFile aggregates the hosts of each group of rf with AggregateRoutes, keeping
+// per-host comments apart, and returns the number of hosts before and after. Host ranges
+// are expanded first.
+func AggregateFile(rf *RoutesFile) (before, after int) {
+	if rf == nil {
+		return 0, 0
+	}
+	for i := range rf.Routes {
+		g := &rf.Routes[i]
+		before += len(g.Hosts)
+		var entries []Route
+		for _, e := range g.Hosts {
+			expanded, err := expandHostRange(e.Host)
+			if err != nil {
+				expanded = []string{e.Host}
+			}
+			for _, h := range expanded {
+				entries = append(entries, Route{Host: h, Comment: e.Comment})
+			}
+		}
+		hosts := make(HostList, 0, len(entries))
+		for _, r := range AggregateRoutes(entries) {
+			hosts = append(hosts, HostEntry{Host: r.Host, Comment: r.Comment})
+		}
+		g.Hosts = hosts
+		after += len(hosts)
+	}
+	return before, after
+}
+
+// FindContaining returns the entries whose destination, an address or CIDR, contains ip,
+// in their original order. Entries with invalid destinations are skipped.
+func FindContaining(entries []Route, ip net.IP) []Route {
+	var out []Route
+	for _, r := range entries {
+		host := strings.TrimSpace(r.Host)
+		if strings.Contains(host, "/") {
+			if _, ipnet, err := net.ParseCIDR(host); err == nil && ipnet.Contains(ip) {
+				out = append(out, r)
+			}
+			continue
+		}
+		if addr := net.ParseIP(host); addr != nil && addr.Equal(ip) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// OverlapType tells how the destination of OverlapWarning.Second relates to that of First.
+// There is no partial overlap: two CIDRs are either nested or disjoint.
+type OverlapType string
+
+const (
+	OverlapSubset   OverlapType = "subset"
+	OverlapSuperset OverlapType = "superset"
+)
+
+// OverlapWarning reports two routes whose destinations overlap, so that traffic to the
+// narrower one does not follow the wider one. First comes before Second in the entries.
+type OverlapWarning struct {
+	First  Route
+	Second Route
+	Type   OverlapType
+}
+
+func (w OverlapWarning) String() string {
+	return fmt.Sprintf("%s (%s) is a %s of %s (%s)", w.Second.Host, routeTarget(w.Second), w.Type, w.First.Host, routeTarget(w.First))
+}
+
+// routeTarget describes where a route sends traffic.
+func routeTarget(r Route) string {
+	if r.Interface != "" {
+		return "interface " + r.Interface
+	}
+	return "gateway " + r.Gateway
+}
+
+// DetectOverlaps reports every pair of entries whose destinations overlap, e.g.
+// 10.0.0.0/16 and 10.0.0.0/24, in the order the narrower entries are found. Equal
+// destinations written differently (1.1.1.1 and 1.1.1.1/32) count as subsets.
+// Invalid destinations are ignored.
+func DetectOverlaps(entries []Route) []OverlapWarning {
+	type parsed struct {
+		prefix netip.Prefix
+		idx    int
+	}
+	var list []parsed
+	for i, r := range entries {
+		p, err := parsePrefix(r.Host)
+		if err != nil {
+			continue
+		}
+		list = append(list, parsed{p, i})
+	}
+	// Sorted by address and then from wider to narrower, every prefix that contains
+	// another one comes before it, so a stack of enclosing prefixes finds all pairs.
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].prefix, list[j].prefix
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c < 0
+		}
+		return a.Bits() < b.Bits()
+	})
+
+	var warnings []OverlapWarning
+	var stack []parsed
+	for _, cur := range list {
+		for len(stack) > 0 && !stack[len(stack)-1].prefix.Overlaps(cur.prefix) {
+			stack = stack[:len(stack)-1]
+		}
+		for _, outer := range stack {
+			w := OverlapWarning{First: entries[outer.idx], Second: entries[cur.idx], Type: OverlapSubset}
+			if cur.idx < outer.idx {
+				w = OverlapWarning{First: entries[cur.idx], Second: entries[outer.idx], Type: OverlapSuperset}
+			}
+			warnings = append(warnings, w)
+		}
+		stack = append(stack, cur)
+	}
+	return warnings
+}
+
+// parsePrefix parses an address or CIDR into its network prefix; a plain address becomes
+// a full-length prefix.
+func parsePrefix(host string) (netip.Prefix, error) {
+	host = strings.TrimSpace(host)
+	if strings.Contains(host, "/") {
+		p, err := netip.ParsePrefix(host)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ExpandEnvVars returns a copy of rf with $VAR and ${VAR} references in the string fields of
+// its groups (comment, gateway, interface, hosts, domains, and tags) replaced by environment
+// variables, so that templates like gateway: "$GATEWAY" can be filled in at run time.
+// Unset variables are an error unless allowUnset is set, in which case they expand to "".
+func ExpandEnvVars(rf *RoutesFile, allowUnset bool) (*RoutesFile, error) {
+	if rf == nil {
+		return nil, nil
+	}
+	var missing []string
+	seen := make(map[string]bool)
+	expand := func(s string) string {
+		if !strings.Contains(s, "$") {
+			return s
+		}
+		return os.Expand(s, func(name string) string {
+			v, ok := os.LookupEnv(name)
+			if !ok && !seen[name] {
+				seen[name] = true
+				missing = append(missing, name)
+			}
+			return v
+		})
+	}
+	expandAll := func(list []string) []string {
+		if list == nil {
+			return nil
+		}
+		out := make([]string, len(list))
+		for i, s := range list {
+			out[i] = expand(s)
+		}
+		return out
+	}
+
+	out := &RoutesFile{Metadata: rf.Metadata, Routes: make([]RouteGroup, len(rf.Routes))}
+	for i, g := range rf.Routes {
+		g.Comment = expand(g.Comment)
+		g.Gateway = expand(g.Gateway)
+		g.Interface = expand(g.Interface)
+		if g.Hosts != nil {
+			hosts := make(HostList, len(g.Hosts))
+			for j, e := range g.Hosts {
+				hosts[j] = HostEntry{Host: expand(e.Host), Comment: expand(e.Comment)}
+			}
+			g.Hosts = hosts
+		}
+		g.Domains = expandAll(g.Domains)
+		g.Tags = expandAll(g.Tags)
+		out.Routes[i] = g
+	}
+	if len(missing) > 0 && !allowUnset {
+		return nil, fmt.Errorf("unset environment variables: %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
@@ -1,6 +1,13 @@
 package routes
 
-import "testing"
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 type stubRoute struct {
 	host      string
@@ -81,3 +88,308 @@ func TestRouteDestAndToYAML(t *testing.T) {
 		t.Fatalf("expected 3 hosts, got %d", len(group.Hosts))
 	}
 }
+
+func TestMergeFiles(t *testing.T) {
+	base := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "vpn", Interface: "Wireguard0", Hosts: NewHostList("1.1.1.1", "2.2.2.2"), Tags: []string{"base"}},
+		{Comment: "dns", Gateway: "10.0.0.1", Hosts: NewHostList("8.8.8.8")},
+	}}
+	extra := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "vpn", Interface: "Wireguard0", Hosts: NewHostList("2.2.2.2", "3.3.3.3"), Domains: []string{"example.com"}, Tags: []string{"base", "extra"}},
+		{Comment: "vpn", Interface: "Wireguard1", Hosts: NewHostList("4.4.4.4")},
+	}}
+
+	merged := MergeFiles(base, nil, extra)
+	if len(merged.Routes) != 3 {
+		t.Fatalf("expected 3 groups, got %d: %+v", len(merged.Routes), merged.Routes)
+	}
+	vpn := merged.Routes[0]
+	if fmt.Sprint(vpn.Hosts.Strings()) != "[1.1.1.1 2.2.2.2 3.3.3.3]" {
+		t.Fatalf("merged hosts: got %v", vpn.Hosts.Strings())
+	}
+	if fmt.Sprint(vpn.Domains) != "[example.com]" || fmt.Sprint(vpn.Tags) != "[base extra]" {
+		t.Fatalf("merged domains/tags: got %v / %v", vpn.Domains, vpn.Tags)
+	}
+	if merged.Routes[1].Comment != "dns" || merged.Routes[2].Interface != "Wireguard1" {
+		t.Fatalf("unexpected group order: %+v", merged.Routes)
+	}
+	if fmt.Sprint(base.Routes[0].Hosts.Strings()) != "[1.1.1.1 2.2.2.2]" {
+		t.Fatalf("input modified: %v", base.Routes[0].Hosts.Strings())
+	}
+
+	// A disabled group is not merged into an enabled one with the same parameters.
+	disabled := false
+	off := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "dns", Gateway: "10.0.0.1", Enabled: &disabled, Hosts: NewHostList("9.9.9.9")},
+	}}
+	merged = MergeFiles(base, off)
+	if len(merged.Routes) != 3 || merged.Routes[2].IsEnabled() || fmt.Sprint(merged.Routes[1].Hosts.Strings()) != "[8.8.8.8]" {
+		t.Fatalf("disabled group merged: %+v", merged.Routes)
+	}
+}
+
+func TestFilterFile(t *testing.T) {
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "dns", Gateway: "10.0.0.1", Hosts: NewHostList("8.8.8.8")},
+		{Comment: "vpn", Interface: "Wireguard0", Hosts: NewHostList("1.1.1.1")},
+		{Comment: "vpn", Gateway: "10.0.0.2", Hosts: NewHostList("2.2.2.2")},
+	}}
+
+	tests := []struct {
+		name string
+		got  *RoutesFile
+		want []string
+	}{
+		{name: "custom", got: FilterFile(rf, func(g RouteGroup) bool { return len(g.Hosts) > 0 }), want: []string{"8.8.8.8", "1.1.1.1", "2.2.2.2"}},
+		{name: "gateway", got: FilterByGateway(rf, "10.0.0.2"), want: []string{"2.2.2.2"}},
+		{name: "comment", got: FilterByComment(rf, "vpn"), want: []string{"1.1.1.1", "2.2.2.2"}},
+		{name: "interface", got: FilterByInterface(rf, "Wireguard0"), want: []string{"1.1.1.1"}},
+		{name: "no_match", got: FilterByInterface(rf, "Wireguard9"), want: []string{}},
+		{name: "nil_file", got: FilterByGateway(nil, "10.0.0.1"), want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts := []string{}
+			for _, g := range tt.got.Routes {
+				hosts = append(hosts, g.Hosts.Strings()...)
+			}
+			if fmt.Sprint(hosts) != fmt.Sprint(tt.want) {
+				t.Fatalf("got %v, want %v", hosts, tt.want)
+			}
+		})
+	}
+	if len(rf.Routes) != 3 {
+		t.Fatalf("input modified: %+v", rf.Routes)
+	}
+}
+
+func TestSortFileAndDedupeHosts(t *testing.T) {
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "vpn", Interface: "Wireguard0", Hosts: NewHostList("2.2.2.2", "1.1.1.1", " 2.2.2.2")},
+		{Comment: "dns", Gateway: "10.0.0.2", Hosts: NewHostList("9.9.9.9")},
+		{Comment: "dns", Gateway: "10.0.0.1", Hosts: NewHostList("8.8.8.8", "8.8.4.4")},
+	}}
+	if removed := DedupeHosts(rf); removed != 1 {
+		t.Fatalf("removed: got %d, want 1", removed)
+	}
+	SortFile(rf)
+
+	var got []string
+	for _, g := range rf.Routes {
+		got = append(got, fmt.Sprintf("%s/%s%s:%v", g.Comment, g.Gateway, g.Interface, g.Hosts.Strings()))
+	}
+	want := []string{
+		"dns/10.0.0.1:[8.8.4.4 8.8.8.8]",
+		"dns/10.0.0.2:[9.9.9.9]",
+		"vpn/Wireguard0:[1.1.1.1 2.2.2.2]",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestToText(t *testing.T) {
+	got := ToText([]Route{
+		{Host: "1.1.1.1", Gateway: "10.0.0.1"},
+		{Host: "10.0.0.0/8", Interface: "Wireguard1"},
+		{Host: "1.1.1.1", Gateway: "10.0.0.2"},
+	})
+	if got != "1.1.1.1\n10.0.0.0/8\n" {
+		t.Fatalf("ToText: got %q", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "routes.txt")
+	if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rf, err := LoadText(path, "10.0.0.1", "", "")
+	if err != nil {
+		t.Fatalf("LoadText: %v", err)
+	}
+	if strings.Join(rf.Routes[0].Hosts.Strings(), ",") != "1.1.1.1,10.0.0.0/8" {
+		t.Fatalf("round trip: got %v", rf.Routes[0].Hosts)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	stats := Summarize([]Route{
+		{Host: "1.1.1.1", Gateway: "10.0.0.1", Comment: "a"},
+		{Host: "10.0.0.0/8", Gateway: "10.0.0.1", Comment: "a"},
+		{Host: "2.2.2.2/32", Interface: "Wireguard1"},
+		{Host: "2001:db8::/32", Interface: "Wireguard1"},
+	})
+	if stats.Total != 4 || stats.HostRoutes != 2 || stats.NetworkRoutes != 2 || stats.IPCount != 1<<24+2 {
+		t.Fatalf("unexpected counts: %+v", stats)
+	}
+	if stats.ByGateway["10.0.0.1"] != 2 || stats.ByInterface["Wireguard1"] != 2 || stats.ByComment["a"] != 2 || stats.ByComment[""] != 2 {
+		t.Fatalf("unexpected breakdown: %+v", stats)
+	}
+
+	empty := Summarize(nil)
+	if empty.Total != 0 || empty.ByGateway == nil {
+		t.Fatalf("unexpected empty stats: %+v", empty)
+	}
+}
+
+func TestHostRouteCountAndIPCount(t *testing.T) {
+	entries := []Route{
+		{Host: "1.1.1.1"},
+		{Host: "2.2.2.2/32"},
+		{Host: "10.0.0.0/24"},
+		{Host: "10.0.1.0/25"},
+		{Host: "2001:db8::/32"},
+	}
+	hosts, networks := HostRouteCount(entries)
+	if hosts != 2 || networks != 3 {
+		t.Fatalf("HostRouteCount: got %d hosts, %d networks", hosts, networks)
+	}
+	if got := IPCount(entries); got != 1+1+256+128 {
+		t.Fatalf("IPCount: got %d", got)
+	}
+	if got := IPCount([]Route{{Host: "0.0.0.0/0"}}); uint64(got) != 1<<32 {
+		t.Fatalf("IPCount of 0.0.0.0/0: got %d", got)
+	}
+}
+
+func TestAggregateRoutes(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		want  string
+	}{
+		{name: "halves", hosts: []string{"10.0.0.128/25", "10.0.0.0/25"}, want: "10.0.0.0/24"},
+		{name: "hosts", hosts: []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}, want: "10.0.0.0/30"},
+		{name: "unaligned", hosts: []string{"10.0.0.1", "10.0.0.2"}, want: "10.0.0.1,10.0.0.2"},
+		{name: "contained", hosts: []string{"10.0.0.0/8", "10.1.2.3", "10.255.0.0/16"}, want: "10.0.0.0/8"},
+		{name: "partial", hosts: []string{"10.0.0.0/25", "10.0.0.128/26", "10.0.0.192"}, want: "10.0.0.0/25,10.0.0.128/26,10.0.0.192"},
+		{name: "whole_space", hosts: []string{"0.0.0.0/1", "128.0.0.0/1"}, want: "0.0.0.0/0"},
+		{name: "ipv6_passthrough", hosts: []string{"2001:db8::/32", "1.1.1.1"}, want: "1.1.1.1,2001:db8::/32"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entries []Route
+			for _, h := range tt.hosts {
+				entries = append(entries, Route{Host: h, Gateway: "192.168.1.1"})
+			}
+			var got []string
+			for _, r := range AggregateRoutes(entries) {
+				if r.Gateway != "192.168.1.1" {
+					t.Fatalf("route lost its gateway: %+v", r)
+				}
+				got = append(got, r.Host)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Fatalf("got %s, want %s", strings.Join(got, ","), tt.want)
+			}
+		})
+	}
+
+	got := AggregateRoutes([]Route{
+		{Host: "10.0.0.0/25", Gateway: "192.168.1.1"},
+		{Host: "10.0.0.128/25", Gateway: "192.168.1.2"},
+	})
+	if len(got) != 2 {
+		t.Fatalf("routes with different gateways were merged: %+v", got)
+	}
+}
+
+func TestAggregateFile(t *testing.T) {
+	rf := &RoutesFile{Routes: []RouteGroup{{
+		Gateway: "192.168.1.1",
+		Hosts:   HostList{{Host: "10.0.0.0-10.0.0.3"}, {Host: "10.0.0.4/30"}, {Host: "1.1.1.1", Comment: "dns"}},
+	}}}
+	before, after := AggregateFile(rf)
+	if before != 3 || after != 2 {
+		t.Fatalf("counts: got %d -> %d", before, after)
+	}
+	if got := strings.Join(rf.Routes[0].Hosts.Strings(), ","); got != "10.0.0.0/29,1.1.1.1" {
+		t.Fatalf("hosts: got %s", got)
+	}
+	if rf.Routes[0].Hosts[1].Comment != "dns" {
+		t.Fatalf("host comment lost: %+v", rf.Routes[0].Hosts[1])
+	}
+}
+
+func TestFindContaining(t *testing.T) {
+	entries := []Route{
+		{Host: "8.8.8.0/24", Gateway: "10.0.0.1"},
+		{Host: "1.1.1.1", Gateway: "10.0.0.1"},
+		{Host: "8.8.8.8", Interface: "Wireguard1"},
+		{Host: "0.0.0.0/0", Gateway: "10.0.0.2"},
+		{Host: "2001:db8::/32", Interface: "Wireguard1"},
+		{Host: "bad", Gateway: "10.0.0.1"},
+	}
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "8.8.8.8", want: "8.8.8.0/24,8.8.8.8,0.0.0.0/0"},
+		{ip: "1.1.1.1", want: "1.1.1.1,0.0.0.0/0"},
+		{ip: "2001:db8::1", want: "2001:db8::/32"},
+		{ip: "2001:db9::1", want: ""},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, r := range FindContaining(entries, net.ParseIP(tt.ip)) {
+			got = append(got, r.Host)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Fatalf("%s: got %s, want %s", tt.ip, strings.Join(got, ","), tt.want)
+		}
+	}
+}
+
+func TestDetectOverlaps(t *testing.T) {
+	entries := []Route{
+		{Host: "10.0.0.0/24", Gateway: "10.1.1.1"},
+		{Host: "10.0.0.0/16", Interface: "Wireguard1"},
+		{Host: "10.0.0.5", Gateway: "10.1.1.2"},
+		{Host: "10.1.0.0/16", Gateway: "10.1.1.1"},
+		{Host: "192.168.0.0/16", Gateway: "10.1.1.1"},
+		{Host: "2001:db8::/32", Interface: "Wireguard1"},
+		{Host: "2001:db8:1::1", Interface: "Wireguard2"},
+	}
+	var got []string
+	for _, w := range DetectOverlaps(entries) {
+		got = append(got, w.String())
+	}
+	want := []string{
+		"10.0.0.0/16 (interface Wireguard1) is a superset of 10.0.0.0/24 (gateway 10.1.1.1)",
+		"10.0.0.5 (gateway 10.1.1.2) is a subset of 10.0.0.0/16 (interface Wireguard1)",
+		"10.0.0.5 (gateway 10.1.1.2) is a subset of 10.0.0.0/24 (gateway 10.1.1.1)",
+		"2001:db8:1::1 (interface Wireguard2) is a subset of 2001:db8::/32 (interface Wireguard1)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected warnings:\n%s", strings.Join(got, "\n"))
+	}
+
+	if w := DetectOverlaps([]Route{{Host: "1.1.1.1"}, {Host: "1.1.1.1/32"}}); len(w) != 1 || w[0].Type != OverlapSubset {
+		t.Fatalf("equal destinations: got %+v", w)
+	}
+}
+
+func TestExpandEnvVars(t *testing.T) {
+	t.Setenv("KR_TEST_GATEWAY", "10.0.0.1")
+	t.Setenv("KR_TEST_NET", "10.1")
+	rf := &RoutesFile{Routes: []RouteGroup{{
+		Comment: "via $KR_TEST_GATEWAY",
+		Gateway: "$KR_TEST_GATEWAY",
+		Hosts:   NewHostList("${KR_TEST_NET}.0.0/16", "1.1.1.1"),
+		Tags:    []string{"env-$KR_TEST_MISSING"},
+	}}}
+
+	if _, err := ExpandEnvVars(rf, false); err == nil || !strings.Contains(err.Error(), "KR_TEST_MISSING") {
+		t.Fatalf("expected unset variable error, got %v", err)
+	}
+	got, err := ExpandEnvVars(rf, true)
+	if err != nil {
+		t.Fatalf("ExpandEnvVars: %v", err)
+	}
+	g := got.Routes[0]
+	if g.Comment != "via 10.0.0.1" || g.Gateway != "10.0.0.1" || strings.Join(g.Hosts.Strings(), ",") != "10.1.0.0/16,1.1.1.1" || g.Tags[0] != "env-" {
+		t.Fatalf("unexpected group: %+v", g)
+	}
+	if rf.Routes[0].Gateway != "$KR_TEST_GATEWAY" || rf.Routes[0].Hosts[0].Host != "${KR_TEST_NET}.0.0/16" {
+		t.Fatalf("input was modified: %+v", rf.Routes[0])
+	}
+}
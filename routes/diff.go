@@ -0,0 +1,44 @@
+package routes
+
+// routeKey identifies a route by destination and target for comparisons.
+type routeKey struct {
+	host    string
+	gateway string
+	iface   string
+}
+
+func keyOf(r Route) routeKey {
+	return routeKey{host: r.Host, gateway: r.Gateway, iface: r.Interface}
+}
+
+// DiffRoutes compares desired routes against current ones by host and target (gateway or interface).
+// added are routes in desired but not in current; removed are routes in current but not in desired.
+func DiffRoutes(desired, current []Route) (added, removed []Route) {
+	currentKeys := make(map[routeKey]struct{}, len(current))
+	for _, r := range current {
+		currentKeys[keyOf(r)] = struct{}{}
+	}
+	desiredKeys := make(map[routeKey]struct{}, len(desired))
+	for _, r := range desired {
+		k := keyOf(r)
+		if _, dup := desiredKeys[k]; dup {
+			continue
+		}
+		desiredKeys[k] = struct{}{}
+		if _, exists := currentKeys[k]; !exists {
+			added = append(added, r)
+		}
+	}
+	seen := make(map[routeKey]struct{}, len(current))
+	for _, r := range current {
+		k := keyOf(r)
+		if _, dup := seen[k]; dup {
+			continue
+		}
+		seen[k] = struct{}{}
+		if _, exists := desiredKeys[k]; !exists {
+			removed = append(removed, r)
+		}
+	}
+	return added, removed
+}
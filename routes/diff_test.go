@@ -0,0 +1,53 @@
+package routes
+
+import "testing"
+
+func TestDiffRoutes(t *testing.T) {
+	a := Route{Host: "1.1.1.1", Gateway: "10.0.0.1"}
+	b := Route{Host: "2.2.2.2", Interface: "Wireguard1"}
+	c := Route{Host: "3.3.3.3", Gateway: "10.0.0.1"}
+
+	tests := []struct {
+		name        string
+		desired     []Route
+		current     []Route
+		wantAdded   int
+		wantRemoved int
+	}{
+		{name: "add_only", desired: []Route{a, b}, current: []Route{a}, wantAdded: 1},
+		{name: "remove_only", desired: []Route{a}, current: []Route{a, c}, wantRemoved: 1},
+		{name: "mixed", desired: []Route{a, b}, current: []Route{a, c}, wantAdded: 1, wantRemoved: 1},
+		{name: "identical", desired: []Route{a, b, c}, current: []Route{c, b, a}},
+		{name: "gateway_change", desired: []Route{{Host: "1.1.1.1", Gateway: "10.0.0.2"}}, current: []Route{a}, wantAdded: 1, wantRemoved: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := DiffRoutes(tt.desired, tt.current)
+			if len(added) != tt.wantAdded || len(removed) != tt.wantRemoved {
+				t.Fatalf("got added=%v removed=%v", added, removed)
+			}
+		})
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	old := &RoutesFile{Routes: []RouteGroup{
+		{Gateway: "10.0.0.1", Hosts: NewHostList("1.1.1.1", "2.2.2.2")},
+	}}
+	updated := &RoutesFile{Routes: []RouteGroup{
+		{Gateway: "10.0.0.1", Hosts: NewHostList("2.2.2.2", "3.3.3.3")},
+	}}
+	added, removed, err := DiffFiles(old, updated)
+	if err != nil {
+		t.Fatalf("DiffFiles: %v", err)
+	}
+	if len(added) != 1 || added[0].Host != "3.3.3.3" || len(removed) != 1 || removed[0].Host != "1.1.1.1" {
+		t.Fatalf("got added=%v removed=%v", added, removed)
+	}
+
+	invalid := &RoutesFile{Routes: []RouteGroup{{Hosts: NewHostList("1.1.1.1")}}}
+	if _, _, err := DiffFiles(old, invalid); err == nil {
+		t.Fatalf("expected error for invalid file")
+	}
+}
@@ -2,19 +2,137 @@ package routes
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	domainLookupTimeout = 5 * time.Second
+	domainLookupRetries = 3
+	domainLookupDelay   = 200 * time.Millisecond
 )
 
-const domainLookupTimeout = 5 * time.Second
+var errNoIPv4Records = errors.New("no IPv4 records found")
 
 // ResolveSummary describes the result of domain resolution.
 type ResolveSummary struct {
 	Groups   int
 	Domains  int
 	IPsAdded int
+	Errors   []ResolveDomainError
+}
+
+// ResolveDomainError describes a domain that failed to resolve.
+type ResolveDomainError struct {
+	Group string
+	// Index is the position of the group in RoutesFile.Routes.
+	Index  int
+	Domain string
+	Err    error
+}
+
+func (e ResolveDomainError) Error() string {
+	return fmt.Sprintf("group %s domain %q: %v", e.Group, e.Domain, e.Err)
+}
+
+func (e ResolveDomainError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveError aggregates all domain resolution failures of a run.
+type ResolveError struct {
+	Errors []ResolveDomainError
+}
+
+func (e *ResolveError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, de := range e.Errors {
+		msgs = append(msgs, de.Error())
+	}
+	return fmt.Sprintf("failed to resolve %d domains:\n  %s", len(e.Errors), strings.Join(msgs, "\n  "))
+}
+
+func (e *ResolveError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, de := range e.Errors {
+		errs = append(errs, de)
+	}
+	return errs
+}
+
+// SaveUnresolved writes to path the groups of rf that have domains failed in errs. Groups keep
+// their route parameters but list only the failed domains, each with its DNS error as a
+// comment, so the file can be passed to resolve-domains again later.
+func SaveUnresolved(path string, rf *RoutesFile, errs []ResolveDomainError) error {
+	failed := make(map[int][]ResolveDomainError)
+	var order []int
+	for _, e := range errs {
+		if _, ok := failed[e.Index]; !ok {
+			order = append(order, e.Index)
+		}
+		failed[e.Index] = append(failed[e.Index], e)
+	}
+	sort.Ints(order)
+
+	out := &RoutesFile{Routes: []RouteGroup{}}
+	for _, idx := range order {
+		if rf == nil || idx < 0 || idx >= len(rf.Routes) {
+			return fmt.Errorf("unresolved domain refers to unknown group #%d", idx+1)
+		}
+		g := rf.Routes[idx]
+		g.Hosts = HostList{}
+		g.Domains = nil
+		for _, e := range failed[idx] {
+			g.Domains = append(g.Domains, e.Domain)
+		}
+		out.Routes = append(out.Routes, g)
+	}
+
+	var doc yaml.Node
+	if err := doc.Encode(out); err != nil {
+		return fmt.Errorf("marshal YAML: %w", err)
+	}
+	groups := mappingValue(&doc, "routes")
+	for i, idx := range order {
+		domains := mappingValue(groups.Content[i], "domains")
+		for j, e := range failed[idx] {
+			domains.Content[j].LineComment = strings.ReplaceAll(e.Err.Error(), "\n", "; ")
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+	data, err := yaml.Marshal(&doc)
+	if err != nil {
+		return fmt.Errorf("marshal YAML: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
+
+// mappingValue returns the value node of key in the mapping node m.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
 }
 
 // IPResolver is a minimal DNS resolver interface.
@@ -22,86 +140,404 @@ type IPResolver interface {
 	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
 }
 
+// NewCustomResolver returns a resolver that sends all queries to the DNS server at addr.
+// Port 53 is used when addr has no port.
+func NewCustomResolver(addr string) *net.Resolver {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: domainLookupTimeout}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// NewDoHResolver returns a resolver that queries A records from the DNS over HTTPS endpoint
+// at serverURL (e.g. https://cloudflare-dns.com/dns-query) using the JSON API.
+func NewDoHResolver(serverURL string) IPResolver {
+	return &dohResolver{
+		serverURL: serverURL,
+		client:    &http.Client{Timeout: domainLookupTimeout},
+	}
+}
+
+type dohResolver struct {
+	serverURL string
+	client    *http.Client
+}
+
+// dohResponse is the subset of the DNS JSON API response used for A lookups.
+type dohResponse struct {
+	Status int `json:"Status"`
+	Answer []struct {
+		Type int    `json:"type"`
+		Data string `json:"data"`
+	} `json:"Answer"`
+}
+
+const (
+	dnsTypeA         = 1
+	dnsRcodeNXDomain = 3
+)
+
+func (r *dohResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	u, err := url.Parse(r.serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse DoH URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("name", host)
+	q.Set("type", "A")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DoH request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH request: unexpected status %s", resp.Status)
+	}
+	var body dohResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode DoH response: %w", err)
+	}
+	switch body.Status {
+	case 0:
+	case dnsRcodeNXDomain:
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.serverURL, IsNotFound: true}
+	default:
+		return nil, &net.DNSError{Err: fmt.Sprintf("DNS response code %d", body.Status), Name: host, Server: r.serverURL}
+	}
+	var addrs []net.IPAddr
+	for _, a := range body.Answer {
+		if a.Type != dnsTypeA {
+			continue
+		}
+		if ip := net.ParseIP(a.Data); ip != nil {
+			addrs = append(addrs, net.IPAddr{IP: ip})
+		}
+	}
+	return addrs, nil
+}
+
+// NewFallbackResolver returns a resolver that tries resolvers in order and returns the first
+// successful result. If all of them fail, the errors are combined.
+func NewFallbackResolver(resolvers ...IPResolver) IPResolver {
+	return fallbackResolver(resolvers)
+}
+
+type fallbackResolver []IPResolver
+
+func (r fallbackResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if len(r) == 0 {
+		return nil, errors.New("no DNS resolvers configured")
+	}
+	var errs []error
+	for _, resolver := range r {
+		addrs, err := resolver.LookupIPAddr(ctx, host)
+		if err == nil {
+			return addrs, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
 // ResolveDomains resolves RouteGroup.Domains and merges IPv4 results into Hosts.
+// All domains are attempted; failures are collected into a *ResolveError.
 func ResolveDomains(rf *RoutesFile) (ResolveSummary, error) {
 	return ResolveDomainsWithResolver(rf, net.DefaultResolver)
 }
 
+// DNSCache caches resolved IPv4 addresses per domain until they expire.
+type DNSCache struct {
+	mu         sync.RWMutex
+	defaultTTL time.Duration
+	entries    map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	ips     []string
+	expires time.Time
+}
+
+// NewDNSCache creates a cache that keeps entries for defaultTTL.
+func NewDNSCache(defaultTTL time.Duration) *DNSCache {
+	return &DNSCache{defaultTTL: defaultTTL, entries: make(map[string]dnsCacheEntry)}
+}
+
+// Get returns cached IPs for domain if present and not expired.
+func (c *DNSCache) Get(domain string) ([]string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[domain]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return append([]string(nil), entry.ips...), true
+}
+
+// Set stores IPs for domain using the default TTL.
+func (c *DNSCache) Set(domain string, ips []string) {
+	c.SetWithTTL(domain, ips, c.defaultTTL)
+}
+
+// SetWithTTL stores IPs for domain with an explicit TTL.
+func (c *DNSCache) SetWithTTL(domain string, ips []string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[domain] = dnsCacheEntry{
+		ips:     append([]string(nil), ips...),
+		expires: time.Now().Add(ttl),
+	}
+}
+
 // ResolveDomainsWithResolver resolves domains using the provided resolver.
 func ResolveDomainsWithResolver(rf *RoutesFile, resolver IPResolver) (ResolveSummary, error) {
+	return resolveDomains(rf, func(domain string) ([]string, error) {
+		return lookupIPv4(resolver, domain)
+	})
+}
+
+// ResolveDomainsWithCache resolves domains using cache first and the resolver on a miss.
+func ResolveDomainsWithCache(rf *RoutesFile, resolver IPResolver, cache *DNSCache) (ResolveSummary, error) {
+	if cache == nil {
+		return ResolveDomainsWithResolver(rf, resolver)
+	}
+	return resolveDomains(rf, func(domain string) ([]string, error) {
+		if ips, ok := cache.Get(domain); ok {
+			return ips, nil
+		}
+		ips, err := lookupIPv4(resolver, domain)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) > 0 {
+			cache.Set(domain, ips)
+		}
+		return ips, nil
+	})
+}
+
+func resolveDomains(rf *RoutesFile, lookup func(domain string) ([]string, error)) (ResolveSummary, error) {
 	var summary ResolveSummary
 	if rf == nil || len(rf.Routes) == 0 {
 		return summary, nil
 	}
+	if err := validateRoutesFile(rf); err != nil {
+		return summary, err
+	}
 	for i := range rf.Routes {
 		group := &rf.Routes[i]
 		if len(group.Domains) == 0 {
 			continue
 		}
-		if (group.Gateway == "") == (group.Interface == "") {
-			return summary, fmt.Errorf("group %s: set exactly one of gateway or interface", groupLabel(group, i))
-		}
+		domains := groupDomains(group)
 		summary.Groups++
 
-		seenHosts := make(map[string]struct{})
-		mergedHosts := make([]string, 0, len(group.Hosts))
-		for _, h := range group.Hosts {
-			trimmed := strings.TrimSpace(h)
-			if trimmed == "" {
-				continue
+		seenHosts, mergedHosts := dedupeHosts(group.Hosts)
+		for _, domain := range domains {
+			summary.Domains++
+			ips, err := lookup(domain)
+			if err == nil && len(ips) == 0 {
+				err = errNoIPv4Records
 			}
-			if _, exists := seenHosts[trimmed]; exists {
+			if err != nil {
+				summary.Errors = append(summary.Errors, ResolveDomainError{
+					Group:  groupLabel(group, i),
+					Index:  i,
+					Domain: domain,
+					Err:    err,
+				})
 				continue
 			}
-			seenHosts[trimmed] = struct{}{}
-			mergedHosts = append(mergedHosts, trimmed)
+			mergedHosts = mergeIPs(mergedHosts, seenHosts, ips, &summary)
 		}
 
-		seenDomains := make(map[string]struct{})
-		for _, d := range group.Domains {
-			domain := strings.TrimSpace(d)
-			if domain == "" {
-				return summary, fmt.Errorf("group %s: empty domain entry", groupLabel(group, i))
-			}
-			if _, exists := seenDomains[domain]; exists {
-				continue
-			}
-			seenDomains[domain] = struct{}{}
-			summary.Domains++
+		group.Hosts = mergedHosts
+	}
+	if len(summary.Errors) > 0 {
+		return summary, &ResolveError{Errors: summary.Errors}
+	}
+	return summary, nil
+}
 
-			ips, err := lookupIPv4(resolver, domain)
-			if err != nil {
-				return summary, fmt.Errorf("group %s domain %q: %w", groupLabel(group, i), domain, err)
-			}
-			if len(ips) == 0 {
-				return summary, fmt.Errorf("group %s domain %q: no IPv4 records found", groupLabel(group, i), domain)
-			}
-			for _, ip := range ips {
-				if _, exists := seenHosts[ip]; exists {
-					continue
-				}
-				seenHosts[ip] = struct{}{}
-				mergedHosts = append(mergedHosts, ip)
-				summary.IPsAdded++
+// ResolveDomainsWithResolverConcurrent resolves domains of all groups in parallel
+// using up to workers concurrent lookups. Each unique domain is looked up once.
+func ResolveDomainsWithResolverConcurrent(rf *RoutesFile, resolver IPResolver, workers int) (ResolveSummary, error) {
+	return ResolveDomainsWithOptions(rf, resolver, ResolveOptions{Workers: workers})
+}
+
+// ResolveOptions controls timeouts and concurrency of ResolveDomainsWithOptions.
+type ResolveOptions struct {
+	// PerDomainTimeout limits all lookup attempts of one domain; zero means no limit
+	// beyond the timeout of each attempt.
+	PerDomainTimeout time.Duration
+	// TotalTimeout limits the whole resolution; zero means no limit.
+	TotalTimeout time.Duration
+	// Workers is the number of concurrent lookups; values below 1 mean 1.
+	Workers int
+}
+
+// ResolveDomainsWithOptions is like ResolveDomainsWithResolverConcurrent but bounds the run
+// with opts.TotalTimeout and each domain with opts.PerDomainTimeout. Domains not resolved
+// in time are reported as errors.
+func ResolveDomainsWithOptions(rf *RoutesFile, resolver IPResolver, opts ResolveOptions) (ResolveSummary, error) {
+	if rf == nil || len(rf.Routes) == 0 {
+		return ResolveSummary{}, nil
+	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	if err := validateRoutesFile(rf); err != nil {
+		return ResolveSummary{}, err
+	}
+
+	ctx := context.Background()
+	if opts.TotalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.TotalTimeout)
+		defer cancel()
+	}
+
+	pending := make(map[string]struct{})
+	for i := range rf.Routes {
+		group := &rf.Routes[i]
+		if len(group.Domains) == 0 {
+			continue
+		}
+		domains := groupDomains(group)
+		for _, d := range domains {
+			pending[d] = struct{}{}
+		}
+	}
+
+	type lookupResult struct {
+		ips []string
+		err error
+	}
+	results := make(map[string]lookupResult, len(pending))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for domain := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(domain string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			domainCtx := ctx
+			if opts.PerDomainTimeout > 0 {
+				var cancel context.CancelFunc
+				domainCtx, cancel = context.WithTimeout(ctx, opts.PerDomainTimeout)
+				defer cancel()
 			}
+			ips, err := lookupIPv4WithRetry(domainCtx, resolver, domain, domainLookupRetries, domainLookupDelay)
+			mu.Lock()
+			results[domain] = lookupResult{ips: ips, err: err}
+			mu.Unlock()
+		}(domain)
+	}
+	wg.Wait()
+
+	return resolveDomains(rf, func(domain string) ([]string, error) {
+		res := results[domain]
+		return res.ips, res.err
+	})
+}
+
+// groupDomains returns the trimmed, deduplicated domains of a validated group.
+func groupDomains(group *RouteGroup) []string {
+	seen := make(map[string]struct{})
+	domains := make([]string, 0, len(group.Domains))
+	for _, d := range group.Domains {
+		domain := strings.TrimSpace(d)
+		if _, exists := seen[domain]; exists {
+			continue
 		}
+		seen[domain] = struct{}{}
+		domains = append(domains, domain)
+	}
+	return domains
+}
 
-		group.Hosts = mergedHosts
+// dedupeHosts returns trimmed unique hosts and the set of them.
+func dedupeHosts(hosts HostList) (map[string]struct{}, HostList) {
+	seen := make(map[string]struct{})
+	merged := make(HostList, 0, len(hosts))
+	for _, h := range hosts {
+		trimmed := strings.TrimSpace(h.Host)
+		if trimmed == "" {
+			continue
+		}
+		if _, exists := seen[trimmed]; exists {
+			continue
+		}
+		seen[trimmed] = struct{}{}
+		merged = append(merged, HostEntry{Host: trimmed, Comment: h.Comment})
 	}
-	return summary, nil
+	return seen, merged
+}
+
+// mergeIPs appends ips not yet in seen to hosts and counts them in summary.
+func mergeIPs(hosts HostList, seen map[string]struct{}, ips []string, summary *ResolveSummary) HostList {
+	for _, ip := range ips {
+		if _, exists := seen[ip]; exists {
+			continue
+		}
+		seen[ip] = struct{}{}
+		hosts = append(hosts, HostEntry{Host: ip})
+		summary.IPsAdded++
+	}
+	return hosts
 }
 
 func lookupIPv4(resolver IPResolver, domain string) ([]string, error) {
+	return lookupIPv4WithRetry(context.Background(), resolver, domain, domainLookupRetries, domainLookupDelay)
+}
+
+// lookupIPv4WithRetry makes up to maxRetries lookup attempts, waiting baseDelay*2^attempt
+// between them. NXDOMAIN errors are returned immediately, and so is the context error
+// once ctx is done.
+func lookupIPv4WithRetry(ctx context.Context, resolver IPResolver, domain string, maxRetries int, baseDelay time.Duration) ([]string, error) {
 	if ip := net.ParseIP(domain); ip != nil {
 		if ip4 := ip.To4(); ip4 != nil {
 			return []string{ip4.String()}, nil
 		}
 		return nil, fmt.Errorf("IPv6 is not supported")
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), domainLookupTimeout)
-	defer cancel()
-
-	addrs, err := resolver.LookupIPAddr(ctx, domain)
+	if maxRetries < 1 {
+		maxRetries = 1
+	}
+	var addrs []net.IPAddr
+	var err error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(baseDelay * time.Duration(1<<(attempt-1))):
+			}
+		}
+		addrs, err = lookupIPAddr(ctx, resolver, domain)
+		if err == nil || !isTransientDNSError(err) {
+			break
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -120,6 +556,21 @@ func lookupIPv4(resolver IPResolver, domain string) ([]string, error) {
 	return ips, nil
 }
 
+func lookupIPAddr(ctx context.Context, resolver IPResolver, domain string) ([]net.IPAddr, error) {
+	ctx, cancel := context.WithTimeout(ctx, domainLookupTimeout)
+	defer cancel()
+	return resolver.LookupIPAddr(ctx, domain)
+}
+
+// isTransientDNSError reports whether a lookup error is worth retrying.
+func isTransientDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	return true
+}
+
 func groupLabel(group *RouteGroup, idx int) string {
 	if group != nil && group.Comment != "" {
 		return fmt.Sprintf("%q", group.Comment)
@@ -0,0 +1,385 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	mu      sync.Mutex
+	records map[string][]string
+	calls   map[string]int
+}
+
+func (f *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[host]++
+	ips, ok := f.records[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestResolveDomainsWithResolver(t *testing.T) {
+	resolver := &fakeResolver{records: map[string][]string{
+		"a.example": {"1.1.1.1", "2001:db8::1"},
+		"b.example": {"1.1.1.1", "2.2.2.2"},
+	}}
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "g", Gateway: "10.0.0.1", Hosts: NewHostList("8.8.8.8"), Domains: []string{"a.example", "b.example", "a.example"}},
+	}}
+	summary, err := ResolveDomainsWithResolver(rf, resolver)
+	if err != nil {
+		t.Fatalf("ResolveDomainsWithResolver: %v", err)
+	}
+	if summary.Groups != 1 || summary.Domains != 2 || summary.IPsAdded != 2 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	want := []string{"8.8.8.8", "1.1.1.1", "2.2.2.2"}
+	if fmt.Sprint(rf.Routes[0].Hosts.Strings()) != fmt.Sprint(want) {
+		t.Fatalf("hosts: got %v, want %v", rf.Routes[0].Hosts, want)
+	}
+}
+
+type countingResolver struct {
+	fakeResolver
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+}
+
+func (c *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	n := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	for {
+		cur := c.maxSeen.Load()
+		if n <= cur || c.maxSeen.CompareAndSwap(cur, n) {
+			break
+		}
+	}
+	return c.fakeResolver.LookupIPAddr(ctx, host)
+}
+
+func TestResolveDomainsWithResolverConcurrent(t *testing.T) {
+	records := make(map[string][]string)
+	var domains []string
+	for i := 1; i <= 20; i++ {
+		d := fmt.Sprintf("d%d.example", i)
+		records[d] = []string{fmt.Sprintf("10.1.0.%d", i)}
+		domains = append(domains, d)
+	}
+	resolver := &countingResolver{fakeResolver: fakeResolver{records: records}}
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "first", Gateway: "10.0.0.1", Domains: domains},
+		{Comment: "second", Interface: "Wireguard1", Domains: domains[:5]},
+	}}
+	summary, err := ResolveDomainsWithResolverConcurrent(rf, resolver, 4)
+	if err != nil {
+		t.Fatalf("ResolveDomainsWithResolverConcurrent: %v", err)
+	}
+	if summary.Groups != 2 || summary.Domains != 25 || summary.IPsAdded != 25 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	if got := resolver.maxSeen.Load(); got > 4 {
+		t.Fatalf("expected at most 4 concurrent lookups, got %d", got)
+	}
+	for d, n := range resolver.calls {
+		if n != 1 {
+			t.Fatalf("domain %s looked up %d times", d, n)
+		}
+	}
+	for i, h := range rf.Routes[0].Hosts.Strings() {
+		if want := fmt.Sprintf("10.1.0.%d", i+1); h != want {
+			t.Fatalf("host order: got %s at %d, want %s", h, i, want)
+		}
+	}
+}
+
+// blockingResolver answers known hosts immediately and blocks on the rest until ctx is done.
+type blockingResolver struct {
+	fakeResolver
+}
+
+func (b *blockingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if _, ok := b.records[host]; ok {
+		return b.fakeResolver.LookupIPAddr(ctx, host)
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestResolveDomainsWithOptions(t *testing.T) {
+	newFile := func() *RoutesFile {
+		return &RoutesFile{Routes: []RouteGroup{
+			{Gateway: "10.0.0.1", Domains: []string{"fast.example", "slow.example"}},
+		}}
+	}
+	resolver := &blockingResolver{fakeResolver{records: map[string][]string{"fast.example": {"1.1.1.1"}}}}
+
+	for _, opts := range []ResolveOptions{
+		{PerDomainTimeout: 20 * time.Millisecond, Workers: 2},
+		{TotalTimeout: 20 * time.Millisecond, Workers: 2},
+	} {
+		rf := newFile()
+		start := time.Now()
+		summary, err := ResolveDomainsWithOptions(rf, resolver, opts)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("%+v: resolution took %v", opts, elapsed)
+		}
+		var resolveErr *ResolveError
+		if !errors.As(err, &resolveErr) || len(resolveErr.Errors) != 1 || resolveErr.Errors[0].Domain != "slow.example" {
+			t.Fatalf("%+v: expected timeout for slow.example, got %v", opts, err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%+v: expected deadline error, got %v", opts, err)
+		}
+		if summary.IPsAdded != 1 || fmt.Sprint(rf.Routes[0].Hosts.Strings()) != "[1.1.1.1]" {
+			t.Fatalf("%+v: unexpected result: %+v %v", opts, summary, rf.Routes[0].Hosts)
+		}
+	}
+}
+
+func TestResolveDomainsAggregatesErrors(t *testing.T) {
+	resolver := &fakeResolver{records: map[string][]string{
+		"ok.example":    {"1.1.1.1"},
+		"ipv6.example":  {"2001:db8::1"},
+		"other.example": {"2.2.2.2"},
+	}}
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "g", Gateway: "10.0.0.1", Domains: []string{"missing.example", "ok.example", "ipv6.example"}},
+		{Comment: "h", Interface: "Wireguard1", Domains: []string{"other.example"}},
+	}}
+	summary, err := ResolveDomainsWithResolverConcurrent(rf, resolver, 2)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) {
+		t.Fatalf("expected *ResolveError, got %v", err)
+	}
+	if len(resolveErr.Errors) != 2 || len(summary.Errors) != 2 {
+		t.Fatalf("expected 2 domain errors, got %+v", resolveErr.Errors)
+	}
+	if resolveErr.Errors[0].Domain != "missing.example" || resolveErr.Errors[1].Domain != "ipv6.example" {
+		t.Fatalf("unexpected failed domains: %+v", resolveErr.Errors)
+	}
+	if !errors.Is(err, errNoIPv4Records) {
+		t.Fatalf("expected errNoIPv4Records in chain")
+	}
+	if len(rf.Routes[0].Hosts) != 1 || rf.Routes[0].Hosts[0].Host != "1.1.1.1" {
+		t.Fatalf("unexpected hosts in first group: %v", rf.Routes[0].Hosts)
+	}
+	if len(rf.Routes[1].Hosts) != 1 || rf.Routes[1].Hosts[0].Host != "2.2.2.2" {
+		t.Fatalf("unexpected hosts in second group: %v", rf.Routes[1].Hosts)
+	}
+}
+
+type flakyResolver struct {
+	failures int
+	calls    int
+	err      error
+}
+
+func (f *flakyResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, f.err
+	}
+	return []net.IPAddr{{IP: net.ParseIP("1.2.3.4")}}, nil
+}
+
+func TestLookupIPv4WithRetry(t *testing.T) {
+	transient := &net.DNSError{Err: "server misbehaving", Name: "a.example", IsTemporary: true}
+	notFound := &net.DNSError{Err: "no such host", Name: "a.example", IsNotFound: true}
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "first_try", failures: 0, err: transient, wantCalls: 1},
+		{name: "recovers", failures: 2, err: transient, wantCalls: 3},
+		{name: "gives_up", failures: 5, err: transient, wantCalls: 3, wantErr: true},
+		{name: "nxdomain_not_retried", failures: 5, err: notFound, wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &flakyResolver{failures: tt.failures, err: tt.err}
+			ips, err := lookupIPv4WithRetry(context.Background(), resolver, "a.example", 3, time.Millisecond)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+			} else if err != nil || len(ips) != 1 || ips[0] != "1.2.3.4" {
+				t.Fatalf("unexpected result: %v, %v", ips, err)
+			}
+			if resolver.calls != tt.wantCalls {
+				t.Fatalf("calls: got %d, want %d", resolver.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResolveDomainsWithCache(t *testing.T) {
+	resolver := &fakeResolver{records: map[string][]string{"a.example": {"1.1.1.1"}}}
+	cache := NewDNSCache(time.Hour)
+	for i := 0; i < 2; i++ {
+		rf := &RoutesFile{Routes: []RouteGroup{
+			{Comment: "g", Gateway: "10.0.0.1", Domains: []string{"a.example"}},
+		}}
+		if _, err := ResolveDomainsWithCache(rf, resolver, cache); err != nil {
+			t.Fatalf("ResolveDomainsWithCache: %v", err)
+		}
+		if len(rf.Routes[0].Hosts) != 1 || rf.Routes[0].Hosts[0].Host != "1.1.1.1" {
+			t.Fatalf("unexpected hosts: %v", rf.Routes[0].Hosts)
+		}
+	}
+	if n := resolver.calls["a.example"]; n != 1 {
+		t.Fatalf("expected 1 lookup, got %d", n)
+	}
+
+	cache.SetWithTTL("a.example", []string{"1.1.1.1"}, -time.Second)
+	if _, ok := cache.Get("a.example"); ok {
+		t.Fatalf("expected expired entry to miss")
+	}
+}
+
+func TestNewCustomResolver(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	got := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 512)
+		if _, _, err := pc.ReadFrom(buf); err == nil {
+			got <- struct{}{}
+		}
+	}()
+
+	resolver := NewCustomResolver(pc.LocalAddr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, _ = resolver.LookupIPAddr(ctx, "example.test")
+
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatalf("custom DNS server did not receive a query")
+	}
+}
+
+func TestDoHResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/dns-json" || r.URL.Query().Get("type") != "A" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Query().Get("name") {
+		case "a.example":
+			fmt.Fprint(w, `{"Status":0,"Answer":[{"name":"a.example","type":5,"data":"b.example."},{"name":"b.example","type":1,"data":"1.2.3.4"}]}`)
+		case "broken.example":
+			fmt.Fprint(w, `{"Status":2}`)
+		default:
+			fmt.Fprint(w, `{"Status":3}`)
+		}
+	}))
+	defer server.Close()
+
+	resolver := NewDoHResolver(server.URL)
+	addrs, err := resolver.LookupIPAddr(context.Background(), "a.example")
+	if err != nil {
+		t.Fatalf("LookupIPAddr: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0].IP.String() != "1.2.3.4" {
+		t.Fatalf("unexpected addrs: %v", addrs)
+	}
+
+	_, err = resolver.LookupIPAddr(context.Background(), "missing.example")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if _, err := resolver.LookupIPAddr(context.Background(), "broken.example"); err == nil || !isTransientDNSError(err) {
+		t.Fatalf("expected transient error, got %v", err)
+	}
+}
+
+func TestFallbackResolver(t *testing.T) {
+	down := &flakyResolver{failures: 100, err: errors.New("connection refused")}
+	empty := &fakeResolver{}
+	good := &fakeResolver{records: map[string][]string{"a.example": {"1.1.1.1"}}}
+
+	addrs, err := NewFallbackResolver(down, good).LookupIPAddr(context.Background(), "a.example")
+	if err != nil || len(addrs) != 1 || addrs[0].IP.String() != "1.1.1.1" {
+		t.Fatalf("unexpected result: %v, %v", addrs, err)
+	}
+	if down.calls != 1 || good.calls["a.example"] != 1 {
+		t.Fatalf("unexpected calls: down=%d good=%v", down.calls, good.calls)
+	}
+
+	_, err = NewFallbackResolver(down, empty).LookupIPAddr(context.Background(), "a.example")
+	if err == nil || !strings.Contains(err.Error(), "connection refused") || !strings.Contains(err.Error(), "no such host") {
+		t.Fatalf("expected combined error, got %v", err)
+	}
+
+	if _, err := NewFallbackResolver().LookupIPAddr(context.Background(), "a.example"); err == nil {
+		t.Fatalf("expected error for empty chain")
+	}
+}
+
+func TestSaveUnresolved(t *testing.T) {
+	resolver := &fakeResolver{records: map[string][]string{"ok.example": {"1.1.1.1"}}}
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "resolved", Gateway: "10.0.0.1", Domains: []string{"ok.example"}},
+		{Comment: "partial", Interface: "Wireguard1", Hosts: NewHostList("8.8.8.8"), Domains: []string{"ok.example", "missing.example"}},
+	}}
+	_, err := ResolveDomainsWithResolver(rf, resolver)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) {
+		t.Fatalf("expected *ResolveError, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "routes.unresolved.yaml")
+	if err := SaveUnresolved(path, rf, resolveErr.Errors); err != nil {
+		t.Fatalf("SaveUnresolved: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if !strings.Contains(string(data), "- missing.example # lookup missing.example") {
+		t.Fatalf("expected error comment, got:\n%s", data)
+	}
+	loaded, err := LoadYAML(path)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if len(loaded.Routes) != 1 {
+		t.Fatalf("expected 1 group, got %+v", loaded.Routes)
+	}
+	g := loaded.Routes[0]
+	if g.Comment != "partial" || g.Interface != "Wireguard1" || len(g.Hosts) != 0 || fmt.Sprint(g.Domains) != "[missing.example]" {
+		t.Fatalf("unexpected group: %+v", g)
+	}
+}
@@ -0,0 +1,32 @@
+package routes
+
+import "fmt"
+
+// CodeInvalidRoute is the error code of ValidationError.
+const CodeInvalidRoute = "INVALID_ROUTE"
+
+// ValidationError is returned when a routes file has an invalid host, gateway, or group.
+// Err holds the detailed message and the underlying parse error, if any.
+type ValidationError struct {
+	Code string
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, &ValidationError{}) match any ValidationError.
+func (e *ValidationError) Is(target error) bool {
+	_, ok := target.(*ValidationError)
+	return ok
+}
+
+// validationErrorf formats a ValidationError; %w wraps as in fmt.Errorf.
+func validationErrorf(format string, args ...any) error {
+	return &ValidationError{Code: CodeInvalidRoute, Err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,180 @@
+package routes
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Severity tells whether a lint issue makes a routes file unusable.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// LintIssue is a problem found in a routes file by Lint.
+type LintIssue struct {
+	Severity Severity
+	// Group is the group label used in validation errors: its quoted comment or #N.
+	Group   string
+	Message string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s: group %s: %s", i.Severity, i.Group, i.Message)
+}
+
+// Lint runs the checks of ValidateRouteGroup on every group of rf and reports all problems
+// instead of stopping at the first one. Invalid hosts, gateways, and targets and empty hosts
+// or domains are errors; hosts repeated within a group or across groups are warnings.
+func Lint(rf *RoutesFile) []LintIssue {
+	var issues []LintIssue
+	firstGroup := make(map[string]string)
+	for i := range rf.Routes {
+		g := &rf.Routes[i]
+		label := groupLabel(g, i)
+		add := func(sev Severity, format string, args ...any) {
+			issues = append(issues, LintIssue{Severity: sev, Group: label, Message: fmt.Sprintf(format, args...)})
+		}
+
+		for _, p := range groupProblems(*g) {
+			add(SeverityError, "%s", p)
+		}
+
+		seen := make(map[string]struct{}, len(g.Hosts))
+		for _, e := range g.Hosts {
+			if strings.TrimSpace(e.Host) == "" {
+				add(SeverityError, "empty host entry")
+				continue
+			}
+			hosts, err := normalizeGroupHost(e.Host)
+			if err != nil {
+				add(SeverityError, "host %q: %v", e.Host, err)
+				continue
+			}
+			// Duplicates fail ValidateRouteGroup but are only warnings here: fix removes them.
+			for _, norm := range hosts {
+				if _, dup := seen[norm]; dup {
+					add(SeverityWarning, "duplicate host %s", norm)
+					continue
+				}
+				seen[norm] = struct{}{}
+				if first, dup := firstGroup[norm]; dup {
+					add(SeverityWarning, "host %s is also in group %s", norm, first)
+					continue
+				}
+				firstGroup[norm] = label
+			}
+		}
+	}
+	return issues
+}
+
+// IPv6Issues reports the IPv6 gateways and hosts of rf as errors, for callers that accept
+// only IPv4 routes, as the app does unless --ipv6 is set.
+func IPv6Issues(rf *RoutesFile) []LintIssue {
+	var issues []LintIssue
+	for i := range rf.Routes {
+		g := &rf.Routes[i]
+		if IsIPv6(g.Gateway) {
+			issues = append(issues, LintIssue{Severity: SeverityError, Group: groupLabel(g, i), Message: fmt.Sprintf("IPv6 gateway %s requires --ipv6", g.Gateway)})
+		}
+		for _, e := range g.Hosts {
+			if IsIPv6(strings.TrimSpace(e.Host)) {
+				issues = append(issues, LintIssue{Severity: SeverityError, Group: groupLabel(g, i), Message: fmt.Sprintf("IPv6 host %s requires --ipv6", strings.TrimSpace(e.Host))})
+			}
+		}
+	}
+	return issues
+}
+
+// FixSummary counts the changes made by FixFile.
+type FixSummary struct {
+	Duplicates int
+	CIDRs      int
+	Trimmed    int
+	Empty      int
+	// Sorted counts groups whose hosts were reordered.
+	Sorted int
+}
+
+// String describes the changes, e.g. "Fixed 3 duplicate hosts, normalized 2 CIDRs."
+func (s FixSummary) String() string {
+	var parts []string
+	if s.Duplicates > 0 {
+		parts = append(parts, fmt.Sprintf("fixed %d duplicate hosts", s.Duplicates))
+	}
+	if s.CIDRs > 0 {
+		parts = append(parts, fmt.Sprintf("normalized %d CIDRs", s.CIDRs))
+	}
+	if s.Trimmed > 0 {
+		parts = append(parts, fmt.Sprintf("trimmed %d fields", s.Trimmed))
+	}
+	if s.Empty > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d empty hosts", s.Empty))
+	}
+	if s.Sorted > 0 {
+		parts = append(parts, fmt.Sprintf("sorted hosts of %d groups", s.Sorted))
+	}
+	if len(parts) == 0 {
+		return "Nothing to fix."
+	}
+	out := strings.Join(parts, ", ") + "."
+	return strings.ToUpper(out[:1]) + out[1:]
+}
+
+// FixFile corrects the issues of rf that have a single obvious fix: it trims whitespace
+// from all string fields, drops empty hosts, corrects CIDRs with host bits set to their
+// network, removes repeated hosts within a group, and sorts the hosts of each group.
+// Invalid hosts and targets are left for Lint to report.
+func FixFile(rf *RoutesFile) FixSummary {
+	var sum FixSummary
+	if rf == nil {
+		return sum
+	}
+	trim := func(s *string) {
+		if t := strings.TrimSpace(*s); t != *s {
+			*s = t
+			sum.Trimmed++
+		}
+	}
+	for i := range rf.Routes {
+		g := &rf.Routes[i]
+		trim(&g.Comment)
+		trim(&g.Gateway)
+		trim(&g.Interface)
+		for j := range g.Domains {
+			trim(&g.Domains[j])
+		}
+		for j := range g.Tags {
+			trim(&g.Tags[j])
+		}
+
+		hosts := make(HostList, 0, len(g.Hosts))
+		for _, e := range g.Hosts {
+			trim(&e.Host)
+			trim(&e.Comment)
+			if e.Host == "" {
+				sum.Empty++
+				continue
+			}
+			if ip, n, err := net.ParseCIDR(e.Host); err == nil && !ip.Equal(n.IP) {
+				e.Host = n.String()
+				sum.CIDRs++
+			}
+			hosts = append(hosts, e)
+		}
+		unique := appendUniqueHosts(nil, hosts)
+		sum.Duplicates += len(hosts) - len(unique)
+		less := func(a, b int) bool { return unique[a].Host < unique[b].Host }
+		if !sort.SliceIsSorted(unique, less) {
+			sort.SliceStable(unique, less)
+			sum.Sorted++
+		}
+		g.Hosts = unique
+	}
+	return sum
+}
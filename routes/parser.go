@@ -1,43 +1,184 @@
 package routes
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Route is a full route: host plus all Keenetic parameters.
 type Route struct {
-	Host      string
-	Comment   string
-	Gateway   string
-	Interface string
-	Auto      bool
-	Reject    bool
+	Host      string `json:"destination"`
+	Comment   string `json:"comment"`
+	Gateway   string `json:"gateway"`
+	Interface string `json:"interface"`
+	Auto      bool   `json:"auto"`
+	Reject    bool   `json:"reject"`
 }
 
 // RouteGroup is a YAML group: shared params, hosts, and domains.
 type RouteGroup struct {
-	Comment   string   `yaml:"comment,omitempty"`
-	Gateway   string   `yaml:"gateway,omitempty"`
-	Interface string   `yaml:"interface,omitempty"`
-	Auto      bool     `yaml:"auto,omitempty"`
-	Reject    bool     `yaml:"reject,omitempty"`
-	Hosts     []string `yaml:"hosts"`
-	Domains   []string `yaml:"domains,omitempty"`
+	Comment   string   `yaml:"comment,omitempty" json:"comment,omitempty"`
+	Gateway   string   `yaml:"gateway,omitempty" json:"gateway,omitempty"`
+	Interface string   `yaml:"interface,omitempty" json:"interface,omitempty"`
+	Auto      bool     `yaml:"auto,omitempty" json:"auto,omitempty"`
+	Reject    bool     `yaml:"reject,omitempty" json:"reject,omitempty"`
+	Hosts     HostList `yaml:"hosts" json:"hosts"`
+	Domains   []string `yaml:"domains,omitempty" json:"domains,omitempty"`
+	// Enabled set to false excludes the group from upload; nil means enabled.
+	Enabled *bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	// Tags label the group for selective upload, backup, and clear (--tags).
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// IsEnabled reports whether the group is not disabled with enabled: false.
+func (g RouteGroup) IsEnabled() bool {
+	return g.Enabled == nil || *g.Enabled
+}
+
+// HostEntry is a host of a group with an optional comment overriding the group one.
+type HostEntry struct {
+	Host    string `yaml:"host" json:"host"`
+	Comment string `yaml:"comment,omitempty" json:"comment,omitempty"`
+}
+
+// MarshalYAML writes the entry as a plain string when it has no comment.
+func (e HostEntry) MarshalYAML() (interface{}, error) {
+	if e.Comment == "" {
+		return e.Host, nil
+	}
+	type plain HostEntry
+	return plain(e), nil
+}
+
+// MarshalJSON writes the entry as a plain string when it has no comment.
+func (e HostEntry) MarshalJSON() ([]byte, error) {
+	if e.Comment == "" {
+		return json.Marshal(e.Host)
+	}
+	type plain HostEntry
+	return json.Marshal(plain(e))
+}
+
+// UnmarshalJSON accepts either "1.2.3.4" or {"host": ..., "comment": ...}.
+func (e *HostEntry) UnmarshalJSON(data []byte) error {
+	var host string
+	if err := json.Unmarshal(data, &host); err == nil {
+		*e = HostEntry{Host: host}
+		return nil
+	}
+	type plain HostEntry
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// HostList is a YAML hosts list whose items are either "1.2.3.4" or {host, comment} maps.
+type HostList []HostEntry
+
+// NewHostList builds a HostList of hosts without comments.
+func NewHostList(hosts ...string) HostList {
+	l := make(HostList, 0, len(hosts))
+	for _, h := range hosts {
+		l = append(l, HostEntry{Host: h})
+	}
+	return l
+}
+
+// Strings returns the hosts without comments.
+func (l HostList) Strings() []string {
+	out := make([]string, 0, len(l))
+	for _, e := range l {
+		out = append(out, e.Host)
+	}
+	return out
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *HostList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: hosts must be a list", node.Line)
+	}
+	list := make(HostList, 0, len(node.Content))
+	for _, item := range node.Content {
+		var e HostEntry
+		switch item.Kind {
+		case yaml.ScalarNode:
+			e.Host = item.Value
+		case yaml.MappingNode:
+			type plain HostEntry
+			if err := item.Decode((*plain)(&e)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("line %d: host must be a string or a {host, comment} map", item.Line)
+		}
+		list = append(list, e)
+	}
+	*l = list
+	return nil
 }
 
 // RoutesFile is the root YAML structure.
 type RoutesFile struct {
-	Routes []RouteGroup `yaml:"routes"`
+	Metadata *Metadata    `yaml:"metadata,omitempty" json:"metadata,omitempty"`
+	Routes   []RouteGroup `yaml:"routes" json:"routes"`
+	// Includes lists the files pulled in with !include while parsing, in the order they were read.
+	// Writing the file back inlines their groups, so rewriting commands refuse such files.
+	Includes []string `yaml:"-" json:"-"`
+	// Warnings lists input corrected while parsing, e.g. a CIDR with host bits set.
+	Warnings []string `yaml:"-" json:"-"`
+}
+
+// Metadata describes where a routes file came from.
+type Metadata struct {
+	CreatedAt   time.Time `yaml:"created_at,omitempty" json:"created_at,omitempty"`
+	Version     string    `yaml:"version,omitempty" json:"version,omitempty"`
+	Description string    `yaml:"description,omitempty" json:"description,omitempty"`
 }
 
 // normalizeHost validates and normalizes an IP address or CIDR.
-func normalizeHost(s string) (string, error) {
+// A CIDR with host bits set (10.0.0.5/24) is corrected to its network, and a warning is
+// appended to warnings unless it is nil.
+func normalizeHost(s string, warnings *[]string) (string, error) {
+	norm, err := parseHost(s)
+	if err != nil {
+		return "", err
+	}
+	if ip, n, err := net.ParseCIDR(strings.TrimSpace(s)); err == nil && !ip.Equal(n.IP) && warnings != nil {
+		*warnings = append(*warnings, fmt.Sprintf("normalizing %s to %s", strings.TrimSpace(s), norm))
+	}
+	return norm, nil
+}
+
+// hostWarnings returns the warnings normalizeHost gives for the hosts of groups. Invalid
+// hosts are skipped; validation reports them.
+func hostWarnings(groups []RouteGroup) []string {
+	var warnings []string
+	for _, g := range groups {
+		for _, e := range g.Hosts {
+			expanded, err := expandHostRange(e.Host)
+			if err != nil {
+				continue
+			}
+			for _, host := range expanded {
+				_, _ = normalizeHost(host, &warnings)
+			}
+		}
+	}
+	return warnings
+}
+
+// parseHost is normalizeHost without warnings.
+func parseHost(s string) (string, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
 		return "", fmt.Errorf("empty host")
@@ -56,6 +197,113 @@ func normalizeHost(s string) (string, error) {
 	return ip.String(), nil
 }
 
+// maxHostRange limits how many addresses a single host range may expand to.
+const maxHostRange = 256
+
+// expandHostRange expands "A.B.C.D-E.F.G.H" into every IPv4 address of the inclusive range.
+// Any other value is returned as is.
+func expandHostRange(s string) ([]string, error) {
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return []string{s}, nil
+	}
+	start := net.ParseIP(strings.TrimSpace(startStr)).To4()
+	end := net.ParseIP(strings.TrimSpace(endStr)).To4()
+	if start == nil || end == nil {
+		return nil, fmt.Errorf("invalid range: both ends must be IPv4 addresses")
+	}
+	first := binary.BigEndian.Uint32(start)
+	last := binary.BigEndian.Uint32(end)
+	if first > last {
+		return nil, fmt.Errorf("invalid range: start is after end")
+	}
+	if last-first >= maxHostRange {
+		return nil, fmt.Errorf("range has %d addresses, limit is %d", uint64(last-first)+1, maxHostRange)
+	}
+	out := make([]string, 0, last-first+1)
+	for n := first; ; n++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, n)
+		out = append(out, ip.String())
+		if n == last {
+			break
+		}
+	}
+	return out, nil
+}
+
+// ValidateRouteGroup checks group idx of a routes file: domains must be non-empty,
+// hosts must be valid and unique, the gateway must be an IP address, and a group with
+// hosts or domains must set exactly one of gateway or interface. IPv6 gateways and hosts
+// pass; callers that route only IPv4 check them with IsIPv6 or IPv6Issues.
+func ValidateRouteGroup(g RouteGroup, idx int) error {
+	label := groupLabel(&g, idx)
+	if problems := groupProblems(g); len(problems) > 0 {
+		return validationErrorf("group %s: %s", label, problems[0])
+	}
+	seen := make(map[string]struct{}, len(g.Hosts))
+	for _, e := range g.Hosts {
+		hosts, err := normalizeGroupHost(e.Host)
+		if err != nil {
+			return validationErrorf("group %s host %q: %w", label, e.Host, err)
+		}
+		for _, norm := range hosts {
+			if _, exists := seen[norm]; exists {
+				return validationErrorf("group %s: duplicate host %s", label, norm)
+			}
+			seen[norm] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// groupProblems returns the problems of the target and domains of g, in the order
+// ValidateRouteGroup reports them. Lint reports all of them.
+func groupProblems(g RouteGroup) []string {
+	var problems []string
+	if len(g.Hosts) > 0 || len(g.Domains) > 0 {
+		if (g.Gateway == "") == (strings.TrimSpace(g.Interface) == "") {
+			problems = append(problems, "set exactly one of gateway or interface")
+		}
+	}
+	if g.Gateway != "" && net.ParseIP(g.Gateway) == nil {
+		problems = append(problems, fmt.Sprintf("gateway %q is not a valid IP address", g.Gateway))
+	}
+	for _, d := range g.Domains {
+		if strings.TrimSpace(d) == "" {
+			problems = append(problems, "empty domain entry")
+		}
+	}
+	return problems
+}
+
+// normalizeGroupHost expands a host entry of a group (an IP, CIDR, or IPv4 range) to
+// its normalized destinations.
+func normalizeGroupHost(h string) ([]string, error) {
+	expanded, err := expandHostRange(h)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, 0, len(expanded))
+	for _, host := range expanded {
+		norm, err := parseHost(host)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, norm)
+	}
+	return hosts, nil
+}
+
+func validateRoutesFile(rf *RoutesFile) error {
+	for i, g := range rf.Routes {
+		if err := ValidateRouteGroup(g, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // LoadYAML reads a YAML routes file. Returns nil RoutesFile and nil error if file does not exist (for merge).
 func LoadYAML(path string) (*RoutesFile, error) {
 	data, err := os.ReadFile(path)
@@ -65,63 +313,437 @@ func LoadYAML(path string) (*RoutesFile, error) {
 		}
 		return nil, fmt.Errorf("read file: %w", err)
 	}
-	var rf RoutesFile
-	if err := yaml.Unmarshal(data, &rf); err != nil {
+	return parseYAML(data, filepath.Dir(path))
+}
+
+// ParseYAML parses YAML routes data, e.g. read from stdin. Included files are resolved
+// relative to the current directory.
+func ParseYAML(data []byte) (*RoutesFile, error) {
+	return parseYAML(data, ".")
+}
+
+// maxIncludeDepth limits nested !include directives, which also stops circular includes.
+const maxIncludeDepth = 10
+
+// parseYAML parses YAML routes data, replacing "- !include path" items of the routes list
+// with the groups of the referenced file. Relative paths are resolved from dir.
+func parseYAML(data []byte, dir string) (*RoutesFile, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, fmt.Errorf("parse YAML: %w", err)
 	}
+	var rf RoutesFile
+	if doc.Kind != 0 {
+		if err := expandIncludes(&doc, dir, 0, &rf.Includes); err != nil {
+			return nil, err
+		}
+		if err := doc.Decode(&rf); err != nil {
+			return nil, fmt.Errorf("parse YAML: %w", err)
+		}
+	}
 	if rf.Routes == nil {
 		rf.Routes = []RouteGroup{}
 	}
+	rf.Warnings = hostWarnings(rf.Routes)
 	return &rf, nil
 }
 
-// SaveYAML writes RoutesFile to path as YAML.
-func SaveYAML(path string, rf *RoutesFile) error {
+// expandIncludes replaces the !include items of the routes list of doc with the routes of
+// the files they name, recursively. The paths of the included files are appended to includes.
+func expandIncludes(doc *yaml.Node, dir string, depth int, includes *[]string) error {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	list := mappingValue(root, "routes")
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return nil
+	}
+	items := make([]*yaml.Node, 0, len(list.Content))
+	for _, item := range list.Content {
+		if item.Tag != "!include" {
+			items = append(items, item)
+			continue
+		}
+		if depth >= maxIncludeDepth {
+			return fmt.Errorf("include %s: more than %d nested includes (circular include?)", item.Value, maxIncludeDepth)
+		}
+		path := item.Value
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("include %s: %w", item.Value, err)
+		}
+		*includes = append(*includes, path)
+		var included yaml.Node
+		if err := yaml.Unmarshal(data, &included); err != nil {
+			return fmt.Errorf("include %s: parse YAML: %w", item.Value, err)
+		}
+		if included.Kind == 0 {
+			continue
+		}
+		if err := expandIncludes(&included, filepath.Dir(path), depth+1, includes); err != nil {
+			return fmt.Errorf("include %s: %w", item.Value, err)
+		}
+		incRoot := included.Content[0]
+		if incRoot.Kind != yaml.MappingNode {
+			return fmt.Errorf("include %s: expected a routes file", item.Value)
+		}
+		if incList := mappingValue(incRoot, "routes"); incList != nil && incList.Kind == yaml.SequenceNode {
+			items = append(items, incList.Content...)
+		}
+	}
+	list.Content = items
+	return nil
+}
+
+// LoadText reads a plain-text file with one IP or CIDR per line into a single group with
+// the given gateway, interface, and comment. Blank lines and lines starting with # are skipped.
+func LoadText(path string, gateway, iface, comment string) (*RoutesFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return ParseText(data, gateway, iface, comment)
+}
+
+// ParseText parses plain-text routes data in the format read by LoadText.
+func ParseText(data []byte, gateway, iface, comment string) (*RoutesFile, error) {
+	group := RouteGroup{Comment: comment, Gateway: gateway, Interface: iface}
+	var warnings []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		host, err := normalizeHost(line, &warnings)
+		if err != nil {
+			return nil, validationErrorf("line %d %q: %w", i+1, line, err)
+		}
+		group.Hosts = append(group.Hosts, HostEntry{Host: host})
+	}
+	return &RoutesFile{Routes: []RouteGroup{group}, Warnings: warnings}, nil
+}
+
+// LoadCSV reads a CSV file with a header row. The destination column is required and holds
+// an IP, "ip/prefix", or "ip,mask"; optional gateway, interface, and comment columns set the
+// route parameters. Rows with the same gateway, interface, and comment form one group.
+func LoadCSV(path string) (*RoutesFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["destination"]; !ok {
+		return nil, fmt.Errorf("CSV header: missing required column \"destination\"")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var b groupBuilder
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse CSV: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		host, err := parseDestination(field(record, "destination"), &b.warnings)
+		if err != nil {
+			return nil, validationErrorf("line %d: %w", line, err)
+		}
+		b.add(host, field(record, "gateway"), field(record, "interface"), field(record, "comment"))
+	}
+	return b.file(), nil
+}
+
+// groupBuilder collects hosts into groups with the same gateway, interface, and comment,
+// keeping the order in which groups first appear.
+type groupBuilder struct {
+	index    map[[3]string]int
+	groups   []RouteGroup
+	warnings []string
+}
+
+func (b *groupBuilder) add(host, gateway, iface, comment string) {
+	if b.index == nil {
+		b.index = make(map[[3]string]int)
+	}
+	k := [3]string{gateway, iface, comment}
+	i, ok := b.index[k]
+	if !ok {
+		i = len(b.groups)
+		b.index[k] = i
+		b.groups = append(b.groups, RouteGroup{Comment: comment, Gateway: gateway, Interface: iface})
+	}
+	b.groups[i].Hosts = append(b.groups[i].Hosts, HostEntry{Host: host})
+}
+
+func (b *groupBuilder) file() *RoutesFile {
+	if b.groups == nil {
+		return &RoutesFile{Routes: []RouteGroup{}, Warnings: b.warnings}
+	}
+	return &RoutesFile{Routes: b.groups, Warnings: b.warnings}
+}
+
+// LoadJSON reads a JSON routes file: either the RoutesFile structure ({"routes": [...]})
+// or a flat array of {"host", "gateway", "interface", "comment"} objects, grouped like LoadCSV.
+func LoadJSON(path string) (*RoutesFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return ParseJSON(data)
+}
+
+// ParseJSON parses JSON routes data in either of the forms read by LoadJSON.
+func ParseJSON(data []byte) (*RoutesFile, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []struct {
+			Host      string `json:"host"`
+			Gateway   string `json:"gateway"`
+			Interface string `json:"interface"`
+			Comment   string `json:"comment"`
+		}
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, fmt.Errorf("parse JSON: %w", err)
+		}
+		var b groupBuilder
+		for i, item := range items {
+			host, err := normalizeHost(item.Host, &b.warnings)
+			if err != nil {
+				return nil, validationErrorf("item %d host %q: %w", i+1, item.Host, err)
+			}
+			b.add(host, item.Gateway, item.Interface, item.Comment)
+		}
+		return b.file(), nil
+	}
+	var rf RoutesFile
+	if err := json.Unmarshal(trimmed, &rf); err != nil {
+		return nil, fmt.Errorf("parse JSON: %w", err)
+	}
+	if rf.Routes == nil {
+		rf.Routes = []RouteGroup{}
+	}
+	rf.Warnings = hostWarnings(rf.Routes)
+	return &rf, nil
+}
+
+// SaveJSON writes RoutesFile to path as indented JSON.
+func SaveJSON(path string, rf *RoutesFile) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, rf); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
+
+// WriteJSON writes rf to w as indented JSON, as SaveJSON does.
+func WriteJSON(w io.Writer, rf *RoutesFile) error {
 	if rf == nil {
 		rf = &RoutesFile{Routes: []RouteGroup{}}
 	}
+	data, err := json.MarshalIndent(rf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal JSON: %w", err)
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write JSON: %w", err)
+	}
+	return nil
+}
+
+// parseDestination normalizes "ip", "ip/prefix", or "ip,mask" into an IP or CIDR, appending
+// normalization warnings to warnings as normalizeHost does.
+func parseDestination(s string, warnings *[]string) (string, error) {
+	addr, mask, ok := strings.Cut(s, ",")
+	if !ok {
+		return normalizeHost(s, warnings)
+	}
+	ip := net.ParseIP(strings.TrimSpace(addr)).To4()
+	m := net.ParseIP(strings.TrimSpace(mask)).To4()
+	if ip == nil || m == nil {
+		return "", fmt.Errorf("invalid destination %q: want IPv4 address and mask", s)
+	}
+	ones, bits := net.IPMask(m).Size()
+	if bits == 0 {
+		return "", fmt.Errorf("invalid destination %q: non-contiguous mask", s)
+	}
+	return normalizeHost(fmt.Sprintf("%s/%d", ip, ones), warnings)
+}
+
+// SaveYAML writes RoutesFile to path as YAML, replacing the file atomically.
+// If rf has metadata with a zero CreatedAt, the current time is written instead; rf itself
+// is not changed. Files without metadata are written without it.
+func SaveYAML(path string, rf *RoutesFile) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return fmt.Errorf("create output directory: %w", err)
 	}
+	var buf bytes.Buffer
+	if err := WriteYAML(&buf, rf); err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
+
+// WriteYAML writes rf to w as YAML, filling in Metadata.CreatedAt like SaveYAML.
+func WriteYAML(w io.Writer, rf *RoutesFile) error {
+	if rf == nil {
+		rf = &RoutesFile{Routes: []RouteGroup{}}
+	}
+	if rf.Metadata != nil && rf.Metadata.CreatedAt.IsZero() {
+		// Stamp a copy: the caller's rf is left as it was.
+		meta := *rf.Metadata
+		meta.CreatedAt = time.Now().UTC().Truncate(time.Second)
+		cp := *rf
+		cp.Metadata = &meta
+		rf = &cp
+	}
 	data, err := yaml.Marshal(rf)
 	if err != nil {
 		return fmt.Errorf("marshal YAML: %w", err)
 	}
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return fmt.Errorf("write file: %w", err)
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("write YAML: %w", err)
 	}
 	return nil
 }
 
+// WriteFileAtomic writes data to a temporary file next to path and renames it over path,
+// so readers never see a partly written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// HasAnyTag reports whether the group has at least one of tags.
+func (g RouteGroup) HasAnyTag(tags []string) bool {
+	for _, t := range g.Tags {
+		for _, want := range tags {
+			if t == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FilterByTags returns a RoutesFile with only the groups having at least one of tags.
+// With no tags, rf is returned unchanged.
+func FilterByTags(rf *RoutesFile, tags []string) *RoutesFile {
+	if rf == nil || len(tags) == 0 {
+		return rf
+	}
+	return FilterFile(rf, func(g RouteGroup) bool { return g.HasAnyTag(tags) })
+}
+
+// DuplicateWarning reports a host listed in two groups. Only the route from Group1 is kept.
+type DuplicateWarning struct {
+	Host   string
+	Group1 string
+	Group2 string
+}
+
+func (w DuplicateWarning) String() string {
+	return fmt.Sprintf("host %s is in groups %s and %s; keeping the route from %s", w.Host, w.Group1, w.Group2, w.Group1)
+}
+
 // FlattenToEntries converts RoutesFile to a slice of Route (one per host), normalizing hosts.
-func FlattenToEntries(rf *RoutesFile) ([]Route, error) {
+// Disabled groups are skipped. If filterTags are given, only groups with a matching tag are used.
+// A host listed in several groups is kept once, from the first group, and reported in the warnings.
+func FlattenToEntries(rf *RoutesFile, filterTags ...string) ([]Route, []DuplicateWarning, error) {
+	rf = FilterByTags(rf, filterTags)
 	if rf == nil || len(rf.Routes) == 0 {
-		return nil, nil
+		return nil, nil, nil
+	}
+	if err := validateRoutesFile(rf); err != nil {
+		return nil, nil, err
 	}
 	var out []Route
-	for _, g := range rf.Routes {
-		if len(g.Hosts) == 0 {
+	var warnings []DuplicateWarning
+	firstGroup := make(map[string]string)
+	for i, g := range rf.Routes {
+		if len(g.Hosts) == 0 || !g.IsEnabled() {
 			continue
 		}
-		hasGW := g.Gateway != ""
-		hasIface := g.Interface != ""
-		if hasGW == hasIface {
-			return nil, fmt.Errorf("group %q: set exactly one of gateway or interface", g.Comment)
-		}
-		for _, h := range g.Hosts {
-			norm, err := normalizeHost(h)
+		label := groupLabel(&g, i)
+		for _, e := range g.Hosts {
+			h := e.Host
+			comment := g.Comment
+			if e.Comment != "" {
+				comment = e.Comment
+			}
+			expanded, err := expandHostRange(h)
 			if err != nil {
-				return nil, fmt.Errorf("group %q host %q: %w", g.Comment, h, err)
+				return nil, nil, validationErrorf("group %q host %q: %w", g.Comment, h, err)
+			}
+			for _, host := range expanded {
+				norm, err := parseHost(host)
+				if err != nil {
+					return nil, nil, validationErrorf("group %q host %q: %w", g.Comment, h, err)
+				}
+				if first, exists := firstGroup[norm]; exists {
+					warnings = append(warnings, DuplicateWarning{Host: norm, Group1: first, Group2: label})
+					continue
+				}
+				firstGroup[norm] = label
+				out = append(out, Route{
+					Host:      norm,
+					Comment:   comment,
+					Gateway:   g.Gateway,
+					Interface: g.Interface,
+					Auto:      g.Auto,
+					Reject:    g.Reject,
+				})
 			}
-			out = append(out, Route{
-				Host:      norm,
-				Comment:   g.Comment,
-				Gateway:   g.Gateway,
-				Interface: g.Interface,
-				Auto:      g.Auto,
-				Reject:    g.Reject,
-			})
 		}
 	}
-	return out, nil
+	return out, warnings, nil
 }
@@ -1,9 +1,14 @@
 package routes
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNormalizeHost(t *testing.T) {
@@ -25,7 +30,7 @@ func TestNormalizeHost(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := normalizeHost(tt.input)
+			got, err := normalizeHost(tt.input, nil)
 			if tt.wantErr {
 				if err == nil {
 					t.Fatalf("expected error, got nil")
@@ -42,6 +47,175 @@ func TestNormalizeHost(t *testing.T) {
 	}
 }
 
+func TestNormalizeHost_HostBitsWarning(t *testing.T) {
+	var warnings []string
+	got, err := normalizeHost("10.0.0.5/24", &warnings)
+	if err != nil {
+		t.Fatalf("normalizeHost: %v", err)
+	}
+	if got != "10.0.0.0/24" {
+		t.Fatalf("got %q, want %q", got, "10.0.0.0/24")
+	}
+	if len(warnings) != 1 || warnings[0] != "normalizing 10.0.0.5/24 to 10.0.0.0/24" {
+		t.Fatalf("warnings: got %q", warnings)
+	}
+
+	warnings = nil
+	if _, err := normalizeHost("10.0.0.0/24", &warnings); err != nil {
+		t.Fatalf("normalizeHost: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warning for network address: %q", warnings)
+	}
+}
+
+func TestParse_HostBitsWarnings(t *testing.T) {
+	const want = "normalizing 10.0.0.5/24 to 10.0.0.0/24"
+	parsers := map[string]func() (*RoutesFile, error){
+		"yaml": func() (*RoutesFile, error) {
+			return ParseYAML([]byte("routes:\n  - gateway: 10.0.0.1\n    hosts:\n      - 10.0.0.5/24\n      - 1.1.1.1\n"))
+		},
+		"json": func() (*RoutesFile, error) {
+			return ParseJSON([]byte(`[{"host": "10.0.0.5/24", "gateway": "10.0.0.1"}, {"host": "1.1.1.1", "gateway": "10.0.0.1"}]`))
+		},
+		"text": func() (*RoutesFile, error) {
+			return ParseText([]byte("10.0.0.5/24\n1.1.1.1\n"), "10.0.0.1", "", "")
+		},
+	}
+	for name, parse := range parsers {
+		t.Run(name, func(t *testing.T) {
+			rf, err := parse()
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if len(rf.Warnings) != 1 || rf.Warnings[0] != want {
+				t.Fatalf("warnings: got %q, want [%q]", rf.Warnings, want)
+			}
+		})
+	}
+}
+
+func TestExpandHostRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantFirst string
+		wantLast  string
+		wantLen   int
+		wantErr   bool
+	}{
+		{name: "single_host", input: "8.8.8.8", wantFirst: "8.8.8.8", wantLast: "8.8.8.8", wantLen: 1},
+		{name: "small_range", input: "10.0.0.1-10.0.0.3", wantFirst: "10.0.0.1", wantLast: "10.0.0.3", wantLen: 3},
+		{name: "spaces", input: "10.0.0.1 - 10.0.0.2", wantFirst: "10.0.0.1", wantLast: "10.0.0.2", wantLen: 2},
+		{name: "across_octet", input: "10.0.0.250-10.0.1.5", wantFirst: "10.0.0.250", wantLast: "10.0.1.5", wantLen: 12},
+		{name: "max_size", input: "10.0.0.0-10.0.0.255", wantFirst: "10.0.0.0", wantLast: "10.0.0.255", wantLen: 256},
+		{name: "too_large", input: "10.0.0.0-10.0.1.0", wantErr: true},
+		{name: "reversed", input: "10.0.0.5-10.0.0.1", wantErr: true},
+		{name: "ipv6", input: "2001:db8::1-2001:db8::5", wantErr: true},
+		{name: "invalid", input: "10.0.0.1-foo", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandHostRange(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %d hosts", len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != tt.wantLen || got[0] != tt.wantFirst || got[len(got)-1] != tt.wantLast {
+				t.Fatalf("got %d hosts %q..%q, want %d hosts %q..%q", len(got), got[0], got[len(got)-1], tt.wantLen, tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestValidateRouteGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		group   RouteGroup
+		wantErr string
+	}{
+		{name: "ok", group: RouteGroup{Gateway: "10.0.0.1", Hosts: NewHostList("8.8.8.8", "10.0.0.0/8"), Domains: []string{"example.com"}}},
+		{name: "empty_group", group: RouteGroup{Comment: "empty"}},
+		{name: "empty_domain", group: RouteGroup{Gateway: "10.0.0.1", Domains: []string{"example.com", "  "}}, wantErr: "empty domain"},
+		{name: "invalid_host", group: RouteGroup{Gateway: "10.0.0.1", Hosts: NewHostList("not-an-ip")}, wantErr: "not-an-ip"},
+		{name: "duplicate_host", group: RouteGroup{Gateway: "10.0.0.1", Hosts: NewHostList("8.8.8.8", " 8.8.8.8")}, wantErr: "duplicate host 8.8.8.8"},
+		{name: "duplicate_in_range", group: RouteGroup{Gateway: "10.0.0.1", Hosts: NewHostList("10.0.0.2", "10.0.0.1-10.0.0.3")}, wantErr: "duplicate host 10.0.0.2"},
+		{name: "no_target", group: RouteGroup{Domains: []string{"example.com"}}, wantErr: "exactly one of gateway or interface"},
+		{name: "truncated_gateway", group: RouteGroup{Comment: "vpn", Gateway: "10.0.0.", Hosts: NewHostList("8.8.8.8")}, wantErr: `group "vpn": gateway "10.0.0."`},
+		{name: "blank_interface", group: RouteGroup{Interface: "  ", Hosts: NewHostList("8.8.8.8")}, wantErr: "exactly one of gateway or interface"},
+		{name: "both_targets", group: RouteGroup{Gateway: "10.0.0.1", Interface: "Wireguard0", Hosts: NewHostList("8.8.8.8")}, wantErr: "exactly one of gateway or interface"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRouteGroup(tt.group, 0)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) || verr.Code != CodeInvalidRoute {
+				t.Fatalf("expected ValidationError with code %s, got %#v", CodeInvalidRoute, err)
+			}
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "vpn", Gateway: "10.0.0.", Hosts: NewHostList("8.8.8.8", "8.8.8.8", "bad", "")},
+		{Domains: []string{"example.com", " "}},
+		{Interface: "Wireguard0", Hosts: NewHostList(" 8.8.8.8")},
+	}}
+	var got []string
+	for _, issue := range Lint(rf) {
+		got = append(got, issue.String())
+	}
+	want := []string{
+		`error: group "vpn": gateway "10.0.0." is not a valid IP address`,
+		`warning: group "vpn": duplicate host 8.8.8.8`,
+		`error: group "vpn": host "bad": invalid IP`,
+		`error: group "vpn": empty host entry`,
+		`error: group #2: set exactly one of gateway or interface`,
+		`error: group #2: empty domain entry`,
+		`warning: group #3: host 8.8.8.8 is also in group "vpn"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected issues:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestFixFile(t *testing.T) {
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: " vpn ", Gateway: "10.0.0.1", Hosts: NewHostList("8.8.8.8", "10.0.0.5/24", " 8.8.8.8", "", "1.1.1.1", "10.0.0.0/24")},
+	}}
+	sum := FixFile(rf)
+	if sum != (FixSummary{Duplicates: 2, CIDRs: 1, Trimmed: 2, Empty: 1, Sorted: 1}) {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+	if got := sum.String(); got != "Fixed 2 duplicate hosts, normalized 1 CIDRs, trimmed 2 fields, removed 1 empty hosts, sorted hosts of 1 groups." {
+		t.Fatalf("unexpected summary text: %q", got)
+	}
+	g := rf.Routes[0]
+	if got := strings.Join(g.Hosts.Strings(), ","); g.Comment != "vpn" || got != "1.1.1.1,10.0.0.0/24,8.8.8.8" {
+		t.Fatalf("unexpected group: %q %s", g.Comment, got)
+	}
+	if got := FixFile(rf).String(); got != "Nothing to fix." {
+		t.Fatalf("second pass: %q", got)
+	}
+}
+
 func TestLoadYAML_MissingFile(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "missing.yaml")
@@ -57,6 +231,47 @@ func TestLoadYAML_MissingFile(t *testing.T) {
 	}
 }
 
+func TestLoadYAML_Include(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+	write("regions/eu.yaml", "routes:\n  - comment: eu\n    gateway: 10.0.0.2\n    hosts: [2.2.2.2]\n  - !include ../common.yaml\n")
+	write("common.yaml", "routes:\n  - comment: common\n    gateway: 10.0.0.3\n    hosts: [3.3.3.3]\n")
+	main := write("main.yaml", "routes:\n  - comment: main\n    gateway: 10.0.0.1\n    hosts: [1.1.1.1]\n  - !include regions/eu.yaml\n")
+
+	rf, err := LoadYAML(main)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	var comments []string
+	for _, g := range rf.Routes {
+		comments = append(comments, g.Comment)
+	}
+	if got := strings.Join(comments, ","); got != "main,eu,common" {
+		t.Fatalf("groups: got %s", got)
+	}
+	if len(rf.Includes) != 2 || filepath.Base(rf.Includes[0]) != "eu.yaml" || filepath.Base(rf.Includes[1]) != "common.yaml" {
+		t.Fatalf("includes: got %v", rf.Includes)
+	}
+
+	loop := write("loop.yaml", "routes:\n  - !include loop.yaml\n")
+	if _, err := LoadYAML(loop); err == nil || !strings.Contains(err.Error(), "circular include") {
+		t.Fatalf("expected circular include error, got %v", err)
+	}
+	missing := write("missing.yaml", "routes:\n  - !include nope.yaml\n")
+	if _, err := LoadYAML(missing); err == nil || !strings.Contains(err.Error(), "include nope.yaml") {
+		t.Fatalf("expected missing include error, got %v", err)
+	}
+}
+
 func TestLoadYAML_RoundTrip(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "routes.yaml")
@@ -66,7 +281,7 @@ func TestLoadYAML_RoundTrip(t *testing.T) {
 				Comment: "test",
 				Gateway: "192.168.1.1",
 				Auto:    true,
-				Hosts:   []string{"8.8.8.8"},
+				Hosts:   NewHostList("8.8.8.8"),
 			},
 		},
 	}
@@ -82,6 +297,52 @@ func TestLoadYAML_RoundTrip(t *testing.T) {
 	}
 }
 
+func TestSaveYAML_Metadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	rf := &RoutesFile{Metadata: &Metadata{Version: "v1.2"}, Routes: []RouteGroup{}}
+	if err := SaveYAML(path, rf); err != nil {
+		t.Fatalf("SaveYAML: %v", err)
+	}
+	if !rf.Metadata.CreatedAt.IsZero() {
+		t.Fatalf("SaveYAML changed the caller's metadata: %+v", rf.Metadata)
+	}
+	loaded, err := LoadYAML(path)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if loaded.Metadata == nil || loaded.Metadata.Version != "v1.2" || loaded.Metadata.CreatedAt.IsZero() {
+		t.Fatalf("unexpected metadata: %+v", loaded.Metadata)
+	}
+
+	created := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	loaded.Metadata.CreatedAt = created
+	if err := SaveYAML(path, loaded); err != nil {
+		t.Fatalf("SaveYAML: %v", err)
+	}
+	loaded, err = LoadYAML(path)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	if !loaded.Metadata.CreatedAt.Equal(created) {
+		t.Fatalf("created_at was overwritten: %v", loaded.Metadata.CreatedAt)
+	}
+}
+
+func TestSaveYAML_NoMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	rf := &RoutesFile{Routes: []RouteGroup{{Gateway: "10.0.0.1", Hosts: NewHostList("1.1.1.1")}}}
+	if err := SaveYAML(path, rf); err != nil {
+		t.Fatalf("SaveYAML: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if strings.Contains(string(data), "metadata") {
+		t.Fatalf("metadata added to a file without it:\n%s", data)
+	}
+}
+
 func TestSaveYAML_CreatesDirs(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "nested", "routes.yaml")
@@ -93,3 +354,236 @@ func TestSaveYAML_CreatesDirs(t *testing.T) {
 		t.Fatalf("stat saved file: %v", err)
 	}
 }
+
+func TestHostList_PerHostComments(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "routes.yaml")
+	content := `routes:
+  - comment: group
+    gateway: 10.0.0.1
+    hosts:
+      - 8.8.8.8
+      - host: 1.1.1.1
+        comment: cloudflare
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	rf, err := LoadYAML(path)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	entries, _, err := FlattenToEntries(rf)
+	if err != nil {
+		t.Fatalf("FlattenToEntries: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Comment != "group" || entries[1].Comment != "cloudflare" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	if err := SaveYAML(path, rf); err != nil {
+		t.Fatalf("SaveYAML: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if !strings.Contains(string(data), "- 8.8.8.8\n") || !strings.Contains(string(data), "- host: 1.1.1.1\n") {
+		t.Fatalf("unexpected YAML:\n%s", data)
+	}
+}
+
+func TestFlattenToEntries_SkipsDisabledGroups(t *testing.T) {
+	disabled, enabled := false, true
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "default", Gateway: "10.0.0.1", Hosts: NewHostList("1.1.1.1")},
+		{Comment: "off", Gateway: "10.0.0.1", Hosts: NewHostList("2.2.2.2"), Enabled: &disabled},
+		{Comment: "on", Gateway: "10.0.0.1", Hosts: NewHostList("3.3.3.3"), Enabled: &enabled},
+	}}
+	entries, _, err := FlattenToEntries(rf)
+	if err != nil {
+		t.Fatalf("FlattenToEntries: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Host != "1.1.1.1" || entries[1].Host != "3.3.3.3" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestFilterByTags(t *testing.T) {
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "a", Gateway: "10.0.0.1", Hosts: NewHostList("1.1.1.1"), Tags: []string{"vpn-a"}},
+		{Comment: "b", Gateway: "10.0.0.1", Hosts: NewHostList("2.2.2.2"), Tags: []string{"vpn-b", "work"}},
+		{Comment: "untagged", Gateway: "10.0.0.1", Hosts: NewHostList("3.3.3.3")},
+	}}
+	if got := FilterByTags(rf, nil); got != rf {
+		t.Fatalf("expected unchanged file without tags")
+	}
+	got := FilterByTags(rf, []string{"work", "vpn-a"})
+	if len(got.Routes) != 2 || got.Routes[0].Comment != "a" || got.Routes[1].Comment != "b" {
+		t.Fatalf("unexpected groups: %+v", got.Routes)
+	}
+	entries, _, err := FlattenToEntries(rf, "vpn-b")
+	if err != nil {
+		t.Fatalf("FlattenToEntries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Host != "2.2.2.2" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestFlattenToEntries_CrossGroupDuplicates(t *testing.T) {
+	rf := &RoutesFile{Routes: []RouteGroup{
+		{Comment: "a", Gateway: "10.0.0.1", Hosts: NewHostList("1.1.1.1", "2.2.2.2")},
+		{Comment: "b", Interface: "Wireguard0", Hosts: NewHostList("2.2.2.2", "3.3.3.3")},
+	}}
+	entries, warnings, err := FlattenToEntries(rf)
+	if err != nil {
+		t.Fatalf("FlattenToEntries: %v", err)
+	}
+	if len(entries) != 3 || entries[1].Host != "2.2.2.2" || entries[1].Gateway != "10.0.0.1" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	want := DuplicateWarning{Host: "2.2.2.2", Group1: `"a"`, Group2: `"b"`}
+	if len(warnings) != 1 || warnings[0] != want {
+		t.Fatalf("warnings: got %+v, want %+v", warnings, want)
+	}
+}
+
+func TestLoadText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.txt")
+	content := "# blocklist\n8.8.8.8\n\n  10.0.0.0/8  \n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	rf, err := LoadText(path, "192.168.1.1", "", "corp")
+	if err != nil {
+		t.Fatalf("LoadText: %v", err)
+	}
+	if len(rf.Routes) != 1 {
+		t.Fatalf("expected one group, got %d", len(rf.Routes))
+	}
+	g := rf.Routes[0]
+	if g.Gateway != "192.168.1.1" || g.Comment != "corp" || strings.Join(g.Hosts.Strings(), ",") != "8.8.8.8,10.0.0.0/8" {
+		t.Fatalf("unexpected group: %+v", g)
+	}
+
+	if err := os.WriteFile(path, []byte("8.8.8.8\nbad\n"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if _, err := LoadText(path, "192.168.1.1", "", ""); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected line 2 error, got %v", err)
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []RouteGroup
+		wantErr string
+	}{
+		{
+			name: "grouped",
+			content: `destination,gateway,interface,comment
+8.8.8.8,10.0.0.1,,dns
+"10.0.0.0,255.0.0.0",,Wireguard0,vpn
+192.168.0.0/16,,Wireguard0,vpn
+1.1.1.1,10.0.0.1,,dns
+`,
+			want: []RouteGroup{
+				{Comment: "dns", Gateway: "10.0.0.1", Hosts: NewHostList("8.8.8.8", "1.1.1.1")},
+				{Comment: "vpn", Interface: "Wireguard0", Hosts: NewHostList("10.0.0.0/8", "192.168.0.0/16")},
+			},
+		},
+		{name: "destination_only", content: "Destination\n8.8.8.8\n", want: []RouteGroup{{Hosts: NewHostList("8.8.8.8")}}},
+		{name: "missing_destination", content: "gateway\n10.0.0.1\n", wantErr: "destination"},
+		{name: "bad_row", content: "destination,gateway\n8.8.8.8,10.0.0.1\nnope,10.0.0.1\n", wantErr: "line 3"},
+		{name: "bad_mask", content: "destination\n\"10.0.0.0,255.0.255.0\"\n", wantErr: "line 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "routes.csv")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+			rf, err := LoadCSV(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadCSV: %v", err)
+			}
+			if fmt.Sprint(rf.Routes) != fmt.Sprint(tt.want) {
+				t.Fatalf("got %+v, want %+v", rf.Routes, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "routes.json")
+	content := `[
+  {"host": "8.8.8.8", "gateway": "10.0.0.1", "comment": "dns"},
+  {"host": "10.0.0.0/8", "interface": "Wireguard0"},
+  {"host": "1.1.1.1", "gateway": "10.0.0.1", "comment": "dns"}
+]`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	rf, err := LoadJSON(path)
+	if err != nil {
+		t.Fatalf("LoadJSON: %v", err)
+	}
+	want := []RouteGroup{
+		{Comment: "dns", Gateway: "10.0.0.1", Hosts: NewHostList("8.8.8.8", "1.1.1.1")},
+		{Interface: "Wireguard0", Hosts: NewHostList("10.0.0.0/8")},
+	}
+	if fmt.Sprint(rf.Routes) != fmt.Sprint(want) {
+		t.Fatalf("got %+v, want %+v", rf.Routes, want)
+	}
+}
+
+func TestSaveJSON_RoundTripFromYAML(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "routes.yaml")
+	content := `routes:
+  - comment: group
+    gateway: 10.0.0.1
+    auto: true
+    enabled: false
+    tags: [vpn-a]
+    hosts:
+      - 8.8.8.8
+      - host: 1.1.1.1
+        comment: cloudflare
+    domains:
+      - example.com
+  - interface: Wireguard0
+    reject: true
+    hosts:
+      - 10.0.0.0/8
+`
+	if err := os.WriteFile(yamlPath, []byte(content), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	fromYAML, err := LoadYAML(yamlPath)
+	if err != nil {
+		t.Fatalf("LoadYAML: %v", err)
+	}
+	jsonPath := filepath.Join(dir, "routes.json")
+	if err := SaveJSON(jsonPath, fromYAML); err != nil {
+		t.Fatalf("SaveJSON: %v", err)
+	}
+	fromJSON, err := LoadJSON(jsonPath)
+	if err != nil {
+		t.Fatalf("LoadJSON: %v", err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", fromJSON, fromYAML)
+	}
+}
@@ -25,7 +25,7 @@ type RouteView interface {
 // Returns empty string if the destination is missing or invalid.
 func RouteDest(r RouteView) string {
 	if h := r.HostValue(); h != "" {
-		if !isIPOrCIDR(h) {
+		if !isValidIPOrCIDR(h) {
 			return ""
 		}
 		return h
@@ -67,7 +67,8 @@ func RouteDest(r RouteView) string {
 	return fmt.Sprintf("%s/%d", network, ones)
 }
 
-func isIPOrCIDR(s string) bool {
+// isValidIPOrCIDR reports whether s is an IPv4 or IPv6 address or CIDR.
+func isValidIPOrCIDR(s string) bool {
 	if strings.Contains(s, "/") {
 		ip, _, err := net.ParseCIDR(s)
 		if err != nil {
@@ -78,3 +79,10 @@ func isIPOrCIDR(s string) bool {
 	ip := net.ParseIP(s)
 	return ip != nil
 }
+
+// IsIPv6 reports whether host (an address or CIDR) is IPv6.
+func IsIPv6(host string) bool {
+	addr, _, _ := strings.Cut(host, "/")
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() == nil
+}